@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+// FileName is the name of the config file in the config directory
+const FileName = "config.json"
+
+var (
+	// ErrUnknownSetting is returned for a setting name that was never registered
+	ErrUnknownSetting = errors.New("unknown setting")
+	// ErrNotInFile is returned when setting a value in the config file for a setting that is read before the file is loaded
+	ErrNotInFile = errors.New("setting can not be stored in the config file")
+	// ErrInvalidValue is returned when the value does not fit the setting
+	ErrInvalidValue = errors.New("invalid setting value")
+)
+
+// Sources of a setting value, from the highest precedence to the lowest
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "config"
+	SourceDefault = "default"
+)
+
+// Setting is a value that can be given as a global flag --<Name>, through the Env environment variable,
+// in the config file, or left to its Default, in this order of precedence
+type Setting struct {
+	Name        string
+	Env         string
+	Default     string
+	Description string
+	IsBool      bool
+	// NotInFile settings are needed to find the config file, so they can not be stored in it
+	NotInFile bool
+	// Values are the accepted values, any value is accepted if empty
+	Values []string
+	// Check validates values that do not fit in Values, like lists
+	Check func(value string) error
+}
+
+var (
+	lock       sync.RWMutex
+	settings   = map[string]Setting{}
+	flagValues = map[string]string{}
+	fileValues = map[string]string{}
+)
+
+// Register adds a setting. Packages register the settings they read in their init
+func Register(setting Setting) {
+	lock.Lock()
+	defer lock.Unlock()
+	settings[setting.Name] = setting
+}
+
+// Lookup returns the setting with the name
+func Lookup(name string) (Setting, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	setting, ok := settings[name]
+	return setting, ok
+}
+
+// Settings returns all the registered settings, sorted by name
+func Settings() []Setting {
+	lock.RLock()
+	defer lock.RUnlock()
+	all := []Setting{}
+	for _, setting := range settings {
+		all = append(all, setting)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+func filePath() string {
+	return path.Join(paths.ConfigDir, FileName)
+}
+
+// Load reads the config file. A missing file is the same as an empty one
+func Load() error {
+	content, readErr := ioutil.ReadFile(filePath())
+	values := map[string]string{}
+	if readErr != nil {
+		if !os.IsNotExist(readErr) {
+			return readErr
+		}
+	} else if jsonErr := json.Unmarshal(content, &values); jsonErr != nil {
+		return fmt.Errorf("invalid config file %s: %w", filePath(), jsonErr)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	fileValues = values
+	return nil
+}
+
+func save() error {
+	content, jsonErr := json.MarshalIndent(fileValues, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if mkdirErr := os.MkdirAll(paths.ConfigDir, os.ModePerm); mkdirErr != nil {
+		return mkdirErr
+	}
+	// written next to the config file and renamed, so an interrupted save does not leave a truncated config
+	if writeErr := ioutil.WriteFile(filePath()+".tmp", content, 0644); writeErr != nil {
+		os.Remove(filePath() + ".tmp")
+		return writeErr
+	}
+	return os.Rename(filePath()+".tmp", filePath())
+}
+
+// Validate checks that the value can be used for the setting
+func Validate(name string, value string) error {
+	setting, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, name)
+	}
+	if setting.IsBool {
+		if _, parseErr := strconv.ParseBool(value); parseErr != nil {
+			return fmt.Errorf("%w: %s must be true or false", ErrInvalidValue, name)
+		}
+	}
+	if len(setting.Values) > 0 {
+		for _, allowed := range setting.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s must be one of %v", ErrInvalidValue, name, setting.Values)
+	}
+	if setting.Check != nil {
+		if checkErr := setting.Check(value); checkErr != nil {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidValue, name, checkErr.Error())
+		}
+	}
+	return nil
+}
+
+// SetFlag stores the value given as a flag, which overrides all the other sources
+func SetFlag(name string, value string) {
+	lock.Lock()
+	defer lock.Unlock()
+	flagValues[name] = value
+}
+
+// Value returns the value of the setting and where it comes from
+func Value(name string) (string, string) {
+	lock.RLock()
+	defer lock.RUnlock()
+	setting := settings[name]
+	if value, ok := flagValues[name]; ok {
+		return value, SourceFlag
+	}
+	if setting.Env != "" {
+		if value, ok := os.LookupEnv(setting.Env); ok {
+			return value, SourceEnv
+		}
+	}
+	if value, ok := fileValues[name]; ok && !setting.NotInFile {
+		return value, SourceFile
+	}
+	return setting.Default, SourceDefault
+}
+
+// Get returns the value of the setting
+func Get(name string) string {
+	value, _ := Value(name)
+	return value
+}
+
+// GetBool returns the value of a boolean setting, false if it is not a valid boolean
+func GetBool(name string) bool {
+	value, parseErr := strconv.ParseBool(Get(name))
+	return parseErr == nil && value
+}
+
+// GetInt returns the value of a numeric setting, the default if it is not a valid number
+func GetInt(name string) int {
+	value, parseErr := strconv.Atoi(Get(name))
+	if parseErr != nil {
+		setting, _ := Lookup(name)
+		value, _ = strconv.Atoi(setting.Default)
+	}
+	return value
+}
+
+// FileValue returns the value stored in the config file, if any
+func FileValue(name string) (string, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	value, ok := fileValues[name]
+	return value, ok
+}
+
+// Set stores the value in the config file
+func Set(name string, value string) error {
+	if validateErr := Validate(name, value); validateErr != nil {
+		return validateErr
+	}
+	setting, _ := Lookup(name)
+	if setting.NotInFile {
+		return fmt.Errorf("%w: %s", ErrNotInFile, name)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	fileValues[name] = value
+	return save()
+}
+
+// Unset removes the value from the config file
+func Unset(name string) error {
+	_, registered := Lookup(name)
+	if _, inFile := FileValue(name); !registered && !inFile {
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, name)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	delete(fileValues, name)
+	return save()
+}
@@ -0,0 +1,558 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/operations"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/satisfactoryinstall"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/smlhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+// DefaultAddress is the address the API listens on when no other one is given. Only local clients can connect
+const DefaultAddress = "127.0.0.1:8642"
+
+// TokenFileName is the file in the launcher data directory holding the token the clients must send as "Authorization: Bearer <token>".
+// Web pages can not read it, so they can not make requests to the API
+const TokenFileName = "daemon-token"
+
+const defaultSearchLimit = 20
+
+var (
+	// ErrInvalidRequest is returned when the request is missing parameters or has invalid ones
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrMethodNotAllowed is returned when the endpoint does not support the request method
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	// ErrForbidden is returned when the request is not addressed to localhost or comes from a web page of another host
+	ErrForbidden = errors.New("forbidden")
+	// ErrUnauthorized is returned when the request does not have the token of the server
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnsupportedMediaType is returned when the body of a POST request is not JSON
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrNotLoopback is returned when the API would listen on an address that other computers can connect to
+	ErrNotLoopback = errors.New("not a loopback address")
+)
+
+// ErrorCode returns the code sent to the clients for the error
+type ErrorCode func(err error) string
+
+type modRequest struct {
+	ModID   string `json:"mod_id"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Install string `json:"install"`
+	// Cascade uninstalls the mods that depend on the mod too
+	Cascade bool `json:"cascade"`
+	// WithOptional downloads or installs the optional dependencies too
+	WithOptional bool `json:"with_optional"`
+}
+
+type smlRequest struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Install string `json:"install"`
+}
+
+// Server is the HTTP API of the launcher. Operations that read or change the mods directory or a Satisfactory install run one at a time
+type Server struct {
+	errorCode ErrorCode
+	token     string
+	lock      sync.Mutex
+	mux       *http.ServeMux
+}
+
+// TokenPath returns the path of the file holding the token of the server
+func TokenPath() string {
+	return path.Join(paths.SMLauncherDir, TokenFileName)
+}
+
+// newToken generates a random token and writes it to the token file, readable only by the user
+func newToken() (string, error) {
+	tokenData := make([]byte, 32)
+	if _, randErr := rand.Read(tokenData); randErr != nil {
+		return "", randErr
+	}
+	token := hex.EncodeToString(tokenData)
+	if mkdirErr := os.MkdirAll(paths.SMLauncherDir, 0755); mkdirErr != nil {
+		return "", mkdirErr
+	}
+	// remove the token of a previous server first, the permissions of an existing file are not changed by writing it
+	if removeErr := os.Remove(TokenPath()); removeErr != nil && !os.IsNotExist(removeErr) {
+		return "", removeErr
+	}
+	if writeErr := ioutil.WriteFile(TokenPath(), []byte(token), 0600); writeErr != nil {
+		return "", writeErr
+	}
+	return token, nil
+}
+
+// New creates the API server, using errorCode to tell the clients what kind of error happened.
+// It writes a new token to the token file, so the clients of a previous server have to read it again
+func New(errorCode ErrorCode) (*Server, error) {
+	token, tokenErr := newToken()
+	if tokenErr != nil {
+		return nil, fmt.Errorf("failed to write the API token: %w", tokenErr)
+	}
+	server := &Server{errorCode: errorCode, token: token, mux: http.NewServeMux()}
+	server.handle("/api/mods", http.MethodGet, true, server.listMods)
+	server.handle("/api/mods/versions", http.MethodGet, true, server.listModVersions)
+	server.handle("/api/mods/download", http.MethodPost, true, server.downloadMod)
+	server.handle("/api/mods/remove", http.MethodPost, true, server.removeMod)
+	server.handle("/api/mods/update", http.MethodPost, true, server.updateMod)
+	server.handle("/api/mods/updates", http.MethodGet, true, server.checkUpdates)
+	server.handle("/api/mods/deps", http.MethodGet, true, server.dependencyTree)
+	server.handle("/api/installed", http.MethodGet, true, server.listInstalled)
+	server.handle("/api/installed/install", http.MethodPost, true, server.installMod)
+	server.handle("/api/installed/uninstall", http.MethodPost, true, server.uninstallMod)
+	server.handle("/api/installed/autoremove", http.MethodPost, true, server.autoRemove)
+	server.handle("/api/installed/why", http.MethodGet, true, server.why)
+	server.handle("/api/sml", http.MethodGet, true, server.smlVersion)
+	server.handle("/api/sml/install", http.MethodPost, true, server.installSML)
+	server.handle("/api/sml/update", http.MethodPost, true, server.updateSML)
+	server.handle("/api/sml/uninstall", http.MethodPost, true, server.uninstallSML)
+	server.handle("/api/installs", http.MethodGet, false, server.findInstalls)
+	server.handle("/api/installs/registered", http.MethodGet, false, server.registeredInstalls)
+	server.handle("/api/ficsitapp/search", http.MethodGet, false, server.search)
+	server.handle("/api/ficsitapp/info", http.MethodGet, false, server.info)
+	return server, nil
+}
+
+// isLoopbackHost returns true if the host, with or without a port, is localhost or a loopback address
+func isLoopbackHost(host string) bool {
+	if hostname, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// CheckAddress returns an error if the API would listen on the address for other clients than the local ones
+func CheckAddress(address string) error {
+	host, _, splitErr := net.SplitHostPort(address)
+	if splitErr != nil {
+		return fmt.Errorf("invalid address %s: %w", address, splitErr)
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("%w: %s, use localhost or a loopback address", ErrNotLoopback, address)
+	}
+	return nil
+}
+
+// checkRequest rejects the requests that web pages could make, either directly or by rebinding their domain to localhost.
+// The Host must be localhost, the Origin, if any, must be on localhost, the token must match, and POST bodies must be JSON
+func (server *Server) checkRequest(r *http.Request) (int, error) {
+	if !isLoopbackHost(r.Host) {
+		return http.StatusForbidden, fmt.Errorf("%w: host %s is not localhost", ErrForbidden, r.Host)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		originURL, parseErr := url.Parse(origin)
+		if parseErr != nil || !isLoopbackHost(originURL.Host) {
+			return http.StatusForbidden, fmt.Errorf("%w: origin %s is not localhost", ErrForbidden, origin)
+		}
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) != 1 {
+		return http.StatusUnauthorized, fmt.Errorf("%w: missing or wrong token, read it from %s", ErrUnauthorized, TokenPath())
+	}
+	if r.Method == http.MethodPost {
+		mediaType, _, parseErr := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if parseErr != nil || mediaType != "application/json" {
+			return http.StatusUnsupportedMediaType, fmt.Errorf("%w: %s, want application/json", ErrUnsupportedMediaType, r.Header.Get("Content-Type"))
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// ServeHTTP handles the API requests
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// operation handles a request, returning the result sent to the client. Progress goes to the reporter of the request
+type operation func(r *http.Request, reporter *util.Reporter) (interface{}, error)
+
+// handle registers the operation. Serialized operations wait for the previous ones to finish.
+// Clients that accept text/event-stream get server-sent events: queued, started, progress for each
+// progress message, download for the progress of each download, then result or error. Other clients get the result as JSON
+func (server *Server) handle(pattern string, method string, serialized bool, run operation) {
+	server.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if status, checkErr := server.checkRequest(r); checkErr != nil {
+			server.writeError(w, status, checkErr)
+			return
+		}
+		if r.Method != method {
+			server.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%w: %s %s", ErrMethodNotAllowed, r.Method, pattern))
+			return
+		}
+		// the body can not be read after the response is started, so read it before streaming events
+		body, readErr := ioutil.ReadAll(r.Body)
+		if readErr != nil {
+			server.writeError(w, http.StatusBadRequest, fmt.Errorf("%w: %s", ErrInvalidRequest, readErr.Error()))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		flusher, canFlush := w.(http.Flusher)
+		if !canFlush || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			if serialized {
+				server.lock.Lock()
+				defer server.lock.Unlock()
+			}
+			result, runErr := run(r, nil)
+			modhandler.SaveIndex()
+			if runErr != nil {
+				server.writeError(w, statusCode(runErr), runErr)
+				return
+			}
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		send := func(event string, data interface{}) {
+			eventData, _ := json.Marshal(data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, eventData)
+			flusher.Flush()
+		}
+		if serialized {
+			send("queued", nil)
+			server.lock.Lock()
+			defer server.lock.Unlock()
+		}
+		reporter := util.NewReporter(func(message string) {
+			send("progress", message)
+		}, func(progress util.DownloadProgress) {
+			send("download", progress)
+		})
+		send("started", nil)
+		result, runErr := run(r, reporter)
+		modhandler.SaveIndex()
+		if runErr != nil {
+			send("error", operations.Error{Code: server.errorCode(runErr), Message: runErr.Error()})
+			return
+		}
+		send("result", result)
+	})
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, satisfactoryinstall.ErrNotBinariesDir), errors.Is(err, satisfactoryinstall.ErrNoDefaultInstall):
+		return http.StatusBadRequest
+	case errors.Is(err, ficsitapp.ErrModNotFound), errors.Is(err, ficsitapp.ErrVersionNotFound),
+		errors.Is(err, modhandler.ErrModNotFound), errors.Is(err, modhandler.ErrVersionNotFound),
+		errors.Is(err, modhandler.ErrModNotInstalled), errors.Is(err, smlhandler.ErrSMLNotInstalled),
+		errors.Is(err, smlhandler.ErrVersionNotFound), errors.Is(err, satisfactoryinstall.ErrInstallNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ficsitapp.ErrNotCached), errors.Is(err, ficsitapp.ErrOffline):
+		return http.StatusServiceUnavailable
+	}
+	var conflictErr *modhandler.ConflictError
+	if errors.As(err, &conflictErr) || errors.Is(err, modhandler.ErrModAlreadyInstalled) || errors.Is(err, modhandler.ErrModRequired) ||
+		errors.Is(err, smlhandler.ErrUpToDate) || errors.Is(err, smlhandler.ErrNewerInstalled) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func (server *Server) writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]interface{}{"error": operations.Error{Code: server.errorCode(err), Message: err.Error()}})
+}
+
+// decodeBody decodes the JSON body of the request, which may be empty
+func decodeBody(r *http.Request, body interface{}) error {
+	data, readErr := ioutil.ReadAll(r.Body)
+	if readErr != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, readErr.Error())
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if decodeErr := json.Unmarshal(data, body); decodeErr != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, decodeErr.Error())
+	}
+	return nil
+}
+
+func requireParam(name string, value string) error {
+	if value == "" {
+		return fmt.Errorf("%w: %s is required", ErrInvalidRequest, name)
+	}
+	return nil
+}
+
+// queryInstallPath returns the Satisfactory install selected by the path or install query parameters, or the default install
+func queryInstallPath(r *http.Request) (string, error) {
+	return satisfactoryinstall.ResolveInstallPath(r.URL.Query().Get("path"), r.URL.Query().Get("install"))
+}
+
+func (server *Server) listMods(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	return modhandler.GetDownloadedMods()
+}
+
+func (server *Server) listModVersions(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	modID := r.URL.Query().Get("mod_id")
+	if requireErr := requireParam("mod_id", modID); requireErr != nil {
+		return nil, requireErr
+	}
+	versions, getDownloadedErr := modhandler.GetDownloadedModVersions(modID)
+	if getDownloadedErr != nil && !errors.Is(getDownloadedErr, modhandler.ErrModNotFound) {
+		return nil, getDownloadedErr
+	}
+	if versions == nil {
+		versions = []string{}
+	}
+	return versions, nil
+}
+
+func (server *Server) downloadMod(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	var request modRequest
+	if decodeErr := decodeBody(r, &request); decodeErr != nil {
+		return nil, decodeErr
+	}
+	if requireErr := requireParam("mod_id", request.ModID); requireErr != nil {
+		return nil, requireErr
+	}
+	return operations.Download(reporter, request.ModID, request.Version, request.WithOptional)
+}
+
+func (server *Server) removeMod(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	var request modRequest
+	if decodeErr := decodeBody(r, &request); decodeErr != nil {
+		return nil, decodeErr
+	}
+	if requireErr := requireParam("mod_id", request.ModID); requireErr != nil {
+		return nil, requireErr
+	}
+	return operations.Remove(reporter, request.ModID, request.Version, server.errorCode)
+}
+
+func (server *Server) updateMod(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	var request modRequest
+	if decodeErr := decodeBody(r, &request); decodeErr != nil {
+		return nil, decodeErr
+	}
+	if requireErr := requireParam("mod_id", request.ModID); requireErr != nil {
+		return nil, requireErr
+	}
+	return operations.Update(reporter, request.ModID)
+}
+
+func (server *Server) checkUpdates(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	modUpdates, modUpdatesErr := modhandler.CheckForUpdates(reporter, false)
+	if modUpdatesErr != nil {
+		return nil, modUpdatesErr
+	}
+	result := operations.CheckUpdatesResult{Mods: modUpdates}
+	satisfactoryPath, pathErr := queryInstallPath(r)
+	if pathErr != nil && !errors.Is(pathErr, satisfactoryinstall.ErrNoDefaultInstall) {
+		return nil, pathErr
+	}
+	if satisfactoryPath != "" {
+		smlUpdate, smlUpdatesErr := smlhandler.CheckForUpdates(reporter, satisfactoryPath, false)
+		if smlUpdatesErr != nil {
+			return nil, smlUpdatesErr
+		}
+		result.SML = smlUpdate
+	}
+	return result, nil
+}
+
+// dependencyTree uses the installed versions too when an install is given or there is a default one
+func (server *Server) dependencyTree(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	modID := r.URL.Query().Get("mod_id")
+	if requireErr := requireParam("mod_id", modID); requireErr != nil {
+		return nil, requireErr
+	}
+	satisfactoryPath, pathErr := queryInstallPath(r)
+	if errors.Is(pathErr, satisfactoryinstall.ErrNoDefaultInstall) {
+		satisfactoryPath = ""
+	} else if pathErr != nil {
+		return nil, pathErr
+	}
+	return modhandler.GetDependencyTree(modID, r.URL.Query().Get("version"), satisfactoryPath)
+}
+
+func (server *Server) why(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	modID := r.URL.Query().Get("mod_id")
+	if requireErr := requireParam("mod_id", modID); requireErr != nil {
+		return nil, requireErr
+	}
+	satisfactoryPath, pathErr := queryInstallPath(r)
+	if pathErr != nil {
+		return nil, pathErr
+	}
+	return modhandler.GetRequirementPaths(modID, satisfactoryPath)
+}
+
+func (server *Server) listInstalled(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	satisfactoryPath, pathErr := queryInstallPath(r)
+	if pathErr != nil {
+		return nil, pathErr
+	}
+	return modhandler.GetInstalledModsWithState(satisfactoryPath)
+}
+
+// installRequest decodes an install or uninstall request, resolving its install path
+func installRequest(r *http.Request) (modRequest, error) {
+	var request modRequest
+	if decodeErr := decodeBody(r, &request); decodeErr != nil {
+		return request, decodeErr
+	}
+	if requireErr := requireParam("mod_id", request.ModID); requireErr != nil {
+		return request, requireErr
+	}
+	satisfactoryPath, pathErr := satisfactoryinstall.ResolveInstallPath(request.Path, request.Install)
+	if pathErr != nil {
+		return request, pathErr
+	}
+	request.Path = satisfactoryPath
+	return request, nil
+}
+
+func (server *Server) installMod(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	request, requestErr := installRequest(r)
+	if requestErr != nil {
+		return nil, requestErr
+	}
+	return operations.Install(reporter, request.ModID, request.Version, request.Path, request.WithOptional)
+}
+
+func (server *Server) uninstallMod(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	request, requestErr := installRequest(r)
+	if requestErr != nil {
+		return nil, requestErr
+	}
+	return operations.Uninstall(reporter, request.ModID, request.Version, request.Path, request.Cascade)
+}
+
+func (server *Server) autoRemove(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	var request modRequest
+	if decodeErr := decodeBody(r, &request); decodeErr != nil {
+		return nil, decodeErr
+	}
+	satisfactoryPath, pathErr := satisfactoryinstall.ResolveInstallPath(request.Path, request.Install)
+	if pathErr != nil {
+		return nil, pathErr
+	}
+	return operations.AutoRemove(reporter, satisfactoryPath)
+}
+
+func (server *Server) smlVersion(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	satisfactoryPath, pathErr := queryInstallPath(r)
+	if pathErr != nil {
+		return nil, pathErr
+	}
+	installedVersion, getInstalledErr := smlhandler.GetInstalledVersion(satisfactoryPath)
+	if getInstalledErr != nil {
+		return nil, getInstalledErr
+	}
+	return operations.VersionResult{Version: installedVersion}, nil
+}
+
+// smlOperation decodes an SML request and runs the operation, returning the installed SML version
+func smlOperation(r *http.Request, run func(request smlRequest) error) (interface{}, error) {
+	var request smlRequest
+	if decodeErr := decodeBody(r, &request); decodeErr != nil {
+		return nil, decodeErr
+	}
+	satisfactoryPath, pathErr := satisfactoryinstall.ResolveInstallPath(request.Path, request.Install)
+	if pathErr != nil {
+		return nil, pathErr
+	}
+	request.Path = satisfactoryPath
+	if runErr := run(request); runErr != nil {
+		return nil, runErr
+	}
+	installedVersion, getInstalledErr := smlhandler.GetInstalledVersion(request.Path)
+	if getInstalledErr != nil && !errors.Is(getInstalledErr, smlhandler.ErrSMLNotInstalled) {
+		return nil, getInstalledErr
+	}
+	return operations.VersionResult{Version: installedVersion}, nil
+}
+
+func (server *Server) installSML(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	return smlOperation(r, func(request smlRequest) error {
+		if request.Version == "" {
+			latestSML, getLatestErr := smlhandler.GetLatestSML()
+			if getLatestErr != nil {
+				return getLatestErr
+			}
+			request.Version = latestSML.Version
+		}
+		return smlhandler.InstallSML(reporter, request.Path, request.Version)
+	})
+}
+
+func (server *Server) updateSML(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	return smlOperation(r, func(request smlRequest) error {
+		return smlhandler.UpdateSML(reporter, request.Path)
+	})
+}
+
+func (server *Server) uninstallSML(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	return smlOperation(r, func(request smlRequest) error {
+		return smlhandler.UninstallSML(request.Path)
+	})
+}
+
+func (server *Server) findInstalls(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	return satisfactoryinstall.FindSatisfactoryInstalls(), nil
+}
+
+func (server *Server) registeredInstalls(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	return satisfactoryinstall.ReadRegistry()
+}
+
+func (server *Server) search(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	query := r.URL.Query()
+	if requireErr := requireParam("q", query.Get("q")); requireErr != nil {
+		return nil, requireErr
+	}
+	limit := defaultSearchLimit
+	if query.Get("limit") != "" {
+		var limitErr error
+		limit, limitErr = strconv.Atoi(query.Get("limit"))
+		if limitErr != nil {
+			return nil, fmt.Errorf("%w: limit must be a number", ErrInvalidRequest)
+		}
+	}
+	return ficsitapp.SearchMods(reporter, query.Get("q"), limit)
+}
+
+func (server *Server) info(r *http.Request, reporter *util.Reporter) (interface{}, error) {
+	modID := r.URL.Query().Get("mod_id")
+	if requireErr := requireParam("mod_id", modID); requireErr != nil {
+		return nil, requireErr
+	}
+	return ficsitapp.GetModInfo(reporter, modID)
+}
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+func TestRequestChecks(t *testing.T) {
+	dataDir, tempErr := ioutil.TempDir("", "daemon")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(dataDir)
+	if setErr := paths.SetDataDir(dataDir, false); setErr != nil {
+		t.Fatal(setErr)
+	}
+	server, newErr := New(func(err error) string { return "" })
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	token, readErr := ioutil.ReadFile(TokenPath())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if info, statErr := os.Stat(TokenPath()); statErr != nil {
+		t.Fatal(statErr)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		origin      string
+		token       string
+		contentType string
+		want        int
+	}{
+		{"passes the checks", http.MethodPost, "127.0.0.1:8642", "", string(token), "application/json", http.StatusBadRequest},
+		{"localhost", http.MethodPost, "localhost:8642", "", string(token), "application/json; charset=utf-8", http.StatusBadRequest},
+		{"ipv6 loopback", http.MethodPost, "[::1]:8642", "", string(token), "application/json", http.StatusBadRequest},
+		{"local origin", http.MethodPost, "127.0.0.1:8642", "http://localhost:3000", string(token), "application/json", http.StatusBadRequest},
+		{"wrong method after the checks", http.MethodGet, "127.0.0.1:8642", "", string(token), "", http.StatusMethodNotAllowed},
+		{"rebound host", http.MethodPost, "attacker.example:8642", "", string(token), "application/json", http.StatusForbidden},
+		{"foreign origin", http.MethodPost, "127.0.0.1:8642", "http://attacker.example", string(token), "application/json", http.StatusForbidden},
+		{"opaque origin", http.MethodPost, "127.0.0.1:8642", "null", string(token), "application/json", http.StatusForbidden},
+		{"no token", http.MethodPost, "127.0.0.1:8642", "", "", "application/json", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "127.0.0.1:8642", "", "0123", "application/json", http.StatusUnauthorized},
+		{"form body", http.MethodPost, "127.0.0.1:8642", "", string(token), "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"text body", http.MethodPost, "127.0.0.1:8642", "", string(token), "text/plain", http.StatusUnsupportedMediaType},
+		{"no content type", http.MethodPost, "127.0.0.1:8642", "", string(token), "", http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, "/api/mods/download", strings.NewReader("{}"))
+			request.Host = test.host
+			if test.origin != "" {
+				request.Header.Set("Origin", test.origin)
+			}
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+			if response.Code != test.want {
+				t.Errorf("status = %d, want %d: %s", response.Code, test.want, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    error
+	}{
+		{DefaultAddress, nil},
+		{"localhost:8642", nil},
+		{"[::1]:8642", nil},
+		{"0.0.0.0:8642", ErrNotLoopback},
+		{":8642", ErrNotLoopback},
+		{"192.168.1.2:8642", ErrNotLoopback},
+	}
+	for _, test := range tests {
+		if checkErr := CheckAddress(test.address); !errors.Is(checkErr, test.want) {
+			t.Errorf("CheckAddress(%q) = %v, want %v", test.address, checkErr, test.want)
+		}
+	}
+	if checkErr := CheckAddress("localhost"); checkErr == nil {
+		t.Errorf("CheckAddress of an address without a port succeeded, want an error")
+	}
+}
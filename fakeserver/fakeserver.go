@@ -0,0 +1,328 @@
+package fakeserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+// Dependency of a mod version
+type Dependency struct {
+	ModID     string
+	Condition string
+	Optional  bool
+}
+
+// Version of a fake ficsit.app mod
+type Version struct {
+	Version      string
+	Stability    string
+	Dependencies []Dependency
+	Zip          []byte
+	CreatedAt    time.Time
+	// Hash overrides the published SHA-256 of Zip, to serve corrupted downloads
+	Hash string
+}
+
+// Mod on the fake ficsit.app
+type Mod struct {
+	ID               string
+	Name             string
+	ShortDescription string
+	FullDescription  string
+	Authors          []string
+	Downloads        int
+	Versions         []Version
+	// Error makes the getMod lookups of the mod fail with this GraphQL error
+	Error string
+}
+
+// SMLRelease on the fake GitHub releases API
+type SMLRelease struct {
+	Version     string
+	Description string
+	PublishedAt time.Time
+	DLL         []byte
+}
+
+// Server is an in-process fake of the ficsit.app API and the SML GitHub releases API, serving fixture data
+type Server struct {
+	*httptest.Server
+	lock     sync.Mutex
+	mods     map[string]Mod
+	releases []SMLRelease
+	requests map[string]int
+}
+
+// New starts a fake server with the mods and SML releases. Close it when done
+func New(mods []Mod, releases []SMLRelease) *Server {
+	server := &Server{
+		mods:     map[string]Mod{},
+		releases: releases,
+		requests: map[string]int{},
+	}
+	for _, mod := range mods {
+		server.mods[mod.ID] = mod
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/query", server.handleQuery)
+	mux.HandleFunc("/download/", server.handleModDownload)
+	mux.HandleFunc("/sml/releases", server.handleReleases)
+	mux.HandleFunc("/sml/download/", server.handleSMLDownload)
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+// APIURL is the ficsit.app API URL of the server
+func (server *Server) APIURL() string {
+	return server.URL
+}
+
+// ReleasesURL is the SML GitHub releases API URL of the server
+func (server *Server) ReleasesURL() string {
+	return server.URL + "/sml/releases"
+}
+
+// SetMod adds or replaces a mod
+func (server *Server) SetMod(mod Mod) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.mods[mod.ID] = mod
+}
+
+// Requests returns how many times the path was requested
+func (server *Server) Requests(path string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.requests[path]
+}
+
+func (server *Server) countRequest(path string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.requests[path]++
+}
+
+// ModZip creates a mod zip containing a data.json with the mod ID, version and dependencies
+func ModZip(modID string, version string, dependencies map[string]string, optionalDependencies map[string]string) []byte {
+	dataJSON, _ := json.Marshal(map[string]interface{}{
+		"mod_id":                modID,
+		"name":                  modID,
+		"version":               version,
+		"description":           "",
+		"authors":               []string{},
+		"objects":               []interface{}{},
+		"dependencies":          dependencies,
+		"optional_dependencies": optionalDependencies,
+	})
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	dataFile, _ := zipWriter.Create("data.json")
+	dataFile.Write(dataJSON)
+	zipWriter.Close()
+	return buffer.Bytes()
+}
+
+func downloadLink(modID string, version string) string {
+	return "/download/" + modID + "/" + version
+}
+
+func versionJSON(modID string, version Version) map[string]interface{} {
+	hash := version.Hash
+	if hash == "" {
+		hash = fmt.Sprintf("%x", sha256.Sum256(version.Zip))
+	}
+	dependencies := []map[string]interface{}{}
+	for _, dependency := range version.Dependencies {
+		dependencies = append(dependencies, map[string]interface{}{
+			"mod_id":    dependency.ModID,
+			"condition": dependency.Condition,
+			"optional":  dependency.Optional,
+		})
+	}
+	return map[string]interface{}{
+		"version":      version.Version,
+		"stability":    version.Stability,
+		"link":         downloadLink(modID, version.Version),
+		"hash":         hash,
+		"size":         len(version.Zip),
+		"created_at":   version.CreatedAt,
+		"dependencies": dependencies,
+	}
+}
+
+func latestVersions(modID string, versions []Version) map[string]interface{} {
+	latest := map[string]interface{}{"alpha": nil, "beta": nil, "release": nil}
+	latestSemver := map[string]*semver.Version{}
+	for _, version := range versions {
+		ver, verErr := semver.NewVersion(version.Version)
+		if verErr != nil {
+			continue
+		}
+		if current, ok := latestSemver[version.Stability]; !ok || ver.GreaterThan(current) {
+			latestSemver[version.Stability] = ver
+			latest[version.Stability] = versionJSON(modID, version)
+		}
+	}
+	return latest
+}
+
+// modJSON is a superset of all the fields the launcher queries, extra fields are ignored by the client
+func modJSON(mod Mod, variables map[string]interface{}) map[string]interface{} {
+	versions := []map[string]interface{}{}
+	var requestedVersion interface{}
+	for _, version := range mod.Versions {
+		versions = append(versions, versionJSON(mod.ID, version))
+		if variables["version"] == version.Version {
+			requestedVersion = versionJSON(mod.ID, version)
+		}
+	}
+	authors := []map[string]interface{}{}
+	for _, author := range mod.Authors {
+		authors = append(authors, map[string]interface{}{"role": "creator", "user": map[string]interface{}{"username": author}})
+	}
+	return map[string]interface{}{
+		"id":                mod.ID,
+		"name":              mod.Name,
+		"short_description": mod.ShortDescription,
+		"full_description":  mod.FullDescription,
+		"downloads":         mod.Downloads,
+		"authors":           authors,
+		"versions":          versions,
+		"latestVersions":    latestVersions(mod.ID, mod.Versions),
+		"version":           requestedVersion,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// aliasedGetMod matches the getMod fields of batched queries, like mod0: getMod(modId: $mod0). The version of each is in $version0
+var aliasedGetMod = regexp.MustCompile(`(\w+):\s*getMod\(modId:\s*\$(\w+)\)`)
+
+func (server *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	server.countRequest(r.URL.Path)
+	var request struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if json.NewDecoder(r.Body).Decode(&request) != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	data := map[string]interface{}{}
+	errs := []map[string]interface{}{}
+	getMod := func(field string, modID string, variables map[string]interface{}) {
+		mod, ok := server.mods[modID]
+		if ok && mod.Error != "" {
+			data[field] = nil
+			errs = append(errs, map[string]interface{}{"message": mod.Error, "path": []string{field}})
+		} else if ok {
+			data[field] = modJSON(mod, variables)
+		} else {
+			data[field] = nil
+		}
+	}
+	if aliases := aliasedGetMod.FindAllStringSubmatch(request.Query, -1); len(aliases) > 0 {
+		for _, alias := range aliases {
+			modID, _ := request.Variables[alias[2]].(string)
+			getMod(alias[1], modID, map[string]interface{}{"version": request.Variables[strings.Replace(alias[2], "mod", "version", 1)]})
+		}
+	} else if strings.Contains(request.Query, "getMod(") {
+		modID, _ := request.Variables["modID"].(string)
+		getMod("getMod", modID, request.Variables)
+	}
+	if strings.Contains(request.Query, "getMods(") {
+		search, _ := request.Variables["search"].(string)
+		mods := []map[string]interface{}{}
+		for _, mod := range server.mods {
+			if strings.Contains(strings.ToLower(mod.Name), strings.ToLower(search)) {
+				mods = append(mods, modJSON(mod, request.Variables))
+			}
+		}
+		sort.Slice(mods, func(i, j int) bool {
+			return mods[i]["downloads"].(int) > mods[j]["downloads"].(int)
+		})
+		if limit, ok := request.Variables["limit"].(float64); ok && int(limit) < len(mods) {
+			mods = mods[:int(limit)]
+		}
+		data["getMods"] = map[string]interface{}{"mods": mods, "count": len(mods)}
+	}
+	response := map[string]interface{}{"data": data}
+	if len(errs) > 0 {
+		response["errors"] = errs
+	}
+	writeJSON(w, response)
+}
+
+func (server *Server) handleModDownload(w http.ResponseWriter, r *http.Request) {
+	server.countRequest(r.URL.Path)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/download/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	server.lock.Lock()
+	mod, ok := server.mods[parts[0]]
+	server.lock.Unlock()
+	if ok {
+		for _, version := range mod.Versions {
+			if version.Version == parts[1] {
+				http.ServeContent(w, r, mod.ID+".zip", version.CreatedAt, bytes.NewReader(version.Zip))
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (server *Server) handleReleases(w http.ResponseWriter, r *http.Request) {
+	server.countRequest(r.URL.Path)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	releases := []map[string]interface{}{}
+	for _, release := range server.releases {
+		releases = append(releases, map[string]interface{}{
+			"tag_name":     "v" + release.Version,
+			"body":         release.Description,
+			"published_at": release.PublishedAt,
+			"assets": []map[string]interface{}{
+				{"name": "xinput1_3.dll", "browser_download_url": server.URL + "/sml/download/" + release.Version},
+			},
+		})
+	}
+	// GitHub returns the newest release first
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i]["published_at"].(time.Time).After(releases[j]["published_at"].(time.Time))
+	})
+	writeJSON(w, releases)
+}
+
+func (server *Server) handleSMLDownload(w http.ResponseWriter, r *http.Request) {
+	server.countRequest(r.URL.Path)
+	version := strings.TrimPrefix(r.URL.Path, "/sml/download/")
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	for _, release := range server.releases {
+		if release.Version == version {
+			http.ServeContent(w, r, "xinput1_3.dll", release.PublishedAt, bytes.NewReader(release.DLL))
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
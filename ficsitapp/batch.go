@@ -0,0 +1,295 @@
+package ficsitapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+// batchSize is how many mods are looked up in one ficsit.app request
+const batchSize = 20
+
+func init() {
+	config.Register(config.Setting{
+		Name:        "concurrency",
+		Env:         "SMLAUNCHER_CONCURRENCY",
+		Default:     "4",
+		Description: "how many mods are downloaded at the same time",
+		Check: func(value string) error {
+			if workers, parseErr := strconv.Atoi(value); parseErr != nil || workers < 1 {
+				return errors.New("must be a positive number")
+			}
+			return nil
+		},
+	})
+}
+
+// ModVersionRef is a version of a mod
+type ModVersionRef struct {
+	ModID   string `json:"mod_id"`
+	Version string `json:"version"`
+}
+
+// singleModRequests are the single mod requests of the batched selections. Each lookup of a batch is cached
+// like the single mod request, so batched and single lookups share the cached responses
+var singleModRequests = map[string]string{
+	latestVersionsSelection:  modVersionLatestRequest,
+	versionDownloadSelection: modVersionDownloadLinkRequest,
+}
+
+// getModsBatch runs the getMod selection for each lookup, sending up to batchSize aliased getMod fields per request.
+// The selection can use $version, which is the version of each lookup. Mods that do not exist are nil.
+// Lookups are served from the cache like single mod requests, only the missing ones are sent.
+// Offline, the lookups that are not cached get ErrNotCached at their index. A lookup that ficsit.app returns an error for
+// gets the error at its index, or its expired response if it has one, without failing the other lookups of its batch
+func getModsBatch(reporter *util.Reporter, selection string, lookups []ModVersionRef) ([]*Mod, []error, error) {
+	mods := make([]*Mod, len(lookups))
+	errs := make([]error, len(lookups))
+	usesVersion := strings.Contains(selection, "$version")
+	singleRequest := singleModRequests[selection]
+	ttl := requestTTL(singleRequest)
+	keys := make([]string, len(lookups))
+	lookupVariables := make([]map[string]interface{}, len(lookups))
+	cached := make([]cachedResponse, len(lookups))
+	isCached := make([]bool, len(lookups))
+	missing := []int{}
+	for i, lookup := range lookups {
+		variables := map[string]interface{}{"modID": lookup.ModID}
+		if usesVersion {
+			variables["version"] = lookup.Version
+		}
+		lookupVariables[i] = variables
+		keys[i] = cacheKey(singleRequest, variables)
+		cached[i], isCached[i] = readCache(keys[i])
+		if isCached[i] && cached[i].usable(ttl) {
+			var response getModResponse
+			if jsonErr := json.Unmarshal(cached[i].Data, &response); jsonErr != nil {
+				return nil, nil, jsonErr
+			}
+			mods[i] = response.GetMod
+			continue
+		}
+		if Offline() {
+			errs[i] = fmt.Errorf("%w: %s", ErrNotCached, describeRequest(variables))
+			continue
+		}
+		missing = append(missing, i)
+	}
+	for start := 0; start < len(missing); start += batchSize {
+		end := start + batchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[start:end]
+		variables := map[string]interface{}{}
+		declarations := []string{}
+		fields := []string{}
+		for j, i := range batch {
+			alias := "mod" + strconv.Itoa(j)
+			variables[alias] = lookups[i].ModID
+			declarations = append(declarations, "$"+alias+": ModID!")
+			fieldSelection := selection
+			if usesVersion {
+				versionVariable := "version" + strconv.Itoa(j)
+				variables[versionVariable] = lookups[i].Version
+				declarations = append(declarations, "$"+versionVariable+": String!")
+				fieldSelection = strings.ReplaceAll(selection, "$version", "$"+versionVariable)
+			}
+			fields = append(fields, "\t"+alias+": getMod(modId: $"+alias+")"+fieldSelection)
+		}
+		request := "query(" + strings.Join(declarations, ", ") + "){\n" + strings.Join(fields, "") + "}\n"
+		response, fieldErrs, queryErr := runAliasedQuery(request, variables)
+		if queryErr != nil {
+			// fall back to the expired responses, if all the mods of the batch have one
+			for _, i := range batch {
+				if !isCached[i] {
+					return nil, nil, queryErr
+				}
+			}
+			warnStale(reporter, cached[batch[0]], queryErr)
+			for _, i := range batch {
+				var cachedResponse getModResponse
+				if jsonErr := json.Unmarshal(cached[i].Data, &cachedResponse); jsonErr != nil {
+					return nil, nil, jsonErr
+				}
+				mods[i] = cachedResponse.GetMod
+			}
+			continue
+		}
+		for j, i := range batch {
+			alias := "mod" + strconv.Itoa(j)
+			if fieldErr, ok := fieldErrs[alias]; ok {
+				// only this lookup failed, so it falls back to its expired response on its own
+				if !isCached[i] {
+					errs[i] = fmt.Errorf("looking up %s: %w", describeRequest(lookupVariables[i]), fieldErr)
+					continue
+				}
+				warnStale(reporter, cached[i], fieldErr)
+				var cachedResponse getModResponse
+				if jsonErr := json.Unmarshal(cached[i].Data, &cachedResponse); jsonErr != nil {
+					return nil, nil, jsonErr
+				}
+				mods[i] = cachedResponse.GetMod
+				continue
+			}
+			data, jsonErr := json.Marshal(map[string]json.RawMessage{"getMod": response[alias]})
+			if jsonErr != nil {
+				return nil, nil, jsonErr
+			}
+			writeCache(keys[i], data)
+			var modResponse getModResponse
+			if jsonErr := json.Unmarshal(data, &modResponse); jsonErr != nil {
+				return nil, nil, jsonErr
+			}
+			mods[i] = modResponse.GetMod
+		}
+	}
+	return mods, errs, nil
+}
+
+// GetLatestVersions gets the latest version of each mod that meets its stability policy, in as few requests as possible.
+// Mods without such a version are left out, like the mods that are not cached in offline mode and,
+// with a warning, the mods that ficsit.app failed to look up.
+// Returns ErrModNotFound for the first mod that does not exist
+func GetLatestVersions(reporter *util.Reporter, modIDs []string) (map[string]*Version, error) {
+	lookups := []ModVersionRef{}
+	for _, modID := range modIDs {
+		lookups = append(lookups, ModVersionRef{ModID: modID})
+	}
+	mods, lookupErrs, batchErr := getModsBatch(reporter, latestVersionsSelection, lookups)
+	if batchErr != nil {
+		return nil, batchErr
+	}
+	latest := map[string]*Version{}
+	for i, mod := range mods {
+		if lookupErrs[i] != nil {
+			if !errors.Is(lookupErrs[i], ErrNotCached) {
+				reporter.Progress("Warning: " + lookupErrs[i].Error())
+			}
+			continue
+		}
+		if mod == nil {
+			return nil, fmt.Errorf("%w: %s", ErrModNotFound, modIDs[i])
+		}
+		if latestVersion := mod.LatestVersionWithStability(ModStability(modIDs[i])); latestVersion != nil {
+			latest[modIDs[i]] = latestVersion
+		}
+	}
+	return latest, nil
+}
+
+// getDownloadVersions gets the download link, size and checksum of each mod version in as few requests as possible.
+// Like getModVersion, versions that are not found are looked up again with the v prefix. Returns the error of each lookup at its index
+func getDownloadVersions(reporter *util.Reporter, refs []ModVersionRef) ([]*Version, []error, error) {
+	versions := make([]*Version, len(refs))
+	mods, errs, batchErr := getModsBatch(reporter, versionDownloadSelection, refs)
+	if batchErr != nil {
+		return nil, nil, batchErr
+	}
+	retries := []ModVersionRef{}
+	retryIndexes := []int{}
+	for i, mod := range mods {
+		switch {
+		case errs[i] != nil:
+			// not cached offline, or the lookup failed
+		case mod == nil:
+			errs[i] = fmt.Errorf("%w: %s", ErrModNotFound, refs[i].ModID)
+		case mod.Version != nil && mod.Version.Link != "":
+			versions[i] = mod.Version
+		case strings.HasPrefix(refs[i].Version, "v"):
+			errs[i] = fmt.Errorf("%w: %s@%s", ErrVersionNotFound, refs[i].ModID, refs[i].Version[1:])
+		default:
+			retries = append(retries, ModVersionRef{refs[i].ModID, "v" + refs[i].Version})
+			retryIndexes = append(retryIndexes, i)
+		}
+	}
+	if len(retries) == 0 {
+		return versions, errs, nil
+	}
+	retryMods, retryErrs, retryErr := getModsBatch(reporter, versionDownloadSelection, retries)
+	if retryErr != nil {
+		return nil, nil, retryErr
+	}
+	for j, mod := range retryMods {
+		i := retryIndexes[j]
+		if retryErrs[j] != nil {
+			errs[i] = retryErrs[j]
+		} else if mod == nil || mod.Version == nil || mod.Version.Link == "" {
+			errs[i] = fmt.Errorf("%w: %s@%s", ErrVersionNotFound, refs[i].ModID, refs[i].Version)
+		} else {
+			versions[i] = mod.Version
+		}
+	}
+	return versions, errs, nil
+}
+
+// inFlightDownload is a download that other goroutines wanting the same file wait for
+type inFlightDownload struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	inFlightLock sync.Mutex
+	inFlight     = map[string]*inFlightDownload{}
+)
+
+// downloadModVersion downloads the mod version described by ficsit.app. A version that is already being downloaded
+// by another goroutine is not downloaded again, the result of that download is returned instead
+func downloadModVersion(reporter *util.Reporter, modID string, version string, modVersion *Version) error {
+	zipPath := path.Join(paths.ModDir(modID), modID+"_"+version+".zip")
+	inFlightLock.Lock()
+	if download, ok := inFlight[zipPath]; ok {
+		inFlightLock.Unlock()
+		<-download.done
+		return download.err
+	}
+	download := &inFlightDownload{done: make(chan struct{})}
+	inFlight[zipPath] = download
+	inFlightLock.Unlock()
+
+	reporter.Progress("Downloading " + modID + "@" + version)
+	downloadErr := util.DownloadVerifiedFile(reporter, zipPath, modVersion.ModVersion().Link, modVersion.Size, modVersion.Hash, downloadAttempts)
+	if downloadErr != nil {
+		download.err = fmt.Errorf("failed to download %s@%s: %w", modID, version, downloadErr)
+	}
+	inFlightLock.Lock()
+	delete(inFlight, zipPath)
+	inFlightLock.Unlock()
+	close(download.done)
+	return download.err
+}
+
+// DownloadModVersions downloads the mod versions, as many at the same time as the concurrency setting allows.
+// The download links are looked up in batches. Returns the error of each download at its index, nil if it succeeded
+func DownloadModVersions(reporter *util.Reporter, refs []ModVersionRef) []error {
+	if Offline() {
+		errs := make([]error, len(refs))
+		for i, ref := range refs {
+			errs[i] = fmt.Errorf("%w: %s@%s is not downloaded", ErrOffline, ref.ModID, ref.Version)
+		}
+		return errs
+	}
+	versions, lookupErrs, lookupErr := getDownloadVersions(reporter, refs)
+	if lookupErr != nil {
+		errs := make([]error, len(refs))
+		for i := range errs {
+			errs[i] = lookupErr
+		}
+		return errs
+	}
+	return util.ParallelForEach(len(refs), config.GetInt("concurrency"), func(i int) error {
+		if lookupErrs[i] != nil {
+			return lookupErrs[i]
+		}
+		return downloadModVersion(reporter, refs[i].ModID, refs[i].Version, versions[i])
+	})
+}
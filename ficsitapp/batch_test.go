@@ -0,0 +1,184 @@
+package ficsitapp
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/fakeserver"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+var testCreatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testMod(modID string, versions ...string) fakeserver.Mod {
+	mod := fakeserver.Mod{ID: modID, Name: modID}
+	for _, version := range versions {
+		mod.Versions = append(mod.Versions, fakeserver.Version{
+			Version:   version,
+			Stability: StabilityRelease,
+			CreatedAt: testCreatedAt,
+			Zip:       fakeserver.ModZip(modID, version, nil, nil),
+		})
+	}
+	return mod
+}
+
+// setup starts a fake ficsit.app with the mods and uses a new data directory, with the cached responses always expired.
+// The returned function cleans them up
+func setup(t *testing.T, mods ...fakeserver.Mod) (*fakeserver.Server, func()) {
+	server := fakeserver.New(mods, nil)
+	dataDir, tempErr := ioutil.TempDir("", "ficsitapp")
+	if tempErr != nil {
+		server.Close()
+		t.Fatal(tempErr)
+	}
+	cleanup := func() {
+		server.Close()
+		os.RemoveAll(dataDir)
+	}
+	if setErr := paths.SetDataDir(dataDir, false); setErr != nil {
+		cleanup()
+		t.Fatal(setErr)
+	}
+	paths.Init()
+	SetAPIURL(server.APIURL())
+	config.SetFlag("offline", "false")
+	config.SetFlag("stability", StabilityRelease)
+	config.SetFlag("mod-stability", "")
+	config.SetFlag("cache-ttl", "0s")
+	return server, cleanup
+}
+
+// warnings returns a reporter collecting the warnings it gets
+func warnings() (*util.Reporter, *[]string) {
+	collected := []string{}
+	reporter := util.NewReporter(func(message string) {
+		if strings.HasPrefix(message, "Warning: ") {
+			collected = append(collected, message)
+		}
+	}, nil)
+	return reporter, &collected
+}
+
+func TestGetLatestVersionsBatches(t *testing.T) {
+	mods := []fakeserver.Mod{}
+	modIDs := []string{}
+	for i := 0; i < 2*batchSize+5; i++ {
+		modID := "Mod" + strconv.Itoa(i)
+		mods = append(mods, testMod(modID, "1.0.0", "1.1.0"))
+		modIDs = append(modIDs, modID)
+	}
+	server, cleanup := setup(t, mods...)
+	defer cleanup()
+	latest, getLatestErr := GetLatestVersions(nil, modIDs)
+	if getLatestErr != nil {
+		t.Fatal(getLatestErr)
+	}
+	for _, modID := range modIDs {
+		if latest[modID] == nil || latest[modID].Version != "1.1.0" {
+			t.Errorf("latest version of %s = %v, want 1.1.0", modID, latest[modID])
+		}
+	}
+	if queries := server.Requests("/v2/query"); queries != 3 {
+		t.Errorf("looking up %d mods made %d requests, want 3", len(modIDs), queries)
+	}
+
+	config.SetFlag("cache-ttl", "1h")
+	if _, getLatestErr := GetLatestVersions(nil, modIDs); getLatestErr != nil {
+		t.Fatal(getLatestErr)
+	}
+	if _, getLatestErr := GetLatestVersion(nil, "Mod0"); getLatestErr != nil {
+		t.Fatal(getLatestErr)
+	}
+	if queries := server.Requests("/v2/query"); queries != 3 {
+		t.Errorf("cached lookups made %d more requests, want none", queries-3)
+	}
+}
+
+func TestBatchLookupErrors(t *testing.T) {
+	broken := testMod("Broken", "1.0.0")
+	server, cleanup := setup(t, testMod("A", "1.0.0"), broken, testMod("C", "1.0.0"))
+	defer cleanup()
+	// cache Broken before it fails, to check the fallback onto the expired response
+	if _, getLatestErr := GetLatestVersions(nil, []string{"Broken"}); getLatestErr != nil {
+		t.Fatal(getLatestErr)
+	}
+	broken.Error = "internal error"
+	server.SetMod(broken)
+
+	reporter, warned := warnings()
+	latest, getLatestErr := GetLatestVersions(reporter, []string{"A", "Broken", "C"})
+	if getLatestErr != nil {
+		t.Fatal(getLatestErr)
+	}
+	if len(latest) != 3 || len(*warned) != 1 {
+		t.Errorf("GetLatestVersions = %v with warnings %q, want A, C and the expired Broken with one warning", latest, *warned)
+	}
+
+	reporter, warned = warnings()
+	refs := []ModVersionRef{{"A", "1.0.0"}, {"Broken", "1.0.0"}, {"C", "1.0.0"}}
+	versions, lookupErrs, lookupErr := getDownloadVersions(reporter, refs)
+	if lookupErr != nil {
+		t.Fatal(lookupErr)
+	}
+	for i, ref := range refs {
+		failed := ref.ModID == "Broken"
+		if (lookupErrs[i] != nil) != failed || (versions[i] == nil) != failed {
+			t.Errorf("download lookup of %s = %v, %v, want it to fail only for Broken", ref.ModID, versions[i], lookupErrs[i])
+		}
+	}
+	if lookupErrs[1] != nil && !strings.Contains(lookupErrs[1].Error(), "internal error") {
+		t.Errorf("Broken lookup error = %v, want the ficsit.app error", lookupErrs[1])
+	}
+	if len(*warned) != 0 {
+		t.Errorf("download lookups warned %q, want no warnings", *warned)
+	}
+}
+
+func TestDownloadWaitsForInFlight(t *testing.T) {
+	server, cleanup := setup(t, testMod("A", "1.0.0"))
+	defer cleanup()
+	versions, _, lookupErr := getDownloadVersions(nil, []ModVersionRef{{"A", "1.0.0"}})
+	if lookupErr != nil {
+		t.Fatal(lookupErr)
+	}
+	zipPath := path.Join(paths.ModDir("A"), "A_1.0.0.zip")
+	download := &inFlightDownload{done: make(chan struct{})}
+	inFlightLock.Lock()
+	inFlight[zipPath] = download
+	inFlightLock.Unlock()
+
+	errTestDownload := errors.New("download failed")
+	result := make(chan error)
+	go func() {
+		result <- downloadModVersion(nil, "A", "1.0.0", versions[0])
+	}()
+	download.err = errTestDownload
+	close(download.done)
+	if downloadErr := <-result; !errors.Is(downloadErr, errTestDownload) {
+		t.Errorf("download of an in flight version = %v, want the result of the in flight download %v", downloadErr, errTestDownload)
+	}
+	inFlightLock.Lock()
+	delete(inFlight, zipPath)
+	inFlightLock.Unlock()
+	if downloads := server.Requests("/download/A/1.0.0"); downloads != 0 {
+		t.Errorf("download of an in flight version downloaded it %d times, want 0", downloads)
+	}
+
+	for i, downloadErr := range DownloadModVersions(nil, []ModVersionRef{{"A", "1.0.0"}, {"A", "1.0.0"}}) {
+		if downloadErr != nil {
+			t.Errorf("download %d of A@1.0.0: %v", i, downloadErr)
+		}
+	}
+	if !paths.Exists(zipPath) {
+		t.Errorf("%s was not downloaded", zipPath)
+	}
+}
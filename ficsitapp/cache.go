@@ -0,0 +1,140 @@
+package ficsitapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+// cacheDirName is the directory in paths.CacheDir that keeps the ficsit.app responses
+const cacheDirName = "ficsitapp"
+
+var (
+	// ErrNotCached is returned in offline mode when the ficsit.app data needed was never cached
+	ErrNotCached = errors.New("not in the ficsit.app cache, run it once online to cache it")
+	// ErrOffline is returned when something has to be downloaded in offline mode
+	ErrOffline = errors.New("can not download in offline mode")
+)
+
+func init() {
+	config.Register(config.Setting{Name: "offline", Env: "SMLAUNCHER_OFFLINE", Default: "false", IsBool: true,
+		Description: "uses only the cached ficsit.app responses and the downloaded mods, without connecting to ficsit.app"})
+	config.Register(config.Setting{Name: "cache-ttl", Env: "SMLAUNCHER_CACHE_TTL", Default: "1h",
+		Description: "how long cached ficsit.app responses are used before asking ficsit.app again, like 30m or 24h",
+		Check:       checkTTL})
+	// published versions do not change, so their download links are kept longer than the rest of the catalogue
+	config.Register(config.Setting{Name: "version-cache-ttl", Env: "SMLAUNCHER_VERSION_CACHE_TTL", Default: "168h",
+		Description: "how long cached download links of mod versions are used before asking ficsit.app again, like 24h",
+		Check:       checkTTL})
+}
+
+func checkTTL(value string) error {
+	if ttl, parseErr := time.ParseDuration(value); parseErr != nil || ttl < 0 {
+		return errors.New("must be a duration like 30m or 24h")
+	}
+	return nil
+}
+
+// Offline returns true if ficsit.app must not be contacted
+func Offline() bool {
+	return config.GetBool("offline")
+}
+
+// cachedResponse is the data of a ficsit.app response and when it was received
+type cachedResponse struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// requestTTL returns how long the responses to the request are used
+func requestTTL(request string) time.Duration {
+	setting := "cache-ttl"
+	if request == modVersionDownloadLinkRequest {
+		setting = "version-cache-ttl"
+	}
+	ttl, parseErr := time.ParseDuration(config.Get(setting))
+	if parseErr != nil {
+		return time.Hour
+	}
+	return ttl
+}
+
+// cacheKey identifies a request to the ficsit.app API in use
+func cacheKey(request string, variables map[string]interface{}) string {
+	// maps are encoded with sorted keys, so the same variables give the same key
+	variablesJSON, _ := json.Marshal(variables)
+	hash := sha256.Sum256([]byte(baseAPI + "\n" + request + "\n" + string(variablesJSON)))
+	return hex.EncodeToString(hash[:])
+}
+
+func cachePath(key string) string {
+	return path.Join(paths.CacheDir, cacheDirName, key+".json")
+}
+
+func readCache(key string) (cachedResponse, bool) {
+	var cached cachedResponse
+	content, readErr := ioutil.ReadFile(cachePath(key))
+	if readErr != nil {
+		return cached, false
+	}
+	if json.Unmarshal(content, &cached) != nil {
+		return cached, false
+	}
+	return cached, true
+}
+
+// usable returns true if the cached response can be used instead of asking ficsit.app, which is always the case offline
+func (cached cachedResponse) usable(ttl time.Duration) bool {
+	return Offline() || time.Since(cached.FetchedAt) < ttl
+}
+
+// writeCache stores the response data. Failing to write it only means ficsit.app is asked again, so errors are ignored
+func writeCache(key string, data json.RawMessage) {
+	content, jsonErr := json.Marshal(cachedResponse{time.Now(), data})
+	if jsonErr != nil {
+		return
+	}
+	cacheDir := path.Join(paths.CacheDir, cacheDirName)
+	if os.MkdirAll(cacheDir, os.ModePerm) != nil {
+		return
+	}
+	// other launcher processes may read the same response, so it is replaced rather than written in place
+	tmpFile, tmpErr := ioutil.TempFile(cacheDir, key+".*.tmp")
+	if tmpErr != nil {
+		return
+	}
+	_, writeErr := tmpFile.Write(content)
+	closeErr := tmpFile.Close()
+	if writeErr != nil || closeErr != nil || os.Rename(tmpFile.Name(), cachePath(key)) != nil {
+		os.Remove(tmpFile.Name())
+	}
+}
+
+// describeRequest names what a request is about, for the errors of offline mode
+func describeRequest(variables map[string]interface{}) string {
+	if modID, ok := variables["modID"]; ok {
+		if version, ok := variables["version"]; ok {
+			return fmt.Sprintf("mod %v@%v", modID, version)
+		}
+		return fmt.Sprintf("mod %v", modID)
+	}
+	if search, ok := variables["search"]; ok {
+		return fmt.Sprintf("search %q", search)
+	}
+	return "request"
+}
+
+// warnStale reports that a cached response is used because ficsit.app could not be reached
+func warnStale(reporter *util.Reporter, cached cachedResponse, requestErr error) {
+	reporter.Progress("Warning: using ficsit.app data cached at " + cached.FetchedAt.Local().Format("2006-01-02 15:04") + ", as the request failed: " + requestErr.Error())
+}
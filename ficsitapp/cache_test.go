@@ -0,0 +1,117 @@
+package ficsitapp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+// ageCache makes all the cached responses older by the duration
+func ageCache(t *testing.T, duration time.Duration) {
+	cacheDir := path.Join(paths.CacheDir, cacheDirName)
+	files, listErr := ioutil.ReadDir(cacheDir)
+	if listErr != nil {
+		t.Fatal(listErr)
+	}
+	for _, file := range files {
+		filePath := path.Join(cacheDir, file.Name())
+		content, readErr := ioutil.ReadFile(filePath)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		var cached cachedResponse
+		if jsonErr := json.Unmarshal(content, &cached); jsonErr != nil {
+			t.Fatal(jsonErr)
+		}
+		cached.FetchedAt = cached.FetchedAt.Add(-duration)
+		content, _ = json.Marshal(cached)
+		if writeErr := ioutil.WriteFile(filePath, content, 0644); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	server, cleanup := setup(t, testMod("A", "1.0.0"))
+	defer cleanup()
+	config.SetFlag("cache-ttl", "1h")
+	defer config.SetFlag("version-cache-ttl", "168h")
+	tests := []struct {
+		name    string
+		age     time.Duration
+		lookup  func() error
+		queries int
+	}{
+		{"first lookup", 0, func() error { _, err := GetLatestVersion(nil, "A"); return err }, 1},
+		{"fresh response", 30 * time.Minute, func() error { _, err := GetLatestVersion(nil, "A"); return err }, 1},
+		{"expired response", time.Hour, func() error { _, err := GetLatestVersion(nil, "A"); return err }, 2},
+		{"first link lookup", 0, func() error { _, err := GetModVersionLink(nil, "A", "1.0.0"); return err }, 3},
+		{"link older than cache-ttl", 2 * time.Hour, func() error { _, err := GetModVersionLink(nil, "A", "1.0.0"); return err }, 3},
+		{"expired link", 7 * 24 * time.Hour, func() error { _, err := GetModVersionLink(nil, "A", "1.0.0"); return err }, 4},
+		{"link older than version-cache-ttl", 2 * time.Hour, func() error {
+			config.SetFlag("version-cache-ttl", "1h")
+			_, err := GetModVersionLink(nil, "A", "1.0.0")
+			return err
+		}, 5},
+	}
+	for _, test := range tests {
+		if test.age > 0 {
+			ageCache(t, test.age)
+		}
+		if lookupErr := test.lookup(); lookupErr != nil {
+			t.Fatalf("%s: %v", test.name, lookupErr)
+		}
+		if queries := server.Requests("/v2/query"); queries != test.queries {
+			t.Errorf("%s: %d requests in total, want %d", test.name, queries, test.queries)
+		}
+	}
+}
+
+func TestOfflineNotCached(t *testing.T) {
+	server, cleanup := setup(t, testMod("A", "1.0.0"), testMod("B", "1.0.0"))
+	defer cleanup()
+	if _, getLatestErr := GetLatestVersion(nil, "A"); getLatestErr != nil {
+		t.Fatal(getLatestErr)
+	}
+	config.SetFlag("offline", "true")
+	defer config.SetFlag("offline", "false")
+	ageCache(t, 30*24*time.Hour)
+	if latest, getLatestErr := GetLatestVersion(nil, "A"); getLatestErr != nil || latest.Version != "1.0.0" {
+		t.Errorf("offline lookup of a cached mod = %v, %v, want 1.0.0", latest, getLatestErr)
+	}
+	if _, getLatestErr := GetLatestVersion(nil, "B"); !errors.Is(getLatestErr, ErrNotCached) {
+		t.Errorf("offline lookup of an uncached mod error = %v, want %v", getLatestErr, ErrNotCached)
+	}
+	if _, linkErr := GetModVersionLink(nil, "A", "1.0.0"); !errors.Is(linkErr, ErrNotCached) {
+		t.Errorf("offline lookup of an uncached link error = %v, want %v", linkErr, ErrNotCached)
+	}
+	if queries := server.Requests("/v2/query"); queries != 1 {
+		t.Errorf("offline lookups made %d requests, want none", queries-1)
+	}
+}
+
+func TestStaleFallback(t *testing.T) {
+	server, cleanup := setup(t, testMod("A", "1.0.0"), testMod("B", "1.0.0"))
+	defer cleanup()
+	if _, getLatestErr := GetLatestVersion(nil, "A"); getLatestErr != nil {
+		t.Fatal(getLatestErr)
+	}
+	server.Close()
+
+	reporter, warned := warnings()
+	if latest, getLatestErr := GetLatestVersion(reporter, "A"); getLatestErr != nil || latest.Version != "1.0.0" {
+		t.Errorf("lookup of an expired mod with ficsit.app down = %v, %v, want the cached 1.0.0", latest, getLatestErr)
+	}
+	if len(*warned) != 1 {
+		t.Errorf("warnings = %q, want one about the expired response", *warned)
+	}
+	if _, getLatestErr := GetLatestVersion(reporter, "B"); getLatestErr == nil || errors.Is(getLatestErr, ErrNotCached) {
+		t.Errorf("lookup of an uncached mod with ficsit.app down error = %v, want the request error", getLatestErr)
+	}
+}
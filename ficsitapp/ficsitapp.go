@@ -1,27 +1,46 @@
 package ficsitapp
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
-	"log"
-	"path"
+	"fmt"
+	"net/http"
 	"sort"
 	"strings"
+	"time"
 
-	"github.com/Masterminds/semver"
 	"github.com/machinebox/graphql"
-	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/version"
 )
 
-const baseAPI = `https://api.ficsit.app`
-const ficsitappAPI = baseAPI + `/v2/query`
+// DefaultAPIURL is the ficsit.app API used when no other one is configured
+const DefaultAPIURL = `https://api.ficsit.app`
 
-var api *graphql.Client = graphql.NewClient(ficsitappAPI)
+var baseAPI = DefaultAPIURL
 
-const modVersionLatestRequest = `
-query($modID: ModID!){
-	getMod(modId: $modID)
+func init() {
+	config.Register(config.Setting{Name: "api-url", Env: "SMLAUNCHER_API_URL", Default: DefaultAPIURL, Description: "ficsit.app API to use"})
+}
+
+var api *graphql.Client = graphql.NewClient(baseAPI + `/v2/query`)
+
+// SetAPIURL changes the ficsit.app API (or a mirror of it) the package talks to
+func SetAPIURL(apiURL string) {
+	baseAPI = strings.TrimSuffix(apiURL, "/")
+	api = graphql.NewClient(baseAPI + `/v2/query`)
+}
+
+// APIURL returns the ficsit.app API the package talks to
+func APIURL() string {
+	return baseAPI
+}
+
+// latestVersionsSelection is the getMod selection of the latest version of each stability
+const latestVersionsSelection = `
 	{
 		latestVersions
 		{
@@ -39,7 +58,11 @@ query($modID: ModID!){
 			}
 		}
 	}
-}
+`
+
+const modVersionLatestRequest = `
+query($modID: ModID!){
+	getMod(modId: $modID)` + latestVersionsSelection + `}
 `
 
 const modVersionsRequest = `
@@ -49,160 +72,467 @@ query($modID: ModID!){
 		versions
 		{
 			version,
-			stability
+			stability,
+			link,
+			dependencies
+			{
+				mod_id,
+				condition,
+				optional
+			}
 		}
 	}
 }
 `
 
-const modVersionDownloadLinkRequest = `
-query($modID: ModID!, $version: String!){
+const modSearchRequest = `
+query($search: String!, $limit: Int!){
+	getMods(filter: {search: $search, limit: $limit, order_by: downloads, order: desc})
+	{
+		mods
+		{
+			id,
+			name,
+			short_description,
+			downloads,
+			authors
+			{
+				role,
+				user
+				{
+					username
+				}
+			}
+			latestVersions
+			{
+				alpha
+				{
+					version
+				}
+				beta
+				{
+					version
+				}
+				release
+				{
+					version
+				}
+			}
+		}
+	}
+}
+`
+
+const modInfoRequest = `
+query($modID: ModID!){
 	getMod(modId: $modID)
 	{
-		version(version: $version)
+		id,
+		name,
+		short_description,
+		full_description,
+		downloads,
+		authors
+		{
+			role,
+			user
+			{
+				username
+			}
+		}
+		versions
 		{
-			link
+			version,
+			stability,
+			created_at,
+			dependencies
+			{
+				mod_id,
+				condition,
+				optional
+			}
 		}
 	}
 }
 `
 
-var availableVersionStabilities = []string{"alpha", "beta", "release"}
+// versionDownloadSelection is the getMod selection of the download link, hash and size of the version in $version
+const versionDownloadSelection = `
+	{
+		version(version: $version)
+		{
+			link,
+			hash,
+			size
+		}
+	}
+`
+
+const modVersionDownloadLinkRequest = `
+query($modID: ModID!, $version: String!){
+	getMod(modId: $modID)` + versionDownloadSelection + `}
+`
+
+// downloadAttempts is how many times a mod download is tried before giving up
+const downloadAttempts = 3
+
+var (
+	// ErrModNotFound is returned when ficsit.app has no mod with the requested ID
+	ErrModNotFound = errors.New("mod not found on ficsit.app")
+	// ErrVersionNotFound is returned when the mod has no version matching the request
+	ErrVersionNotFound = errors.New("mod version not found on ficsit.app")
+)
+
+// VersionDependency is a dependency of a mod version on ficsit.app
+type VersionDependency struct {
+	ModID     string `json:"mod_id"`
+	Condition string `json:"condition"`
+	Optional  bool   `json:"optional"`
+}
+
+// Version is a version of a mod on ficsit.app
+type Version struct {
+	Version      string              `json:"version"`
+	Stability    string              `json:"stability"`
+	Link         string              `json:"link"`
+	Hash         string              `json:"hash"`
+	Size         int64               `json:"size"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Dependencies []VersionDependency `json:"dependencies"`
+}
+
+// LatestVersions are the newest versions of a mod for each stability. Stabilities without a version are nil
+type LatestVersions struct {
+	Alpha   *Version `json:"alpha"`
+	Beta    *Version `json:"beta"`
+	Release *Version `json:"release"`
+}
+
+// ModAuthor is a user that works on a mod
+type ModAuthor struct {
+	Role string `json:"role"`
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// Mod is a mod on ficsit.app. Only the fields requested by the query are filled
+type Mod struct {
+	ID               string         `json:"id"`
+	Name             string         `json:"name"`
+	ShortDescription string         `json:"short_description"`
+	FullDescription  string         `json:"full_description"`
+	Downloads        int            `json:"downloads"`
+	Authors          []ModAuthor    `json:"authors"`
+	Versions         []Version      `json:"versions"`
+	LatestVersions   LatestVersions `json:"latestVersions"`
+	// Version is the version requested by the query, nil if the mod has no such version
+	Version *Version `json:"version"`
+}
 
-func contains(s []string, e string) bool {
-	for _, a := range s {
-		if a == e {
-			return true
+type getModResponse struct {
+	GetMod *Mod `json:"getMod"`
+}
+
+type getModsResponse struct {
+	GetMods struct {
+		Mods []Mod `json:"mods"`
+	} `json:"getMods"`
+}
+
+// LatestVersion returns the newest of the latest versions of each stability, nil if the mod has no version
+func (mod Mod) LatestVersion() *Version {
+	return mod.LatestVersionWithStability(StabilityAlpha)
+}
+
+// LatestVersionWithStability returns the newest of the latest versions that are at least as stable as the minimum, nil if there is none
+func (mod Mod) LatestVersionWithStability(minimum string) *Version {
+	var latest *Version
+	latestVersions := []*Version{mod.LatestVersions.Alpha, mod.LatestVersions.Beta, mod.LatestVersions.Release}
+	for i, latestVersion := range latestVersions {
+		if latestVersion == nil || latestVersion.Version == "" || !MeetsStability(availableVersionStabilities[i], minimum) {
+			continue
+		}
+		withStability := *latestVersion
+		withStability.Stability = availableVersionStabilities[i]
+		if latest == nil || version.Compare(withStability.Version, latest.Version) > 0 {
+			latest = &withStability
 		}
 	}
-	return false
+	return latest
 }
 
 // ModVersion from ficsit.app
 type ModVersion struct {
-	Version   string
-	Stability string
-}
-
-// GetModVersions gets the versions of the mod
-func GetModVersions(modID string) []ModVersion {
-	req := graphql.NewRequest(modVersionsRequest)
-	req.Var("modID", modID)
-	ctx := context.Background()
-	var respData map[string]interface{}
-	apiErr := api.Run(ctx, req, &respData)
-	util.Check(apiErr)
-	if respData["getMod"] == nil {
-		log.Fatalln("Mod " + modID + " does not exist")
-	}
-	versions := (respData["getMod"].(map[string]interface{})["versions"]).([]interface{})
-	structVersions := []ModVersion{}
-	for _, version := range versions {
-		structVersion := ModVersion{version.(map[string]interface{})["version"].(string), version.(map[string]interface{})["stability"].(string)}
-		structVersions = append(structVersions, structVersion)
+	Version              string
+	Stability            string
+	Link                 string
+	Dependencies         map[string]string
+	OptionalDependencies map[string]string
+}
+
+// ModVersion returns the version with the download link made absolute and the required and optional dependencies as maps of constraints
+func (version Version) ModVersion() ModVersion {
+	dependencies := map[string]string{}
+	optionalDependencies := map[string]string{}
+	for _, dependency := range version.Dependencies {
+		if dependency.Optional {
+			optionalDependencies[dependency.ModID] = dependency.Condition
+		} else {
+			dependencies[dependency.ModID] = dependency.Condition
+		}
+	}
+	link := version.Link
+	if link != "" {
+		link = baseAPI + link
+	}
+	return ModVersion{version.Version, version.Stability, link, dependencies, optionalDependencies}
+}
+
+// runQuery runs the GraphQL request and decodes the data into the response, returning GraphQL errors as errors
+func runQuery(request string, variables map[string]interface{}, response interface{}) error {
+	req := graphql.NewRequest(request)
+	for name, value := range variables {
+		req.Var(name, value)
+	}
+	apiErr := api.Run(context.Background(), req, response)
+	if apiErr != nil {
+		return fmt.Errorf("ficsit.app request failed: %w", apiErr)
+	}
+	return nil
+}
+
+// queryError is a GraphQL error, with the path of the field it is about
+type queryError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path"`
+}
+
+// runAliasedQuery runs the GraphQL request and returns the data of each top level field by its alias. The errors of a field are
+// returned by its alias too, so the other fields can still be used. Only errors that are not about a field fail the whole request
+func runAliasedQuery(request string, variables map[string]interface{}) (map[string]json.RawMessage, map[string]error, error) {
+	body, jsonErr := json.Marshal(map[string]interface{}{"query": request, "variables": variables})
+	if jsonErr != nil {
+		return nil, nil, jsonErr
+	}
+	// the GraphQL client drops the data of responses with errors, so the request is sent directly
+	httpResponse, httpErr := http.Post(baseAPI+`/v2/query`, "application/json", bytes.NewReader(body))
+	if httpErr != nil {
+		return nil, nil, fmt.Errorf("ficsit.app request failed: %w", httpErr)
+	}
+	defer httpResponse.Body.Close()
+	var response struct {
+		Data   map[string]json.RawMessage `json:"data"`
+		Errors []queryError               `json:"errors"`
 	}
-	sort.Slice(versions, func(i, j int) bool {
-		verA, errA := semver.NewVersion(structVersions[i].Version)
-		verB, errB := semver.NewVersion(structVersions[j].Version)
-		if errA != nil {
-			return false
+	if decodeErr := json.NewDecoder(httpResponse.Body).Decode(&response); decodeErr != nil {
+		if httpResponse.StatusCode != http.StatusOK {
+			return nil, nil, fmt.Errorf("ficsit.app request failed: %s", httpResponse.Status)
 		}
-		if errB != nil {
-			return true
+		return nil, nil, fmt.Errorf("ficsit.app request failed: decoding response: %w", decodeErr)
+	}
+	fieldErrs := map[string]error{}
+	for _, responseErr := range response.Errors {
+		alias := ""
+		if len(responseErr.Path) > 0 {
+			alias, _ = responseErr.Path[0].(string)
 		}
-		return verA.Compare(verB) == -1
-	})
-	return structVersions
-}
-
-// GetLatestModVersion gets the latest version of the mod
-func GetLatestModVersion(modID string) string {
-	req := graphql.NewRequest(modVersionLatestRequest)
-	req.Var("modID", modID)
-	ctx := context.Background()
-	var respData map[string]interface{}
-	apiErr := api.Run(ctx, req, &respData)
-	util.Check(apiErr)
-	if respData["getMod"] == nil {
-		log.Fatalln("Mod " + modID + " does not exist")
-	}
-	mod := respData["getMod"].(map[string]interface{})
-	latestVersions := mod["latestVersions"].(map[string]interface{})
-	versions := []string{}
-	for _, versionStability := range availableVersionStabilities {
-		if latestVersions[versionStability] != nil {
-			versionNumber := latestVersions[versionStability].(map[string]interface{})["version"]
-			if versionNumber != nil && versionNumber != "" {
-				versions = append(versions, versionNumber.(string))
-			}
+		if alias == "" {
+			return nil, nil, fmt.Errorf("ficsit.app request failed: graphql: %s", responseErr.Message)
+		}
+		if _, ok := fieldErrs[alias]; !ok {
+			fieldErrs[alias] = fmt.Errorf("ficsit.app request failed: graphql: %s", responseErr.Message)
 		}
 	}
-	if len(versions) == 0 {
-		log.Fatalln("Mod " + modID + " has no available version")
+	return response.Data, fieldErrs, nil
+}
+
+// query is runQuery through the response cache. Cached responses are used until they expire, or always in offline mode.
+// If ficsit.app can not be reached, an expired response is used with a warning sent to the reporter
+func query(reporter *util.Reporter, request string, variables map[string]interface{}, response interface{}) error {
+	key := cacheKey(request, variables)
+	cached, isCached := readCache(key)
+	if isCached && cached.usable(requestTTL(request)) {
+		return json.Unmarshal(cached.Data, response)
+	}
+	if Offline() {
+		return fmt.Errorf("%w: %s", ErrNotCached, describeRequest(variables))
 	}
-	sort.Slice(versions, func(i, j int) bool {
-		verA, errA := semver.NewVersion(versions[i])
-		verB, errB := semver.NewVersion(versions[j])
-		if errA != nil {
-			return false
+	var data json.RawMessage
+	if queryErr := runQuery(request, variables, &data); queryErr != nil {
+		if isCached {
+			warnStale(reporter, cached, queryErr)
+			return json.Unmarshal(cached.Data, response)
 		}
-		if errB != nil {
-			return true
+		return queryErr
+	}
+	writeCache(key, data)
+	return json.Unmarshal(data, response)
+}
+
+// getMod runs a getMod query, returning ErrModNotFound if the mod does not exist
+func getMod(reporter *util.Reporter, request string, modID string, variables map[string]interface{}) (Mod, error) {
+	allVariables := map[string]interface{}{"modID": modID}
+	for name, value := range variables {
+		allVariables[name] = value
+	}
+	var response getModResponse
+	queryErr := query(reporter, request, allVariables, &response)
+	if queryErr != nil {
+		return Mod{}, queryErr
+	}
+	if response.GetMod == nil {
+		return Mod{}, fmt.Errorf("%w: %s", ErrModNotFound, modID)
+	}
+	return *response.GetMod, nil
+}
+
+func sortVersions(versions []ModVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return version.Less(versions[i].Version, versions[j].Version)
+	})
+}
+
+// GetModVersions gets the versions of the mod, oldest first
+func GetModVersions(reporter *util.Reporter, modID string) ([]ModVersion, error) {
+	mod, getModErr := getMod(reporter, modVersionsRequest, modID, nil)
+	if getModErr != nil {
+		return nil, getModErr
+	}
+	structVersions := []ModVersion{}
+	for _, version := range mod.Versions {
+		structVersions = append(structVersions, version.ModVersion())
+	}
+	sortVersions(structVersions)
+	return structVersions, nil
+}
+
+// GetLatestVersion gets the latest version of the mod that meets its stability policy
+func GetLatestVersion(reporter *util.Reporter, modID string) (*Version, error) {
+	mod, getModErr := getMod(reporter, modVersionLatestRequest, modID, nil)
+	if getModErr != nil {
+		return nil, getModErr
+	}
+	stability := ModStability(modID)
+	latestVersion := mod.LatestVersionWithStability(stability)
+	if latestVersion == nil {
+		if mod.LatestVersion() != nil {
+			return nil, fmt.Errorf("%w: %s has no %s version or more stable", ErrVersionNotFound, modID, stability)
 		}
-		return verA.Compare(verB) == -1
+		return nil, fmt.Errorf("%w: %s has no available version", ErrVersionNotFound, modID)
+	}
+	return latestVersion, nil
+}
+
+// GetLatestModVersion gets the latest version of the mod that meets its stability policy
+func GetLatestModVersion(reporter *util.Reporter, modID string) (string, error) {
+	latestVersion, getLatestErr := GetLatestVersion(reporter, modID)
+	if getLatestErr != nil {
+		return "", getLatestErr
+	}
+	return latestVersion.Version, nil
+}
+
+// GetVersionStabilities returns the stability of each version of the mod
+func GetVersionStabilities(reporter *util.Reporter, modID string) (map[string]string, error) {
+	versions, getVersionsErr := GetModVersions(reporter, modID)
+	if getVersionsErr != nil {
+		return nil, getVersionsErr
+	}
+	stabilities := map[string]string{}
+	for _, modVersion := range versions {
+		stabilities[version.Normalize(modVersion.Version)] = modVersion.Stability
+	}
+	return stabilities, nil
+}
+
+// SearchMods finds the mods on ficsit.app matching the search text, most downloaded first
+func SearchMods(reporter *util.Reporter, search string, limit int) ([]Mod, error) {
+	var response getModsResponse
+	queryErr := query(reporter, modSearchRequest, map[string]interface{}{"search": search, "limit": limit}, &response)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+	return response.GetMods.Mods, nil
+}
+
+// GetModInfo gets the descriptions, authors and all versions of the mod, oldest version first
+func GetModInfo(reporter *util.Reporter, modID string) (Mod, error) {
+	mod, getModErr := getMod(reporter, modInfoRequest, modID, nil)
+	if getModErr != nil {
+		return Mod{}, getModErr
+	}
+	sort.SliceStable(mod.Versions, func(i, j int) bool {
+		return mod.Versions[i].CreatedAt.Before(mod.Versions[j].CreatedAt)
 	})
-	return versions[len(versions)-1]
-}
-
-// DownloadModVersion downloads the specified version of the mod
-func DownloadModVersion(modID string, version string) (bool, error) {
-	req := graphql.NewRequest(modVersionDownloadLinkRequest)
-	req.Var("modID", modID)
-	req.Var("version", version)
-	ctx := context.Background()
-	var respData map[string]interface{}
-	apiErr := api.Run(ctx, req, &respData)
-	util.Check(apiErr)
-	if respData["getMod"] == nil {
-		log.Fatalln("Mod " + modID + " does not exist")
-	}
-	versionResponse := respData["getMod"].(map[string]interface{})["version"]
-	if versionResponse == nil {
+	return mod, nil
+}
+
+// getModVersion gets the download link, size and checksum of the specified version of the mod
+func getModVersion(reporter *util.Reporter, modID string, version string) (*Version, error) {
+	mod, getModErr := getMod(reporter, modVersionDownloadLinkRequest, modID, map[string]interface{}{"version": version})
+	if getModErr != nil {
+		return nil, getModErr
+	}
+	if mod.Version == nil || mod.Version.Link == "" {
+		if strings.HasPrefix(version, "v") {
+			return nil, fmt.Errorf("%w: %s@%s", ErrVersionNotFound, modID, version[1:])
+		}
 		// try with prefix v
-		vSuccess, vError := DownloadModVersion(modID, "v"+version)
-		if !vSuccess {
-			return false, errors.New("Mod " + modID + " has no version " + version)
-		}
-		return vSuccess, vError
-	}
-	link := baseAPI + versionResponse.(map[string]interface{})["link"].(string)
-	downloadErr := util.DownloadFile(path.Join(paths.ModDir(modID), modID+"_"+version+".zip"), link)
-	if downloadErr != nil {
-		return false, downloadErr
-	}
-	return true, nil
-}
-
-// GetModFromVersionConstraint returns the latest mod version which meets a constraint
-func GetModFromVersionConstraint(modID string, versionConstraint string) (string, error) {
-	version := ""
-	constraint, constraintErr := semver.NewConstraint(versionConstraint)
-	util.Check(constraintErr)
-	for _, availableVersion := range GetModVersions(modID) {
-		ver, verErr := semver.NewVersion(availableVersion.Version)
-		util.Check(verErr)
-		if constraint.Check(ver) {
-			version = availableVersion.Version
-			if strings.HasPrefix(version, "v") {
-				version = version[1:]
-			}
-			return version, nil
+		return getModVersion(reporter, modID, "v"+version)
+	}
+	return mod.Version, nil
+}
+
+// GetModVersionLink returns the download link of the specified version of the mod
+func GetModVersionLink(reporter *util.Reporter, modID string, version string) (string, error) {
+	modVersion, getVersionErr := getModVersion(reporter, modID, version)
+	if getVersionErr != nil {
+		return "", getVersionErr
+	}
+	return modVersion.ModVersion().Link, nil
+}
+
+// DownloadModVersion downloads the specified version of the mod, checking it against the size and checksum published by ficsit.app
+func DownloadModVersion(reporter *util.Reporter, modID string, version string) error {
+	if Offline() {
+		return fmt.Errorf("%w: %s@%s is not downloaded", ErrOffline, modID, version)
+	}
+	modVersion, getVersionErr := getModVersion(reporter, modID, version)
+	if getVersionErr != nil {
+		return getVersionErr
+	}
+	return downloadModVersion(reporter, modID, version, modVersion)
+}
+
+// GetModFromVersionConstraint returns the latest mod version which meets a constraint and the stability policy of the mod
+func GetModFromVersionConstraint(reporter *util.Reporter, modID string, versionConstraint string) (string, error) {
+	availableVersions, getVersionsErr := GetModVersions(reporter, modID)
+	if getVersionsErr != nil {
+		return "", getVersionsErr
+	}
+	stability := ModStability(modID)
+	stableVersions := []string{}
+	for _, availableVersion := range availableVersions {
+		if MeetsStability(availableVersion.Stability, stability) {
+			stableVersions = append(stableVersions, availableVersion.Version)
 		}
 	}
-	return "", errors.New("No version of mod " + modID + " matched constraint " + versionConstraint)
+	latest, constraintErr := version.LatestSatisfying(stableVersions, versionConstraint)
+	if constraintErr != nil {
+		return "", constraintErr
+	}
+	if latest != "" {
+		return version.Normalize(latest), nil
+	}
+	return "", fmt.Errorf("%w: no %s version or more stable of %s matched constraint %s", ErrVersionNotFound, stability, modID, versionConstraint)
 }
 
 // DownloadModLatest downloads the latest version of the mod
-func DownloadModLatest(modID string) (bool, error) {
-	return DownloadModVersion(modID, GetLatestModVersion(modID))
+func DownloadModLatest(reporter *util.Reporter, modID string) error {
+	version, getLatestErr := GetLatestModVersion(reporter, modID)
+	if getLatestErr != nil {
+		return getLatestErr
+	}
+	return DownloadModVersion(reporter, modID, version)
 }
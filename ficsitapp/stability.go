@@ -0,0 +1,123 @@
+package ficsitapp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+)
+
+// Stabilities of mod versions on ficsit.app
+const (
+	StabilityAlpha   = "alpha"
+	StabilityBeta    = "beta"
+	StabilityRelease = "release"
+)
+
+// availableVersionStabilities are the stabilities of mod versions, from the least stable
+var availableVersionStabilities = []string{StabilityAlpha, StabilityBeta, StabilityRelease}
+
+var errInvalidModStability = errors.New("expected ModID=stability pairs separated by commas")
+
+func init() {
+	config.Register(config.Setting{
+		Name:        "stability",
+		Env:         "SMLAUNCHER_STABILITY",
+		Default:     StabilityAlpha,
+		Description: "least stable mod versions to use when no exact version is given",
+		Values:      availableVersionStabilities,
+	})
+	config.Register(config.Setting{
+		Name:        "mod-stability",
+		Env:         "SMLAUNCHER_MOD_STABILITY",
+		Description: "stability for specific mods, overriding --stability, as ModID=stability,...",
+		Check: func(value string) error {
+			_, parseErr := ParseModStabilities(value)
+			return parseErr
+		},
+	})
+}
+
+func stabilityRank(stability string) int {
+	for rank, available := range availableVersionStabilities {
+		if available == stability {
+			return rank
+		}
+	}
+	return -1
+}
+
+// MeetsStability returns true if a version with the stability is at least as stable as the minimum.
+// Versions with an unknown stability, like the ones only found on disk, always meet it
+func MeetsStability(stability string, minimum string) bool {
+	rank := stabilityRank(stability)
+	return rank == -1 || rank >= stabilityRank(minimum)
+}
+
+// ParseModStabilities parses the per mod stabilities, like "SML=release,ModA=beta"
+func ParseModStabilities(value string) (map[string]string, error) {
+	stabilities := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%w: %s", errInvalidModStability, pair)
+		}
+		stability := strings.TrimSpace(parts[1])
+		if stabilityRank(stability) == -1 {
+			return nil, fmt.Errorf("stability of %s must be one of %v", parts[0], availableVersionStabilities)
+		}
+		stabilities[strings.TrimSpace(parts[0])] = stability
+	}
+	return stabilities, nil
+}
+
+// FormatModStabilities is the reverse of ParseModStabilities, sorted by mod ID
+func FormatModStabilities(stabilities map[string]string) string {
+	pairs := []string{}
+	for modID, stability := range stabilities {
+		pairs = append(pairs, modID+"="+stability)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// ModStabilities returns the per mod stabilities in effect. Invalid values are ignored
+func ModStabilities() map[string]string {
+	stabilities, parseErr := ParseModStabilities(config.Get("mod-stability"))
+	if parseErr != nil {
+		return map[string]string{}
+	}
+	return stabilities
+}
+
+// ModStability returns the least stable versions of the mod that can be used when no exact version is given
+func ModStability(modID string) string {
+	if stability, ok := ModStabilities()[modID]; ok {
+		return stability
+	}
+	return config.Get("stability")
+}
+
+// SetModStability stores the stability of the mod in the config file. An empty stability removes it, so the global one is used
+func SetModStability(modID string, stability string) error {
+	fileValue, _ := config.FileValue("mod-stability")
+	stabilities, parseErr := ParseModStabilities(fileValue)
+	if parseErr != nil {
+		return fmt.Errorf("%w: mod-stability: %s", config.ErrInvalidValue, parseErr.Error())
+	}
+	if stability == "" {
+		delete(stabilities, modID)
+	} else {
+		stabilities[modID] = stability
+	}
+	if len(stabilities) == 0 {
+		return config.Unset("mod-stability")
+	}
+	return config.Set("mod-stability", FormatModStabilities(stabilities))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+func init() {
+	config.Register(config.Setting{Name: "output", Env: "SMLAUNCHER_OUTPUT", Default: "text", Values: []string{"text", "json", "yaml"},
+		Description: "prints the command results as text or structured data on stdout, with messages on stderr"})
+	config.Register(config.Setting{Name: "data-dir", Env: "SMLAUNCHER_DATA_DIR", NotInFile: true,
+		Description: "keeps the downloaded mods, cache and config in this directory"})
+	config.Register(config.Setting{Name: "portable", Env: "SMLAUNCHER_PORTABLE", Default: "false", IsBool: true, NotInFile: true,
+		Description: "keeps everything next to the executable, also turned on by a " + paths.PortableMarker + " file there"})
+	config.Register(config.Setting{Name: "timeout", Env: "SMLAUNCHER_TIMEOUT", Default: strconv.Itoa(int(util.DefaultDownloadTimeout.Seconds())),
+		Description: "seconds a download waits for the server to connect or send data before it is retried",
+		Check: func(value string) error {
+			if seconds, parseErr := strconv.Atoi(value); parseErr != nil || seconds < 1 {
+				return errors.New("must be a positive number")
+			}
+			return nil
+		}})
+}
+
+// globalFlagsHelp lists the settings, which every command accepts as flags before the command name
+func globalFlagsHelp() string {
+	help := `
+Global flags, given before the command (flag, then environment variable, then config file, then default):
+`
+	for _, setting := range config.Settings() {
+		usage := "--" + setting.Name + " <value>"
+		if setting.IsBool {
+			usage = "--" + setting.Name
+		} else if len(setting.Values) > 0 {
+			usage = "--" + setting.Name + " " + strings.Join(setting.Values, "|")
+		}
+		help += "\t" + usage + " - " + setting.Description + " (env " + setting.Env + ")\n"
+	}
+	return help
+}
+
+// parseGlobalFlags removes the global flags before the command name from the arguments and stores their values.
+// The arguments from the command name on are left to the command, so its own flags are never taken for settings
+func parseGlobalFlags(arguments []string) ([]string, error) {
+	for i := 0; i < len(arguments); i++ {
+		argument := arguments[i]
+		if !strings.HasPrefix(argument, "--") {
+			return arguments[i:], nil
+		}
+		name := strings.TrimPrefix(argument, "--")
+		value := ""
+		hasValue := false
+		if equals := strings.Index(name, "="); equals != -1 {
+			name, value, hasValue = name[:equals], name[equals+1:], true
+		}
+		setting, ok := config.Lookup(name)
+		if !ok {
+			return arguments[i:], nil
+		}
+		if !hasValue {
+			if setting.IsBool {
+				value = "true"
+			} else {
+				if i+1 >= len(arguments) {
+					return nil, errors.New("missing value for --" + name)
+				}
+				i++
+				value = arguments[i]
+			}
+		}
+		if validateErr := config.Validate(name, value); validateErr != nil {
+			return nil, validateErr
+		}
+		config.SetFlag(name, value)
+	}
+	return []string{}, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	defer config.SetFlag("offline", "false")
+	defer config.SetFlag("output", "text")
+	tests := []struct {
+		arguments []string
+		want      []string
+		offline   string
+		output    string
+	}{
+		{[]string{"--offline", "--output", "json", "download", "-m", "A"}, []string{"download", "-m", "A"}, "true", "json"},
+		{[]string{"--output=yaml", "list"}, []string{"list"}, "false", "yaml"},
+		// the flags after the command name are its own, even if a setting has the same name
+		{[]string{"config", "set", "--offline", "true"}, []string{"config", "set", "--offline", "true"}, "false", "text"},
+		{[]string{"download", "--output", "json"}, []string{"download", "--output", "json"}, "false", "text"},
+		{[]string{"--unknown", "list"}, []string{"--unknown", "list"}, "false", "text"},
+		{[]string{"--offline"}, []string{}, "true", "text"},
+	}
+	for _, test := range tests {
+		config.SetFlag("offline", "false")
+		config.SetFlag("output", "text")
+		remaining, parseErr := parseGlobalFlags(test.arguments)
+		if parseErr != nil {
+			t.Errorf("parseGlobalFlags(%q): %v", test.arguments, parseErr)
+			continue
+		}
+		if !reflect.DeepEqual(remaining, test.want) {
+			t.Errorf("parseGlobalFlags(%q) = %q, want %q", test.arguments, remaining, test.want)
+		}
+		if config.Get("offline") != test.offline || config.Get("output") != test.output {
+			t.Errorf("parseGlobalFlags(%q) set offline %s and output %s, want %s and %s",
+				test.arguments, config.Get("offline"), config.Get("output"), test.offline, test.output)
+		}
+	}
+	if _, parseErr := parseGlobalFlags([]string{"--output"}); parseErr == nil {
+		t.Errorf("parseGlobalFlags of a flag without its value succeeded, want an error")
+	}
+	if _, parseErr := parseGlobalFlags([]string{"--output", "xml", "list"}); parseErr == nil {
+		t.Errorf("parseGlobalFlags of an invalid value succeeded, want an error")
+	}
+}
@@ -0,0 +1,296 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/transaction"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+// FileName is the name of the lockfile
+const FileName = "smlauncher.lock"
+
+// downloadAttempts is how many times a locked mod download is tried before giving up
+const downloadAttempts = 3
+
+// ErrHashMismatch is returned when a mod zip does not have the checksum recorded in the lockfile
+var ErrHashMismatch = errors.New("mod checksum does not match the lockfile")
+
+// LockedMod is a mod version pinned by the lockfile
+type LockedMod struct {
+	ModID   string `json:"mod_id"`
+	Version string `json:"version"`
+	Link    string `json:"link"`
+	SHA256  string `json:"sha256"`
+}
+
+// Lockfile contains the exact mod versions of a mod set
+type Lockfile struct {
+	Mods []LockedMod `json:"mods"`
+}
+
+// InstallPath returns the path of the lockfile of a Satisfactory install
+func InstallPath(smlPath string) string {
+	return path.Join(smlPath, FileName)
+}
+
+// DownloadsPath returns the path of the lockfile of the downloaded mods
+func DownloadsPath() string {
+	return path.Join(paths.SMLauncherDir, FileName)
+}
+
+// Read reads the lockfile at the path
+func Read(lockfilePath string) (Lockfile, error) {
+	content, readErr := ioutil.ReadFile(lockfilePath)
+	if readErr != nil {
+		return Lockfile{}, readErr
+	}
+	var lockfile Lockfile
+	jsonErr := json.Unmarshal(content, &lockfile)
+	if jsonErr != nil {
+		return Lockfile{}, fmt.Errorf("invalid lockfile %s: %w", lockfilePath, jsonErr)
+	}
+	return lockfile, nil
+}
+
+// Write writes the lockfile to the path
+func Write(lockfilePath string, lockfile Lockfile) error {
+	sort.Slice(lockfile.Mods, func(i, j int) bool {
+		return lockfile.Mods[i].ModID < lockfile.Mods[j].ModID
+	})
+	content, jsonErr := json.MarshalIndent(lockfile, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	// written next to the lockfile and renamed, so an interrupted write does not leave a truncated lockfile
+	if writeErr := ioutil.WriteFile(lockfilePath+".tmp", content, 0644); writeErr != nil {
+		os.Remove(lockfilePath + ".tmp")
+		return writeErr
+	}
+	return os.Rename(lockfilePath+".tmp", lockfilePath)
+}
+
+// Get returns the locked version of the mod
+func (lockfile Lockfile) Get(modID string) (LockedMod, bool) {
+	for _, mod := range lockfile.Mods {
+		if mod.ModID == modID {
+			return mod, true
+		}
+	}
+	return LockedMod{}, false
+}
+
+// Merge returns the lockfile with the mods of other replacing the mods with the same ID
+func (lockfile Lockfile) Merge(other Lockfile) Lockfile {
+	merged := Lockfile{Mods: append([]LockedMod{}, other.Mods...)}
+	for _, mod := range lockfile.Mods {
+		if _, ok := other.Get(mod.ModID); !ok {
+			merged.Mods = append(merged.Mods, mod)
+		}
+	}
+	return merged
+}
+
+// Without returns the lockfile without the mod locked at the version
+func (lockfile Lockfile) Without(modID string, version string) Lockfile {
+	remaining := Lockfile{Mods: []LockedMod{}}
+	for _, mod := range lockfile.Mods {
+		if mod.ModID != modID || mod.Version != version {
+			remaining.Mods = append(remaining.Mods, mod)
+		}
+	}
+	return remaining
+}
+
+func lockMod(reporter *util.Reporter, modID string, version string, link string, zipPath string) (LockedMod, error) {
+	hash, hashErr := modhandler.GetModZipHash(zipPath)
+	if hashErr != nil {
+		return LockedMod{}, hashErr
+	}
+	if link == "" {
+		var linkErr error
+		link, linkErr = ficsitapp.GetModVersionLink(reporter, modID, version)
+		if errors.Is(linkErr, ficsitapp.ErrModNotFound) || errors.Is(linkErr, ficsitapp.ErrVersionNotFound) {
+			// installed from a zip that is not on ficsit.app, it can still be checked but not downloaded again
+			reporter.Progress("Warning: " + modID + "@" + version + " is not on ficsit.app, it is locked without a download link")
+			linkErr = nil
+		}
+		if linkErr != nil {
+			return LockedMod{}, linkErr
+		}
+	}
+	return LockedMod{modID, version, link, hash}, nil
+}
+
+// FromPlan creates a lockfile from the mods picked by the dependency resolver, which must be downloaded
+func FromPlan(reporter *util.Reporter, plan []modhandler.ResolvedMod) (Lockfile, error) {
+	lockfile := Lockfile{Mods: []LockedMod{}}
+	for _, mod := range plan {
+		zipPath, findErr := modhandler.FindModZip(mod.ModID, mod.Version)
+		if findErr != nil {
+			return Lockfile{}, findErr
+		}
+		lockedMod, lockErr := lockMod(reporter, mod.ModID, mod.Version, mod.Link, zipPath)
+		if lockErr != nil {
+			return Lockfile{}, lockErr
+		}
+		lockfile.Mods = append(lockfile.Mods, lockedMod)
+	}
+	return lockfile, nil
+}
+
+// FromInstalled creates a lockfile from the mods installed in the SML path. The mods that are not on ficsit.app are locked without a download link
+func FromInstalled(reporter *util.Reporter, smlPath string) (Lockfile, error) {
+	installedMods, getInstalledErr := modhandler.GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return Lockfile{}, getInstalledErr
+	}
+	lockfile := Lockfile{Mods: []LockedMod{}}
+	for _, mod := range installedMods {
+		zipPath, findErr := modhandler.GetInstalledModZip(mod.ModID, mod.Version, smlPath)
+		if findErr != nil {
+			return Lockfile{}, findErr
+		}
+		lockedMod, lockErr := lockMod(reporter, mod.ModID, mod.Version, "", zipPath)
+		if lockErr != nil {
+			return Lockfile{}, lockErr
+		}
+		lockfile.Mods = append(lockfile.Mods, lockedMod)
+	}
+	return lockfile, nil
+}
+
+func verify(zipPath string, mod LockedMod) error {
+	hash, hashErr := modhandler.GetModZipHash(zipPath)
+	if hashErr != nil {
+		return hashErr
+	}
+	if hash != mod.SHA256 {
+		return fmt.Errorf("%w: %s@%s", ErrHashMismatch, mod.ModID, mod.Version)
+	}
+	return nil
+}
+
+// ensureDownloaded downloads the locked mod if it is missing from the downloaded mods or its checksum is wrong
+func ensureDownloaded(reporter *util.Reporter, mod LockedMod) error {
+	zipPath, findErr := modhandler.FindModZip(mod.ModID, mod.Version)
+	if findErr == nil {
+		if verify(zipPath, mod) == nil {
+			return nil
+		}
+	} else if !errors.Is(findErr, modhandler.ErrVersionNotFound) {
+		return findErr
+	} else {
+		zipPath = path.Join(paths.ModDir(mod.ModID), mod.ModID+"_"+mod.Version+".zip")
+	}
+	if ficsitapp.Offline() {
+		if findErr == nil {
+			return fmt.Errorf("%w: %s@%s", ErrHashMismatch, mod.ModID, mod.Version)
+		}
+		return fmt.Errorf("%w: %s@%s is not downloaded", ficsitapp.ErrOffline, mod.ModID, mod.Version)
+	}
+	if mod.Link == "" {
+		return fmt.Errorf("no download link for %s@%s in the lockfile", mod.ModID, mod.Version)
+	}
+	downloadErr := util.DownloadVerifiedFile(reporter, zipPath, mod.Link, 0, mod.SHA256, downloadAttempts)
+	if errors.Is(downloadErr, util.ErrDownloadMismatch) {
+		return fmt.Errorf("%w: %s@%s", ErrHashMismatch, mod.ModID, mod.Version)
+	}
+	return downloadErr
+}
+
+// Sync downloads the mods in the lockfile that are missing and makes the mods installed in the SML path match the lockfile exactly
+func Sync(reporter *util.Reporter, lockfile Lockfile, smlPath string) error {
+	for _, mod := range lockfile.Mods {
+		downloadErr := ensureDownloaded(reporter, mod)
+		if downloadErr != nil {
+			return downloadErr
+		}
+	}
+	return transaction.Run(nil, smlPath, "sync", func(tx *transaction.Transaction) error {
+		return syncInstalled(tx, lockfile, smlPath)
+	})
+}
+
+func syncInstalled(tx *transaction.Transaction, lockfile Lockfile, smlPath string) error {
+	installedMods, getInstalledErr := modhandler.GetInstalledModsWithState(smlPath)
+	if getInstalledErr != nil {
+		return getInstalledErr
+	}
+	// the mods that stay installed, even at another version, keep their marks
+	wasAutoInstalled := map[string]bool{}
+	for _, installedMod := range installedMods {
+		wasAutoInstalled[installedMod.ModID] = installedMod.AutoInstalled
+	}
+	for _, installedMod := range installedMods {
+		lockedMod, locked := lockfile.Get(installedMod.ModID)
+		if locked && lockedMod.Version == installedMod.Version {
+			zipPath, findErr := modhandler.GetInstalledModZip(installedMod.ModID, installedMod.Version, smlPath)
+			if findErr != nil {
+				return findErr
+			}
+			if verify(zipPath, lockedMod) == nil {
+				continue
+			}
+		}
+		uninstallErr := modhandler.Uninstall(tx, installedMod.ModID, installedMod.Version, smlPath)
+		if uninstallErr != nil {
+			return uninstallErr
+		}
+	}
+	for _, mod := range lockfile.Mods {
+		installed, installedErr := modhandler.IsModVersionInstalled(mod.ModID, mod.Version, smlPath)
+		if installedErr != nil {
+			return installedErr
+		}
+		if installed {
+			continue
+		}
+		installErr := modhandler.Install(tx, mod.ModID, mod.Version, smlPath)
+		if installErr != nil {
+			return installErr
+		}
+	}
+	return markInstalled(tx, lockfile, wasAutoInstalled, smlPath)
+}
+
+// markInstalled marks the locked mods that were not installed before the sync as auto installed if other locked mods depend on them,
+// and as requested otherwise, like installing them with their dependencies would
+func markInstalled(tx *transaction.Transaction, lockfile Lockfile, wasAutoInstalled map[string]bool, smlPath string) error {
+	dependencies := map[string]bool{}
+	for _, mod := range lockfile.Mods {
+		modDependencies, getDependenciesErr := modhandler.GetDependencies(mod.ModID, mod.Version)
+		if getDependenciesErr != nil {
+			return getDependenciesErr
+		}
+		for dependencyID := range modDependencies {
+			dependencies[dependencyID] = true
+		}
+	}
+	autoInstalled := []string{}
+	requested := []string{}
+	for _, mod := range lockfile.Mods {
+		isAutoInstalled, wasInstalled := wasAutoInstalled[mod.ModID]
+		if !wasInstalled {
+			isAutoInstalled = dependencies[mod.ModID]
+		}
+		if isAutoInstalled {
+			autoInstalled = append(autoInstalled, mod.ModID)
+		} else {
+			requested = append(requested, mod.ModID)
+		}
+	}
+	if markErr := modhandler.SetAutoInstalled(tx, requested, false, smlPath); markErr != nil {
+		return markErr
+	}
+	return modhandler.SetAutoInstalled(tx, autoInstalled, true, smlPath)
+}
@@ -0,0 +1,250 @@
+package lockfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/fakeserver"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+// setup starts a fake ficsit.app with the mods and uses a new data directory and Satisfactory install.
+// The returned function cleans them up
+func setup(t *testing.T, mods ...fakeserver.Mod) (*fakeserver.Server, string, func()) {
+	server := fakeserver.New(mods, nil)
+	dataDir, tempErr := ioutil.TempDir("", "lockfile")
+	if tempErr != nil {
+		server.Close()
+		t.Fatal(tempErr)
+	}
+	cleanup := func() {
+		server.Close()
+		os.RemoveAll(dataDir)
+	}
+	if setErr := paths.SetDataDir(path.Join(dataDir, "data"), false); setErr != nil {
+		cleanup()
+		t.Fatal(setErr)
+	}
+	paths.Init()
+	smlPath := path.Join(dataDir, "Satisfactory")
+	if mkdirErr := os.MkdirAll(smlPath, 0755); mkdirErr != nil {
+		cleanup()
+		t.Fatal(mkdirErr)
+	}
+	ficsitapp.SetAPIURL(server.APIURL())
+	config.SetFlag("offline", "false")
+	config.SetFlag("cache-ttl", "0s")
+	return server, smlPath, cleanup
+}
+
+func testMod(modID string, version string) fakeserver.Mod {
+	return fakeserver.Mod{ID: modID, Name: modID, Versions: []fakeserver.Version{{
+		Version:   version,
+		Stability: ficsitapp.StabilityRelease,
+		Zip:       fakeserver.ModZip(modID, version, nil, nil),
+	}}}
+}
+
+// published returns the mod version of the fake ficsit.app locked
+func published(t *testing.T, mod fakeserver.Mod) LockedMod {
+	version := mod.Versions[0]
+	link, linkErr := ficsitapp.GetModVersionLink(nil, mod.ID, version.Version)
+	if linkErr != nil {
+		t.Fatal(linkErr)
+	}
+	hash := sha256.Sum256(version.Zip)
+	return LockedMod{ModID: mod.ID, Version: version.Version, Link: link, SHA256: hex.EncodeToString(hash[:])}
+}
+
+// downloadMod writes the zip of the mod version to the downloaded mods and returns it locked
+func downloadMod(t *testing.T, modID string, version string, dependencies map[string]string) LockedMod {
+	if mkdirErr := os.MkdirAll(paths.ModDir(modID), 0755); mkdirErr != nil {
+		t.Fatal(mkdirErr)
+	}
+	zipPath := path.Join(paths.ModDir(modID), modID+"_"+version+".zip")
+	if writeErr := ioutil.WriteFile(zipPath, fakeserver.ModZip(modID, version, dependencies, nil), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	hash, hashErr := modhandler.GetModZipHash(zipPath)
+	if hashErr != nil {
+		t.Fatal(hashErr)
+	}
+	return LockedMod{ModID: modID, Version: version, SHA256: hash}
+}
+
+// warnings returns a reporter collecting the warnings it gets
+func warnings() (*util.Reporter, *[]string) {
+	collected := []string{}
+	reporter := util.NewReporter(func(message string) {
+		if strings.HasPrefix(message, "Warning: ") {
+			collected = append(collected, message)
+		}
+	}, nil)
+	return reporter, &collected
+}
+
+func checkAutoInstalled(t *testing.T, smlPath string, want map[string]bool) {
+	for modID, wantAutoInstalled := range want {
+		autoInstalled, stateErr := modhandler.IsAutoInstalled(modID, smlPath)
+		if stateErr != nil {
+			t.Fatal(stateErr)
+		}
+		if autoInstalled != wantAutoInstalled {
+			t.Errorf("%s auto installed = %t, want %t", modID, autoInstalled, wantAutoInstalled)
+		}
+	}
+}
+
+func TestSyncMarksInstalledMods(t *testing.T) {
+	_, smlPath, cleanup := setup(t)
+	defer cleanup()
+	lib := downloadMod(t, "Lib", "1.0.0", nil)
+	newLib := downloadMod(t, "Lib", "1.1.0", nil)
+	app := downloadMod(t, "App", "1.0.0", map[string]string{"Lib": "^1.0.0"})
+
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{app, lib}}, smlPath); syncErr != nil {
+		t.Fatal(syncErr)
+	}
+	checkAutoInstalled(t, smlPath, map[string]bool{"App": false, "Lib": true})
+
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{lib}}, smlPath); syncErr != nil {
+		t.Fatal(syncErr)
+	}
+	checkAutoInstalled(t, smlPath, map[string]bool{"Lib": true})
+
+	// a mod keeps its mark when the sync changes its version
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{newLib}}, smlPath); syncErr != nil {
+		t.Fatal(syncErr)
+	}
+	checkAutoInstalled(t, smlPath, map[string]bool{"Lib": true})
+	if version, getInstalledErr := modhandler.GetInstalledModVersion("Lib", smlPath); getInstalledErr != nil || version != "1.1.0" {
+		t.Errorf("installed Lib = %q, %v, want 1.1.0", version, getInstalledErr)
+	}
+	// a newly installed mod that nothing depends on is requested
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{}}, smlPath); syncErr != nil {
+		t.Fatal(syncErr)
+	}
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{newLib}}, smlPath); syncErr != nil {
+		t.Fatal(syncErr)
+	}
+	checkAutoInstalled(t, smlPath, map[string]bool{"Lib": false})
+}
+
+func TestWriteRead(t *testing.T) {
+	_, _, cleanup := setup(t)
+	defer cleanup()
+	lockfilePath := DownloadsPath()
+	a := LockedMod{ModID: "A", Version: "2.0.0", SHA256: "aa"}
+	b := LockedMod{ModID: "B", Version: "1.0.0", Link: "https://example.com/B", SHA256: "bb"}
+	lock := Lockfile{Mods: []LockedMod{b, a}}
+	for i := 0; i < 2; i++ {
+		if writeErr := Write(lockfilePath, lock); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	read, readErr := Read(lockfilePath)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	want := Lockfile{Mods: []LockedMod{a, b}}
+	if !reflect.DeepEqual(read, want) {
+		t.Errorf("Read = %+v, want the written mods sorted by ID %+v", read, want)
+	}
+	if paths.Exists(lockfilePath + ".tmp") {
+		t.Errorf("%s.tmp left after writing", lockfilePath)
+	}
+	if writeErr := ioutil.WriteFile(lockfilePath, []byte("{"), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if _, readErr := Read(lockfilePath); readErr == nil {
+		t.Errorf("Read of a truncated lockfile succeeded, want an error")
+	}
+}
+
+func TestFromInstalled(t *testing.T) {
+	app := testMod("App", "1.0.0")
+	_, smlPath, cleanup := setup(t, app)
+	defer cleanup()
+	downloadMod(t, "App", "1.0.0", nil)
+	local := downloadMod(t, "Local", "1.0.0", nil)
+	for _, modID := range []string{"App", "Local"} {
+		if installErr := modhandler.Install(nil, modID, "1.0.0", smlPath); installErr != nil {
+			t.Fatal(installErr)
+		}
+	}
+	reporter, warned := warnings()
+	lock, lockErr := FromInstalled(reporter, smlPath)
+	if lockErr != nil {
+		t.Fatal(lockErr)
+	}
+	want := map[string]LockedMod{"App": published(t, app), "Local": local}
+	for modID, wantMod := range want {
+		if lockedMod, ok := lock.Get(modID); !ok || lockedMod != wantMod {
+			t.Errorf("locked %s = %+v, want %+v", modID, lockedMod, wantMod)
+		}
+	}
+	if len(lock.Mods) != 2 || len(*warned) != 1 || !strings.Contains((*warned)[0], "Local@1.0.0") {
+		t.Errorf("FromInstalled locked %d mods with warnings %q, want App and Local with a warning about Local", len(lock.Mods), *warned)
+	}
+}
+
+func TestSync(t *testing.T) {
+	lib := testMod("Lib", "1.0.0")
+	server, smlPath, cleanup := setup(t, lib)
+	defer cleanup()
+	lock := Lockfile{Mods: []LockedMod{published(t, lib)}}
+	other := downloadMod(t, "Other", "1.0.0", nil)
+	if installErr := modhandler.Install(nil, "Other", "1.0.0", smlPath); installErr != nil {
+		t.Fatal(installErr)
+	}
+
+	if syncErr := Sync(nil, lock, smlPath); syncErr != nil {
+		t.Fatal(syncErr)
+	}
+	installed, getInstalledErr := modhandler.GetInstalledMods(smlPath)
+	if getInstalledErr != nil || len(installed) != 1 || installed[0].ModID != "Lib" {
+		t.Errorf("installed after the sync = %+v, %v, want only Lib", installed, getInstalledErr)
+	}
+	if downloads := server.Requests("/download/Lib/1.0.0"); downloads != 1 {
+		t.Errorf("Lib downloaded %d times, want once", downloads)
+	}
+	if syncErr := Sync(nil, lock, smlPath); syncErr != nil {
+		t.Fatal(syncErr)
+	}
+	if downloads := server.Requests("/download/Lib/1.0.0"); downloads != 1 {
+		t.Errorf("a second sync downloaded Lib again")
+	}
+
+	// the downloaded zip of Other does not match its locked checksum, so it is downloaded again, but ficsit.app does not have it
+	other.SHA256 = "00"
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{other}}, smlPath); syncErr == nil {
+		t.Errorf("Sync of a mod with a wrong checksum and no link succeeded, want an error")
+	}
+	corrupted := published(t, lib)
+	corrupted.SHA256 = "00"
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{corrupted}}, smlPath); !errors.Is(syncErr, ErrHashMismatch) {
+		t.Errorf("Sync of a mod with a wrong checksum error = %v, want %v", syncErr, ErrHashMismatch)
+	}
+	installed, getInstalledErr = modhandler.GetInstalledMods(smlPath)
+	if getInstalledErr != nil || len(installed) != 1 || installed[0].ModID != "Lib" {
+		t.Errorf("installed after the failed syncs = %+v, %v, want Lib unchanged", installed, getInstalledErr)
+	}
+
+	config.SetFlag("offline", "true")
+	defer config.SetFlag("offline", "false")
+	missing := LockedMod{ModID: "Missing", Version: "1.0.0", Link: lock.Mods[0].Link, SHA256: "00"}
+	if syncErr := Sync(nil, Lockfile{Mods: []LockedMod{missing}}, smlPath); !errors.Is(syncErr, ficsitapp.ErrOffline) {
+		t.Errorf("offline Sync of a mod that is not downloaded error = %v, want %v", syncErr, ficsitapp.ErrOffline)
+	}
+}
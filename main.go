@@ -4,15 +4,25 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/akamensky/argparse"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/daemon"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/lockfile"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/operations"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/profiles"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/satisfactoryinstall"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/smlhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/transaction"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
 )
 
@@ -22,183 +32,664 @@ const helpMessage = `
 Satisfactory Mod Launcher CLI
 Commands: 
 	help - displays this help message
-	download - download a mod from https://ficsit.app by its id and version (optional, defaults to newest)
+	search - searches ficsit.app for mods by name
+	info - shows the descriptions, authors and versions of a mod on ficsit.app
+	download - download a mod from https://ficsit.app by its id and version (optional, defaults to newest meeting the stability policy), --with-optional also downloads optional dependencies
 	remove - deletes a downloaded mod
-	update - downloads the newest version of the mod and deletes the old ones
-	check_updates - checks for available new versions of mods and SML
-	install - installs the mod to the Satisfactory install
-	uninstall - removes the mod from the Satisfactory install
+	update - downloads the newest version of the mod meeting the stability policy and deletes the old ones
+	check_updates - checks for available new versions of mods and SML, -i/--apply (formerly --install) downloads the mods and installs SML
+	install - installs the mod to the Satisfactory install, --with-optional also installs optional dependencies
+	uninstall - removes the mod from the Satisfactory install, refusing if other installed mods need it unless --cascade also removes them
+	autoremove - removes the mods that were only installed as dependencies and are not needed any more
 	install_sml - installs SML
 	uninstall_sml - uninstalls SML
 	update_sml - updates SML
 	sml_version - shows the installed version of SML
 	list_versions - shows the list of downloaded versions of a mod
 	list - shows the installed mods list and their version
-	list_installed - shows the installed mods
+	list_installed - shows the installed mods, marking the ones installed as dependencies and the optional dependencies
+	deps - shows the dependency tree of a downloaded or installed mod, with the versions picked and whether they are downloaded or installed
+	why - shows the installed mods that require a mod, and the constraints they use
+	list_installs - finds the Satisfactory and dedicated server installs on this machine
+	install_add - registers a Satisfactory install under a name (-n, -p), or all the discovered ones (-d)
+	install_remove - unregisters a named install
+	install_default - sets the install used by commands that are not given -p or --install
+	install_list - shows the registered installs
+	lock - writes a lockfile with the exact versions of the installed mods
+	sync - downloads and installs the mods in a lockfile, removing the installed mods that are not in it
+	profile create|add|remove|list|apply - manages named lists of mods that can be applied to a Satisfactory install
+	config get|set|unset|list - shows and changes the settings stored in the config file
+	stability - shows or sets the least stable mod versions to use, for all mods or one mod (-m) (alpha, beta or release)
+	serve - serves the launcher operations as an HTTP API on localhost, for GUI frontends, which send the token written to the data directory
 	mods_dir - shows the directory where SMLauncher downloads the mods
+	dirs - shows the data, mods, cache and config directories of SMLauncher
 	version - shows the Satisfactory Mod Launcher CLI version
 `
 
 var args []string
 
+const searchResultsLimit = 20
+
+func formatAuthors(authors []ficsitapp.ModAuthor) string {
+	names := []string{}
+	for _, author := range authors {
+		names = append(names, author.User.Username)
+	}
+	return strings.Join(names, ", ")
+}
+
+// formatVersion adds the stability to the version, if it is known
+func formatVersion(version string, stability string) string {
+	if stability == "" {
+		return version
+	}
+	return version + " (" + stability + ")"
+}
+
+// versionStabilities returns the stability of each version of the mod, empty if ficsit.app can not be reached
+func versionStabilities(modID string) map[string]string {
+	stabilities, getStabilitiesErr := ficsitapp.GetVersionStabilities(reporter, modID)
+	if getStabilitiesErr != nil {
+		return map[string]string{}
+	}
+	return stabilities
+}
+
+// modStatus describes whether the mod version is downloaded and installed
+func modStatus(downloaded bool, installed bool) string {
+	status := []string{}
+	if downloaded {
+		status = append(status, "downloaded")
+	}
+	if installed {
+		status = append(status, "installed")
+	}
+	if len(status) == 0 {
+		return "missing"
+	}
+	return strings.Join(status, ", ")
+}
+
+func printDependencyTree(node modhandler.DependencyNode, depth int) {
+	indent := strings.Repeat("\t", depth)
+	line := node.ModID + "@" + node.Version
+	if depth > 0 {
+		line = node.ModID + "@" + node.Constraint
+		if node.Optional {
+			line += " (optional)"
+		}
+		if node.Version == "" {
+			line += ": no matching version"
+		} else {
+			line += ": " + node.Version
+		}
+	}
+	if node.Version != "" {
+		line += " [" + modStatus(node.Downloaded, node.Installed) + "]"
+	}
+	if node.Cycle {
+		line += " (cycle)"
+	}
+	fmt.Fprintln(messageWriter, indent+line)
+	for _, dependency := range node.Dependencies {
+		printDependencyTree(dependency, depth+1)
+	}
+}
+
+// formatRequirementPath prints each mod of the path with the constraint it puts on the next one, like "D@1.0.0 requires A@^1.0.0; A@1.0.0 requires B@^1.0.0"
+func formatRequirementPath(requirementPath []modhandler.RequirementLink, modID string) string {
+	parts := []string{}
+	for i, link := range requirementPath {
+		next := modID
+		if i+1 < len(requirementPath) {
+			next = requirementPath[i+1].ModID
+		}
+		requirement := " requires "
+		if link.Optional {
+			requirement = " optionally requires "
+		}
+		parts = append(parts, link.ModID+"@"+link.Version+requirement+next+"@"+link.Constraint)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// addInstallParams adds the parameters selecting the Satisfactory install, by path or by registered name
+func addInstallParams(command *argparse.Command) (*string, *string) {
+	satisfactoryPathParam := command.String("p", "path", &argparse.Options{Required: false, Help: "satisfactory install path (ending in Binaries/Win64)"})
+	installNameParam := command.String("", "install", &argparse.Options{Required: false, Help: "name of a registered install, used instead of the path (defaults to the default install)"})
+	return satisfactoryPathParam, installNameParam
+}
+
+// installPath returns the Satisfactory install selected by the path or install name, or the default install
+func installPath(satisfactoryPath string, installName string) string {
+	resolvedPath, resolveErr := satisfactoryinstall.ResolveInstallPath(satisfactoryPath, installName)
+	check(resolveErr)
+	return resolvedPath
+}
+
+// optionalInstallPath is installPath for commands that also work without an install, returning an empty path if none is selected
+func optionalInstallPath(satisfactoryPath string, installName string) string {
+	resolvedPath, resolveErr := satisfactoryinstall.ResolveInstallPath(satisfactoryPath, installName)
+	if errors.Is(resolveErr, satisfactoryinstall.ErrNoDefaultInstall) {
+		return ""
+	}
+	check(resolveErr)
+	return resolvedPath
+}
+
 func initSMLauncher() {
+	check(paths.SetDataDir(config.Get("data-dir"), config.GetBool("portable")))
+	migrated, migrateErr := paths.MigrateLegacyDir()
+	if migrated != "" {
+		log.Println("Moved the data of the older launcher version from " + migrated + " to " + paths.SMLauncherDir)
+	}
+	if migrateErr != nil {
+		log.Println("Warning: " + migrateErr.Error())
+	}
 	paths.Init()
+	check(config.Load())
+	// the config file can set the output format too
+	check(initOutput())
+	ficsitapp.SetAPIURL(config.Get("api-url"))
+	smlhandler.SetReleasesURL(config.Get("sml-releases-url"))
+	util.SetDownloadTimeout(time.Duration(config.GetInt("timeout")) * time.Second)
+	recovered, recoverErr := transaction.Recover()
+	for _, interrupted := range recovered {
+		if interrupted.RolledBack {
+			log.Println("Rolled back interrupted " + interrupted.Operation + " in " + interrupted.Path)
+		} else {
+			log.Println("Finished interrupted " + interrupted.Operation + " in " + interrupted.Path)
+		}
+	}
+	if recoverErr != nil {
+		log.Println("Failed to recover interrupted operation: " + recoverErr.Error())
+	}
 }
 
 func main() {
 	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+	var flagsErr error
+	args, flagsErr = parseGlobalFlags(os.Args[1:])
+	check(flagsErr)
+	// before anything can fail, so the errors are printed in the requested format
+	check(initOutput())
 	initSMLauncher()
-	args = os.Args[1:]
+	defer modhandler.SaveIndex()
+	initProgressBar()
 	if len(args) == 0 {
-		log.Println(helpMessage)
+		log.Print(helpMessage + globalFlagsHelp())
 		return
 	}
-	commandName := os.Args[1]
+	commandName := args[0]
 	parser := argparse.NewParser("SatisfactoryModLauncher CLI", "Handles mod download and install")
 	if commandName == "help" {
-		log.Println(helpMessage)
+		log.Print(helpMessage + globalFlagsHelp())
 	} else if commandName == "download" || commandName == "remove" || commandName == "update" || commandName == "list_versions" {
 		modIDParam := parser.String("m", "mod", &argparse.Options{Required: true, Help: "ficsit.app mod ID"})
 		versionParam := parser.String("v", "version", &argparse.Options{Required: false, Help: "mod version"})
-		parseErr := parser.Parse(args)
-		util.Check(parseErr)
+		withOptionalParam := parser.Flag("", "with-optional", &argparse.Options{Required: false, Help: "download: also download the optional dependencies"})
+		parseArgs(parser)
 		modID := *modIDParam
 		version := *versionParam
 		if commandName == "download" {
-			if len(version) == 0 {
-				version = ficsitapp.GetLatestModVersion(modID)
-			}
-			success, dependencyCnt := modhandler.DownloadModWithDependencies(modID, version)
-			if success {
-				fmt.Println("Downloaded " + modID + "@" + version + " and " + strconv.Itoa(dependencyCnt-1) + " dependencies")
-			} else {
-				fmt.Println("Mod " + modID + "@" + version + " could not be downloaded")
-			}
+			result, downloadErr := operations.Download(reporter, modID, version, *withOptionalParam)
+			check(downloadErr)
+			fmt.Fprintln(messageWriter, "Downloaded "+modID+"@"+formatVersion(result.Version, result.Stability)+" and "+strconv.Itoa(result.DependencyCount)+" dependencies")
+			printResult(result)
 		} else if commandName == "remove" {
-			if len(version) == 0 {
-				downloadedVersions, getDownloadedErr := modhandler.GetDownloadedModVersions(modID)
-				util.Check(getDownloadedErr)
-				for _, modVersion := range downloadedVersions {
-					if !modhandler.Remove(modID, modVersion) {
-						fmt.Println("Failed to remove " + modID + "@" + modVersion)
-					}
-				}
-			} else {
-				if !modhandler.Remove(modID, version) {
-					fmt.Println("Failed to remove " + modID + "@" + version)
-				}
+			result, removeErr := operations.Remove(reporter, modID, version, errorCode)
+			check(removeErr)
+			for _, removeErr := range result.Errors {
+				fmt.Fprintln(messageWriter, removeErr.Message)
 			}
+			printResult(result)
 		} else if commandName == "update" {
-			updated, dependencyCnt := modhandler.Update(modID)
-			currentVersion, getLatestDownloadedErr := modhandler.GetLatestDownloadedVersion(modID)
-			util.Check(getLatestDownloadedErr)
-			if updated {
-				fmt.Println("Updated " + modID + " to " + currentVersion + " downloading " + strconv.Itoa(dependencyCnt-1) + " dependencies")
+			result, updateErr := operations.Update(reporter, modID)
+			check(updateErr)
+			stability := versionStabilities(modID)[result.Version]
+			if result.Updated {
+				fmt.Fprintln(messageWriter, "Updated "+modID+" to "+formatVersion(result.Version, stability)+" downloading "+strconv.Itoa(result.DependencyCount)+" dependencies")
 			} else {
-				fmt.Println(modID + " is already up to date (" + currentVersion + ")")
+				fmt.Fprintln(messageWriter, modID+" is already up to date: "+formatVersion(result.Version, stability))
 			}
+			printResult(result)
 		} else if commandName == "list_versions" {
-			modVersions, _ := modhandler.GetDownloadedModVersions(modID)
-			fmt.Println(strings.Join(modVersions, ", "))
+			modVersions, getDownloadedErr := modhandler.GetDownloadedModVersions(modID)
+			if getDownloadedErr != nil && !errors.Is(getDownloadedErr, modhandler.ErrModNotFound) {
+				check(getDownloadedErr)
+			}
+			stabilities := map[string]string{}
+			remoteStabilities := map[string]string{}
+			if len(modVersions) > 0 {
+				remoteStabilities = versionStabilities(modID)
+			}
+			formattedVersions := []string{}
+			for _, modVersion := range modVersions {
+				if stability, ok := remoteStabilities[modVersion]; ok {
+					stabilities[modVersion] = stability
+				}
+				formattedVersions = append(formattedVersions, formatVersion(modVersion, stabilities[modVersion]))
+			}
+			fmt.Fprintln(messageWriter, strings.Join(formattedVersions, ", "))
+			if modVersions == nil {
+				modVersions = []string{}
+			}
+			printResult(operations.ListVersionsResult{ModID: modID, Versions: modVersions, Stabilities: stabilities})
 		}
 	} else if commandName == "install" || commandName == "uninstall" {
 		modIDParam := parser.String("m", "mod", &argparse.Options{Required: true, Help: "ficsit.app mod ID"})
 		versionParam := parser.String("v", "version", &argparse.Options{Required: false, Help: "mod version"})
-		satisfactoryPathParam := parser.String("p", "path", &argparse.Options{Required: true, Help: "satisfactory install path (ending in Binaries/Win64)"})
-		parseErr := parser.Parse(args)
-		util.Check(parseErr)
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
+		cascadeParam := parser.Flag("c", "cascade", &argparse.Options{Required: false, Help: "uninstall: also uninstall the mods that depend on the mod"})
+		withOptionalParam := parser.Flag("", "with-optional", &argparse.Options{Required: false, Help: "install: also install the optional dependencies"})
+		parseArgs(parser)
 		modID := *modIDParam
 		version := *versionParam
-		satisfactoryPath := *satisfactoryPathParam
-		if len(version) == 0 {
-			var getLatestErr error
-			version, getLatestErr = modhandler.GetLatestDownloadedVersion(modID)
-			util.Check(getLatestErr)
-		}
-		if !paths.Exists(satisfactoryPath) {
-			log.Fatalln(errors.New("Invalid Satisfactory path"))
-		}
+		satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
 		if commandName == "install" {
-			if modhandler.InstallModWithDependencies(modID, version, satisfactoryPath) {
-				fmt.Println("Installed mod " + modID + "@" + version)
-			} else {
-				fmt.Println("Failed to install mod " + modID + "@" + version)
-			}
+			result, installErr := operations.Install(reporter, modID, version, satisfactoryPath, *withOptionalParam)
+			check(installErr)
+			fmt.Fprintln(messageWriter, "Installed mod "+modID+"@"+result.Version)
+			printResult(result)
 		} else if commandName == "uninstall" {
-			if modhandler.Uninstall(modID, version, satisfactoryPath) {
-				fmt.Println("Uninstalled mod " + modID + "@" + version)
-			} else {
-				fmt.Println("Failed to uninstall mod " + modID + "@" + version)
+			result, uninstallErr := operations.Uninstall(reporter, modID, version, satisfactoryPath, *cascadeParam)
+			check(uninstallErr)
+			for _, dependent := range result.Removed {
+				fmt.Fprintln(messageWriter, "Uninstalled dependent mod "+dependent.ModID+"@"+dependent.Version)
 			}
+			fmt.Fprintln(messageWriter, "Uninstalled mod "+modID+"@"+result.Version)
+			printResult(result)
+		}
+	} else if commandName == "autoremove" {
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
+		parseArgs(parser)
+		satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
+		result, removeErr := operations.AutoRemove(reporter, satisfactoryPath)
+		check(removeErr)
+		for _, mod := range result.Removed {
+			fmt.Fprintln(messageWriter, "Uninstalled "+mod.ModID+"@"+mod.Version)
+		}
+		if len(result.Removed) == 0 {
+			fmt.Fprintln(messageWriter, "No unneeded dependencies installed")
+		}
+		printResult(result)
+	} else if commandName == "lock" || commandName == "sync" {
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
+		lockfilePathParam := parser.String("f", "file", &argparse.Options{Required: false, Help: "lockfile path (defaults to " + lockfile.FileName + " in the satisfactory install path)"})
+		parseArgs(parser)
+		satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
+		lockfilePath := *lockfilePathParam
+		if lockfilePath == "" {
+			lockfilePath = lockfile.InstallPath(satisfactoryPath)
+		}
+		if commandName == "lock" {
+			lock, lockErr := lockfile.FromInstalled(reporter, satisfactoryPath)
+			check(lockErr)
+			check(lockfile.Write(lockfilePath, lock))
+			fmt.Fprintln(messageWriter, "Locked "+strconv.Itoa(len(lock.Mods))+" mods to "+lockfilePath)
+			printResult(operations.LockResult{Path: lockfilePath, Mods: lock.Mods})
+		} else if commandName == "sync" {
+			lock, readErr := lockfile.Read(lockfilePath)
+			check(readErr)
+			check(lockfile.Sync(reporter, lock, satisfactoryPath))
+			fmt.Fprintln(messageWriter, "Synced "+strconv.Itoa(len(lock.Mods))+" mods from "+lockfilePath)
+			printResult(operations.LockResult{Path: lockfilePath, Mods: lock.Mods})
 		}
 	} else if commandName == "list" {
-		mods := modhandler.GetDownloadedMods()
+		mods, getDownloadedErr := modhandler.GetDownloadedMods()
+		check(getDownloadedErr)
 		for _, mod := range mods {
-			fmt.Println(mod.Name + " (" + mod.ModID + ")" + " - " + mod.Version)
+			fmt.Fprintln(messageWriter, mod.Name+" ("+mod.ModID+")"+" - "+mod.Version)
 		}
+		printResult(mods)
 	} else if commandName == "list_installed" {
-		satisfactoryPathParam := parser.String("p", "path", &argparse.Options{Required: true, Help: "satisfactory install path (ending in Binaries/Win64)"})
-		parseErr := parser.Parse(args)
-		util.Check(parseErr)
-		satisfactoryPath := *satisfactoryPathParam
-		mods := modhandler.GetInstalledMods(satisfactoryPath)
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
+		parseArgs(parser)
+		satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
+		mods, getInstalledErr := modhandler.GetInstalledModsWithState(satisfactoryPath)
+		check(getInstalledErr)
 		for _, mod := range mods {
-			fmt.Println(mod.Name + " (" + mod.ModID + ")" + " - " + mod.Version)
+			notes := []string{}
+			if mod.AutoInstalled {
+				notes = append(notes, "dependency")
+			}
+			if len(mod.OptionalFor) > 0 {
+				notes = append(notes, "optional dependency of "+strings.Join(mod.OptionalFor, ", "))
+			}
+			if len(notes) > 0 {
+				fmt.Fprintln(messageWriter, mod.Name+" ("+mod.ModID+")"+" - "+mod.Version+" ("+strings.Join(notes, "; ")+")")
+			} else {
+				fmt.Fprintln(messageWriter, mod.Name+" ("+mod.ModID+")"+" - "+mod.Version)
+			}
+		}
+		printResult(mods)
+	} else if commandName == "deps" {
+		modIDParam := parser.String("m", "mod", &argparse.Options{Required: true, Help: "mod ID"})
+		versionParam := parser.String("v", "version", &argparse.Options{Required: false, Help: "mod version (defaults to the installed or latest downloaded one)"})
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
+		parseArgs(parser)
+		satisfactoryPath := optionalInstallPath(*satisfactoryPathParam, *installNameParam)
+		tree, treeErr := modhandler.GetDependencyTree(*modIDParam, *versionParam, satisfactoryPath)
+		check(treeErr)
+		printDependencyTree(tree, 0)
+		printResult(tree)
+	} else if commandName == "why" {
+		modIDParam := parser.String("m", "mod", &argparse.Options{Required: true, Help: "mod ID"})
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
+		parseArgs(parser)
+		satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
+		requirementPaths, pathsErr := modhandler.GetRequirementPaths(*modIDParam, satisfactoryPath)
+		check(pathsErr)
+		for _, requirementPath := range requirementPaths {
+			fmt.Fprintln(messageWriter, formatRequirementPath(requirementPath, *modIDParam))
+		}
+		if len(requirementPaths) == 0 {
+			fmt.Fprintln(messageWriter, "No installed mod requires "+*modIDParam)
+		}
+		printResult(operations.WhyResult{ModID: *modIDParam, Paths: requirementPaths})
+	} else if commandName == "list_installs" {
+		installs := satisfactoryinstall.FindSatisfactoryInstalls()
+		for _, install := range installs {
+			version := install.Version
+			if version == "" {
+				version = "unknown version"
+			}
+			fmt.Fprintln(messageWriter, install.Name+" ("+install.Branch+", "+version+", "+install.Source+") - "+install.BinariesPath)
 		}
+		if len(installs) == 0 {
+			fmt.Fprintln(messageWriter, "No Satisfactory installs found")
+		}
+		printResult(installs)
+	} else if commandName == "install_add" || commandName == "install_remove" || commandName == "install_default" || commandName == "install_list" {
+		if commandName == "install_add" {
+			nameParam := parser.String("n", "name", &argparse.Options{Required: false, Help: "install name"})
+			satisfactoryPathParam := parser.String("p", "path", &argparse.Options{Required: false, Help: "satisfactory install path (ending in Binaries/Win64)"})
+			discoverParam := parser.Flag("d", "discover", &argparse.Options{Required: false, Help: "register the discovered installs, named after their branch"})
+			parseArgs(parser)
+			if *discoverParam {
+				added, addErr := satisfactoryinstall.AddDiscoveredInstalls()
+				check(addErr)
+				for _, install := range added {
+					fmt.Fprintln(messageWriter, "Added install "+install.Name+" - "+install.Path)
+				}
+				if len(added) == 0 {
+					fmt.Fprintln(messageWriter, "No new installs found")
+				}
+			} else {
+				if *nameParam == "" || *satisfactoryPathParam == "" {
+					check(fmt.Errorf("%w: install_add needs -n and -p, or -d", errInvalidArguments))
+				}
+				check(satisfactoryinstall.AddInstall(*nameParam, *satisfactoryPathParam))
+				fmt.Fprintln(messageWriter, "Added install "+*nameParam)
+			}
+		} else if commandName == "install_remove" || commandName == "install_default" {
+			nameParam := parser.String("n", "name", &argparse.Options{Required: true, Help: "install name"})
+			parseArgs(parser)
+			if commandName == "install_remove" {
+				check(satisfactoryinstall.RemoveInstall(*nameParam))
+				fmt.Fprintln(messageWriter, "Removed install "+*nameParam)
+			} else {
+				check(satisfactoryinstall.SetDefaultInstall(*nameParam))
+				fmt.Fprintln(messageWriter, "Default install is now "+*nameParam)
+			}
+		}
+		registry, readErr := satisfactoryinstall.ReadRegistry()
+		check(readErr)
+		if commandName == "install_list" {
+			for _, install := range registry.Installs {
+				if install.Name == registry.Default {
+					fmt.Fprintln(messageWriter, install.Name+" (default) - "+install.Path)
+				} else {
+					fmt.Fprintln(messageWriter, install.Name+" - "+install.Path)
+				}
+			}
+		}
+		printResult(registry)
 	} else if commandName == "install_sml" || commandName == "uninstall_sml" || commandName == "update_sml" || commandName == "sml_version" {
-		satisfactoryPathParam := parser.String("p", "path", &argparse.Options{Required: true, Help: "satisfactory install path (ending in Binaries/Win64)"})
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
 		if commandName == "sml_version" {
-			parseErr := parser.Parse(args)
-			util.Check(parseErr)
-			satisfactoryPath := *satisfactoryPathParam
-			fmt.Println(smlhandler.GetInstalledVersion(satisfactoryPath))
+			parseArgs(parser)
+			satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
+			installedVersion, getInstalledErr := smlhandler.GetInstalledVersion(satisfactoryPath)
+			check(getInstalledErr)
+			fmt.Fprintln(messageWriter, installedVersion)
+			printResult(operations.VersionResult{Version: installedVersion})
 		} else if commandName == "install_sml" {
 			smlVersionParam := parser.String("v", "version", &argparse.Options{Required: false, Help: "SML version"})
-			parseErr := parser.Parse(args)
-			util.Check(parseErr)
+			parseArgs(parser)
 			smlVersion := *smlVersionParam
-			satisfactoryPath := *satisfactoryPathParam
+			satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
 			if smlVersion == "" {
-				smlVersion = smlhandler.GetLatestSML().Version
+				latestSML, getLatestErr := smlhandler.GetLatestSML()
+				check(getLatestErr)
+				smlVersion = latestSML.Version
 			}
-			installErr := smlhandler.InstallSML(satisfactoryPath, smlVersion)
-			util.Check(installErr)
-			fmt.Println("Installed SML@" + smlVersion)
+			installErr := smlhandler.InstallSML(reporter, satisfactoryPath, smlVersion)
+			check(installErr)
+			fmt.Fprintln(messageWriter, "Installed SML@"+smlVersion)
+			printResult(operations.VersionResult{Version: smlVersion})
 		} else if commandName == "update_sml" {
-			parseErr := parser.Parse(args)
-			util.Check(parseErr)
-			satisfactoryPath := *satisfactoryPathParam
-			updateErr := smlhandler.UpdateSML(satisfactoryPath)
-			util.Check(updateErr)
-			fmt.Println("Updated to SML@" + smlhandler.GetInstalledVersion(satisfactoryPath))
+			parseArgs(parser)
+			satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
+			updateErr := smlhandler.UpdateSML(reporter, satisfactoryPath)
+			check(updateErr)
+			installedVersion, getInstalledErr := smlhandler.GetInstalledVersion(satisfactoryPath)
+			check(getInstalledErr)
+			fmt.Fprintln(messageWriter, "Updated to SML@"+installedVersion)
+			printResult(operations.VersionResult{Version: installedVersion})
 		} else if commandName == "uninstall_sml" {
-			parseErr := parser.Parse(args)
-			util.Check(parseErr)
-			satisfactoryPath := *satisfactoryPathParam
+			parseArgs(parser)
+			satisfactoryPath := installPath(*satisfactoryPathParam, *installNameParam)
 			uninstallErr := smlhandler.UninstallSML(satisfactoryPath)
-			util.Check(uninstallErr)
-			fmt.Println("Uninstalled SML")
+			check(uninstallErr)
+			fmt.Fprintln(messageWriter, "Uninstalled SML")
+			printResult(operations.VersionResult{Version: ""})
 		}
 	} else if commandName == "check_updates" {
-		satisfactoryPathParam := parser.String("p", "path", &argparse.Options{Required: false, Help: "satisfactory install path (ending in Binaries/Win64)"})
-		autoInstallParam := parser.Flag("i", "install", &argparse.Options{Required: false, Help: "Automatically download and install the updates"})
-		parseErr := parser.Parse(args)
-		util.Check(parseErr)
-		satisfactoryPath := *satisfactoryPathParam
+		satisfactoryPathParam, installNameParam := addInstallParams(&parser.Command)
+		autoInstallParam := parser.Flag("i", "apply", &argparse.Options{Required: false, Help: "Download the mod updates, without changing the installed mods, and install the SML update. Was --install, which now selects a registered install"})
+		parseArgs(parser)
+		satisfactoryPath := optionalInstallPath(*satisfactoryPathParam, *installNameParam)
 		autoInstall := *autoInstallParam
-		hasModUpdates := modhandler.CheckForUpdates(autoInstall)
-		hasSMLUpdates := false
-		if satisfactoryPath != "" {
-			hasSMLUpdates = smlhandler.CheckForUpdates(satisfactoryPath, autoInstall)
+		modUpdates, modUpdatesErr := modhandler.CheckForUpdates(reporter, autoInstall)
+		check(modUpdatesErr)
+		for _, update := range modUpdates {
+			if update.Updated {
+				fmt.Fprintln(messageWriter, "Updated "+update.ModID+" to "+formatVersion(update.LatestVersion, update.Stability))
+			} else {
+				fmt.Fprintln(messageWriter, update.ModID+"@"+formatVersion(update.LatestVersion, update.Stability)+" available")
+			}
+		}
+		var smlUpdate *smlhandler.Update
+		if satisfactoryPath != "" && ficsitapp.Offline() {
+			fmt.Fprintln(messageWriter, "Skipping the SML update check in offline mode")
+		} else if satisfactoryPath != "" {
+			var smlUpdatesErr error
+			smlUpdate, smlUpdatesErr = smlhandler.CheckForUpdates(reporter, satisfactoryPath, autoInstall)
+			check(smlUpdatesErr)
+			if smlUpdate != nil && smlUpdate.Updated {
+				fmt.Fprintln(messageWriter, "Updated SML to "+smlUpdate.LatestVersion)
+			} else if smlUpdate != nil {
+				fmt.Fprintln(messageWriter, "SML@"+smlUpdate.LatestVersion+" available")
+			}
+		}
+		if len(modUpdates) == 0 && smlUpdate == nil {
+			fmt.Fprintln(messageWriter, "Already up to date")
+		}
+		printResult(operations.CheckUpdatesResult{Mods: modUpdates, SML: smlUpdate})
+	} else if commandName == "profile" {
+		createCommand := parser.NewCommand("create", "creates an empty profile")
+		createNameParam := createCommand.String("n", "name", &argparse.Options{Required: true, Help: "profile name"})
+		addCommand := parser.NewCommand("add", "adds a mod to a profile")
+		addNameParam := addCommand.String("n", "name", &argparse.Options{Required: true, Help: "profile name"})
+		addModIDParam := addCommand.String("m", "mod", &argparse.Options{Required: true, Help: "ficsit.app mod ID"})
+		addVersionParam := addCommand.String("v", "version", &argparse.Options{Required: false, Help: "mod version constraint (defaults to any version)"})
+		removeCommand := parser.NewCommand("remove", "removes a mod from a profile")
+		removeNameParam := removeCommand.String("n", "name", &argparse.Options{Required: true, Help: "profile name"})
+		removeModIDParam := removeCommand.String("m", "mod", &argparse.Options{Required: true, Help: "ficsit.app mod ID"})
+		listCommand := parser.NewCommand("list", "lists the profiles, or the mods of a profile")
+		listNameParam := listCommand.String("n", "name", &argparse.Options{Required: false, Help: "profile name"})
+		applyCommand := parser.NewCommand("apply", "makes the installed mods match the profile")
+		applyNameParam := applyCommand.String("n", "name", &argparse.Options{Required: true, Help: "profile name"})
+		applySatisfactoryPathParam, applyInstallNameParam := addInstallParams(applyCommand)
+		parseArgs(parser)
+		if createCommand.Happened() {
+			check(profiles.Create(*createNameParam))
+			fmt.Fprintln(messageWriter, "Created profile "+*createNameParam)
+			printProfileResult(*createNameParam)
+		} else if addCommand.Happened() {
+			check(profiles.AddMod(*addNameParam, *addModIDParam, *addVersionParam))
+			fmt.Fprintln(messageWriter, "Added "+*addModIDParam+" to profile "+*addNameParam)
+			printProfileResult(*addNameParam)
+		} else if removeCommand.Happened() {
+			check(profiles.RemoveMod(*removeNameParam, *removeModIDParam))
+			fmt.Fprintln(messageWriter, "Removed "+*removeModIDParam+" from profile "+*removeNameParam)
+			printProfileResult(*removeNameParam)
+		} else if listCommand.Happened() {
+			if *listNameParam == "" {
+				profileNames, listErr := profiles.List()
+				check(listErr)
+				for _, profileName := range profileNames {
+					fmt.Fprintln(messageWriter, profileName)
+				}
+				printResult(profileNames)
+			} else {
+				profile, getErr := profiles.Get(*listNameParam)
+				check(getErr)
+				for _, mod := range profile.Mods {
+					fmt.Fprintln(messageWriter, mod.ModID+"@"+mod.VersionConstraint)
+				}
+				printResult(profile)
+			}
+		} else if applyCommand.Happened() {
+			satisfactoryPath := installPath(*applySatisfactoryPathParam, *applyInstallNameParam)
+			check(operations.ApplyProfile(reporter, *applyNameParam, satisfactoryPath))
+			fmt.Fprintln(messageWriter, "Applied profile "+*applyNameParam)
+			printProfileResult(*applyNameParam)
+		}
+	} else if commandName == "search" {
+		if len(args) < 2 {
+			check(fmt.Errorf("%w: usage: search <text>", errInvalidArguments))
+		}
+		mods, searchErr := ficsitapp.SearchMods(reporter, strings.Join(args[1:], " "), searchResultsLimit)
+		check(searchErr)
+		for _, mod := range mods {
+			latestVersion := "no versions"
+			if latest := mod.LatestVersionWithStability(ficsitapp.ModStability(mod.ID)); latest != nil {
+				latestVersion = formatVersion(latest.Version, latest.Stability)
+			}
+			fmt.Fprintln(messageWriter, mod.Name+" ("+mod.ID+") by "+formatAuthors(mod.Authors)+" - "+latestVersion+" - "+strconv.Itoa(mod.Downloads)+" downloads")
+			fmt.Fprintln(messageWriter, "\t"+mod.ShortDescription)
+		}
+		printResult(mods)
+	} else if commandName == "info" {
+		modIDParam := parser.String("m", "mod", &argparse.Options{Required: true, Help: "ficsit.app mod ID"})
+		parseArgs(parser)
+		mod, getInfoErr := ficsitapp.GetModInfo(reporter, *modIDParam)
+		check(getInfoErr)
+		fmt.Fprintln(messageWriter, mod.Name+" ("+mod.ID+")")
+		fmt.Fprintln(messageWriter, "Authors: "+formatAuthors(mod.Authors))
+		fmt.Fprintln(messageWriter, "Downloads: "+strconv.Itoa(mod.Downloads))
+		fmt.Fprintln(messageWriter)
+		fmt.Fprintln(messageWriter, mod.FullDescription)
+		fmt.Fprintln(messageWriter)
+		fmt.Fprintln(messageWriter, "Versions:")
+		for _, version := range mod.Versions {
+			fmt.Fprintln(messageWriter, "\t"+version.Version+" ("+version.Stability+", released "+version.CreatedAt.Format("2006-01-02")+")")
+			for _, dependency := range version.Dependencies {
+				if dependency.Optional {
+					fmt.Fprintln(messageWriter, "\t\toptionally depends on "+dependency.ModID+"@"+dependency.Condition)
+				} else {
+					fmt.Fprintln(messageWriter, "\t\tdepends on "+dependency.ModID+"@"+dependency.Condition)
+				}
+			}
+		}
+		printResult(mod)
+	} else if commandName == "config" {
+		usage := fmt.Errorf("%w: usage: config get <name> | set <name> <value> | unset <name> | list", errInvalidArguments)
+		if len(args) < 2 {
+			check(usage)
+		}
+		subcommand := args[1]
+		if subcommand == "list" {
+			result := []operations.SettingResult{}
+			for _, setting := range config.Settings() {
+				value, source := config.Value(setting.Name)
+				fmt.Fprintln(messageWriter, setting.Name+" = "+value+" ("+source+")")
+				result = append(result, operations.SettingResult{Name: setting.Name, Value: value, Source: source, Env: setting.Env, Default: setting.Default, Description: setting.Description})
+			}
+			printResult(result)
+		} else if subcommand == "get" && len(args) == 3 {
+			setting, ok := config.Lookup(args[2])
+			if !ok {
+				check(fmt.Errorf("%w: %s", config.ErrUnknownSetting, args[2]))
+			}
+			value, source := config.Value(setting.Name)
+			fmt.Fprintln(messageWriter, value)
+			printResult(operations.SettingResult{Name: setting.Name, Value: value, Source: source, Env: setting.Env, Default: setting.Default, Description: setting.Description})
+		} else if subcommand == "set" && len(args) == 4 {
+			check(config.Set(args[2], args[3]))
+			fmt.Fprintln(messageWriter, "Set "+args[2]+" to "+args[3])
+			if value, source := config.Value(args[2]); source != config.SourceFile {
+				fmt.Fprintln(messageWriter, "Note: "+args[2]+" is currently "+value+" from the "+source)
+			}
+			printResult(operations.SettingResult{Name: args[2], Value: args[3], Source: config.SourceFile})
+		} else if subcommand == "unset" && len(args) == 3 {
+			check(config.Unset(args[2]))
+			fmt.Fprintln(messageWriter, "Unset "+args[2])
+			value, source := config.Value(args[2])
+			printResult(operations.SettingResult{Name: args[2], Value: value, Source: source})
+		} else {
+			check(usage)
+		}
+	} else if commandName == "stability" {
+		modIDParam := parser.String("m", "mod", &argparse.Options{Required: false, Help: "ficsit.app mod ID, to set the stability of only this mod"})
+		setParam := parser.Selector("s", "set", []string{ficsitapp.StabilityAlpha, ficsitapp.StabilityBeta, ficsitapp.StabilityRelease}, &argparse.Options{Required: false, Help: "least stable versions to use"})
+		resetParam := parser.Flag("r", "reset", &argparse.Options{Required: false, Help: "make the mod follow the global stability again"})
+		parseArgs(parser)
+		modID := *modIDParam
+		if *resetParam {
+			if modID == "" {
+				check(fmt.Errorf("%w: --reset needs a mod", errInvalidArguments))
+			}
+			check(ficsitapp.SetModStability(modID, ""))
+		} else if *setParam != "" && modID != "" {
+			check(ficsitapp.SetModStability(modID, *setParam))
+		} else if *setParam != "" {
+			check(config.Set("stability", *setParam))
+		}
+		result := operations.StabilityResult{Stability: config.Get("stability"), Mods: ficsitapp.ModStabilities()}
+		fmt.Fprintln(messageWriter, "All mods: "+result.Stability)
+		modIDs := []string{}
+		for stabilityModID := range result.Mods {
+			modIDs = append(modIDs, stabilityModID)
 		}
-		if !hasModUpdates && !hasSMLUpdates {
-			fmt.Println("Already up to date")
+		sort.Strings(modIDs)
+		for _, stabilityModID := range modIDs {
+			fmt.Fprintln(messageWriter, stabilityModID+": "+result.Mods[stabilityModID])
 		}
+		printResult(result)
+	} else if commandName == "serve" {
+		addressParam := parser.String("a", "address", &argparse.Options{Required: false, Help: "address to listen on", Default: daemon.DefaultAddress})
+		parseArgs(parser)
+		check(daemon.CheckAddress(*addressParam))
+		server, newErr := daemon.New(errorCode)
+		check(newErr)
+		log.Println("Serving the API on http://" + *addressParam + ", send the token in " + daemon.TokenPath() + " as \"Authorization: Bearer <token>\"")
+		check(http.ListenAndServe(*addressParam, server))
 	} else if commandName == "mods_dir" {
-		fmt.Println(paths.ModsDir)
+		fmt.Fprintln(messageWriter, paths.ModsDir)
+		printResult(operations.PathResult{Path: paths.ModsDir})
+	} else if commandName == "dirs" {
+		fmt.Fprintln(messageWriter, "Data: "+paths.SMLauncherDir)
+		fmt.Fprintln(messageWriter, "Mods: "+paths.ModsDir)
+		fmt.Fprintln(messageWriter, "Cache: "+paths.CacheDir)
+		fmt.Fprintln(messageWriter, "Config: "+paths.ConfigDir)
+		printResult(operations.DirsResult{Data: paths.SMLauncherDir, Mods: paths.ModsDir, Cache: paths.CacheDir, Config: paths.ConfigDir})
 	} else if commandName == "version" {
-		fmt.Println(smlauncherVersion)
+		fmt.Fprintln(messageWriter, smlauncherVersion)
+		printResult(operations.VersionResult{Version: smlauncherVersion})
 	} else {
-		log.Println("Unrecognized command \"" + commandName + "\"")
+		check(fmt.Errorf("%w \"%s\"", errUnknownCommand, commandName))
 	}
 }
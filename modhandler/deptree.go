@@ -0,0 +1,178 @@
+package modhandler
+
+import (
+	"sort"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/version"
+)
+
+// DependencyNode is a mod in a dependency tree, with the version picked for it from the installed and downloaded mods
+type DependencyNode struct {
+	ModID string `json:"mod_id"`
+	// Constraint is what the parent requires, empty for the root
+	Constraint string `json:"constraint,omitempty"`
+	Optional   bool   `json:"optional"`
+	// Version is empty if no installed or downloaded version meets the constraint
+	Version    string `json:"version"`
+	Downloaded bool   `json:"downloaded"`
+	Installed  bool   `json:"installed"`
+	// Cycle is set when the mod already appears above in the tree, its dependencies are not repeated
+	Cycle        bool             `json:"cycle,omitempty"`
+	Dependencies []DependencyNode `json:"dependencies"`
+}
+
+// RequirementLink is an installed mod in a requirement path, and the constraint it puts on the next mod of the path
+type RequirementLink struct {
+	ModID      string `json:"mod_id"`
+	Version    string `json:"version"`
+	Constraint string `json:"constraint"`
+	Optional   bool   `json:"optional"`
+}
+
+// dependencyTreeBuilder finds the data.json of the mods in the tree, preferring the installed versions
+type dependencyTreeBuilder struct {
+	installed map[string]DataJSON
+}
+
+// resolve picks the installed version of the mod if it meets the constraint, or the latest downloaded one that does
+func (builder dependencyTreeBuilder) resolve(node *DependencyNode) (DataJSON, bool) {
+	if installedMod, ok := builder.installed[node.ModID]; ok && version.Satisfies(installedMod.Version, node.Constraint) {
+		node.Version = installedMod.Version
+		node.Installed = true
+		_, findErr := FindModZip(node.ModID, node.Version)
+		node.Downloaded = findErr == nil
+		return installedMod, true
+	}
+	downloadedVersion, _ := GetDownloadedModVersionWithConstraint(node.ModID, node.Constraint)
+	if downloadedVersion == "" {
+		return DataJSON{}, false
+	}
+	modZip, findErr := FindModZip(node.ModID, downloadedVersion)
+	if findErr != nil {
+		return DataJSON{}, false
+	}
+	modData, dataErr := GetDataFromZip(modZip)
+	if dataErr != nil {
+		return DataJSON{}, false
+	}
+	node.Version = downloadedVersion
+	node.Downloaded = true
+	return modData, true
+}
+
+func (builder dependencyTreeBuilder) addDependencies(node *DependencyNode, modData DataJSON, ancestors map[string]bool) {
+	node.Dependencies = []DependencyNode{}
+	addNodes := func(dependencies map[string]string, optional bool) {
+		dependencyIDs := []string{}
+		for dependencyID := range dependencies {
+			dependencyIDs = append(dependencyIDs, dependencyID)
+		}
+		sort.Strings(dependencyIDs)
+		for _, dependencyID := range dependencyIDs {
+			dependency := DependencyNode{ModID: dependencyID, Constraint: dependencies[dependencyID], Optional: optional}
+			dependencyData, found := builder.resolve(&dependency)
+			if ancestors[dependencyID] {
+				dependency.Cycle = true
+				dependency.Dependencies = []DependencyNode{}
+			} else if found {
+				ancestors[dependencyID] = true
+				builder.addDependencies(&dependency, dependencyData, ancestors)
+				delete(ancestors, dependencyID)
+			} else {
+				dependency.Dependencies = []DependencyNode{}
+			}
+			node.Dependencies = append(node.Dependencies, dependency)
+		}
+	}
+	addNodes(modData.Dependencies, false)
+	addNodes(modData.OptDependencies, true)
+}
+
+// GetDependencyTree returns the transitive dependencies of the downloaded or installed mod version, required and optional.
+// An empty version uses the installed or latest downloaded one. The install path is optional
+func GetDependencyTree(modID string, modVersion string, smlPath string) (DependencyNode, error) {
+	builder := dependencyTreeBuilder{installed: map[string]DataJSON{}}
+	if smlPath != "" {
+		installedMods, getInstalledErr := GetInstalledMods(smlPath)
+		if getInstalledErr != nil {
+			return DependencyNode{}, getInstalledErr
+		}
+		for _, installedMod := range installedMods {
+			builder.installed[installedMod.ModID] = installedMod
+		}
+	}
+	root := DependencyNode{ModID: modID}
+	var rootData DataJSON
+	if installedMod, ok := builder.installed[modID]; ok && (modVersion == "" || installedMod.Version == modVersion) {
+		rootData = installedMod
+		root.Version = installedMod.Version
+		root.Installed = true
+		_, findErr := FindModZip(modID, root.Version)
+		root.Downloaded = findErr == nil
+	} else {
+		if modVersion == "" {
+			latestVersion, getLatestErr := GetLatestDownloadedVersion(modID)
+			if getLatestErr != nil {
+				return DependencyNode{}, getLatestErr
+			}
+			modVersion = latestVersion
+		}
+		modZip, findErr := FindModZip(modID, modVersion)
+		if findErr != nil {
+			return DependencyNode{}, findErr
+		}
+		var dataErr error
+		rootData, dataErr = GetDataFromZip(modZip)
+		if dataErr != nil {
+			return DependencyNode{}, dataErr
+		}
+		root.Version = modVersion
+		root.Downloaded = true
+	}
+	builder.addDependencies(&root, rootData, map[string]bool{modID: true})
+	return root, nil
+}
+
+// GetRequirementPaths returns every chain of installed mods that requires the mod. Each path starts at a mod that no other installed mod requires,
+// and ends with a mod that requires the given one directly. Optional dependencies are included and marked
+func GetRequirementPaths(modID string, smlPath string) ([][]RequirementLink, error) {
+	installedMods, getInstalledErr := GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return nil, getInstalledErr
+	}
+	sort.Slice(installedMods, func(i, j int) bool {
+		return installedMods[i].ModID < installedMods[j].ModID
+	})
+	// requirers returns the links of the installed mods that depend on the mod directly
+	requirers := func(targetID string) []RequirementLink {
+		links := []RequirementLink{}
+		for _, installedMod := range installedMods {
+			if constraint, ok := installedMod.Dependencies[targetID]; ok {
+				links = append(links, RequirementLink{installedMod.ModID, installedMod.Version, constraint, false})
+			} else if constraint, ok := installedMod.OptDependencies[targetID]; ok {
+				links = append(links, RequirementLink{installedMod.ModID, installedMod.Version, constraint, true})
+			}
+		}
+		return links
+	}
+	paths := [][]RequirementLink{}
+	var walk func(targetID string, path []RequirementLink, visited map[string]bool)
+	walk = func(targetID string, path []RequirementLink, visited map[string]bool) {
+		links := requirers(targetID)
+		extended := false
+		for _, link := range links {
+			if visited[link.ModID] {
+				continue
+			}
+			extended = true
+			visited[link.ModID] = true
+			walk(link.ModID, append([]RequirementLink{link}, path...), visited)
+			delete(visited, link.ModID)
+		}
+		if !extended && len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	walk(modID, []RequirementLink{}, map[string]bool{modID: true})
+	return paths, nil
+}
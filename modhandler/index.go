@@ -0,0 +1,147 @@
+package modhandler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+// indexFile keeps the data.json and checksum of the mod zips that were read, so they are not opened again. It is kept in paths.SMLauncherDir
+const indexFile = "mod-index.json"
+
+// indexEntry is the metadata of a zip. It is only used while the zip has the same size and modification time
+type indexEntry struct {
+	Size    int64    `json:"size"`
+	ModTime int64    `json:"mod_time"`
+	Data    DataJSON `json:"data"`
+	// SHA256 is empty until the checksum is needed
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// modIndex is the index of the zips by path, loaded from the index file on first use
+type modIndex struct {
+	lock    sync.Mutex
+	loaded  bool
+	changed bool
+	entries map[string]indexEntry
+}
+
+var index = &modIndex{}
+
+func indexPath() string {
+	return path.Join(paths.SMLauncherDir, indexFile)
+}
+
+// load reads the index file. A missing or broken index is started again, as everything in it can be read from the zips
+func (idx *modIndex) load() {
+	if idx.loaded {
+		return
+	}
+	idx.loaded = true
+	idx.entries = map[string]indexEntry{}
+	content, readErr := ioutil.ReadFile(indexPath())
+	if readErr != nil {
+		return
+	}
+	if json.Unmarshal(content, &idx.entries) != nil {
+		idx.entries = map[string]indexEntry{}
+	}
+}
+
+// entry returns the index entry of the zip, reading the zip if it is not indexed or changed since
+func (idx *modIndex) entry(zipPath string) (indexEntry, error) {
+	info, statErr := os.Stat(zipPath)
+	idx.lock.Lock()
+	idx.load()
+	entry, ok := idx.entries[zipPath]
+	if statErr != nil && ok {
+		delete(idx.entries, zipPath)
+		idx.changed = true
+	}
+	idx.lock.Unlock()
+	if statErr != nil {
+		return indexEntry{}, statErr
+	}
+	if ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+		return entry, nil
+	}
+	data, dataErr := readDataFromZip(zipPath)
+	if dataErr != nil {
+		return indexEntry{}, dataErr
+	}
+	entry = indexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Data: data}
+	idx.lock.Lock()
+	idx.entries[zipPath] = entry
+	idx.changed = true
+	idx.lock.Unlock()
+	return entry, nil
+}
+
+// hash returns the SHA-256 of the zip, computing it if the index does not have it yet
+func (idx *modIndex) hash(zipPath string) (string, error) {
+	entry, entryErr := idx.entry(zipPath)
+	if entryErr != nil {
+		return "", entryErr
+	}
+	if entry.SHA256 != "" {
+		return entry.SHA256, nil
+	}
+	hash, hashErr := util.Sha256File(zipPath)
+	if hashErr != nil {
+		return "", hashErr
+	}
+	entry.SHA256 = hash
+	idx.lock.Lock()
+	idx.entries[zipPath] = entry
+	idx.changed = true
+	idx.lock.Unlock()
+	return hash, nil
+}
+
+// save writes the index file if it changed, leaving out the zips that do not exist any more.
+// Failing to write it only means the zips are read again next time, so errors are ignored
+func (idx *modIndex) save() {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if !idx.changed {
+		return
+	}
+	idx.changed = false
+	for zipPath := range idx.entries {
+		if !paths.Exists(zipPath) {
+			delete(idx.entries, zipPath)
+		}
+	}
+	content, jsonErr := json.Marshal(idx.entries)
+	if jsonErr != nil {
+		return
+	}
+	if os.MkdirAll(paths.SMLauncherDir, os.ModePerm) != nil {
+		return
+	}
+	// other launcher processes may read the index at the same time, so it is replaced rather than written in place
+	tmpFile, tmpErr := ioutil.TempFile(paths.SMLauncherDir, indexFile+".*.tmp")
+	if tmpErr != nil {
+		return
+	}
+	_, writeErr := tmpFile.Write(content)
+	closeErr := tmpFile.Close()
+	if writeErr != nil || closeErr != nil || os.Rename(tmpFile.Name(), indexPath()) != nil {
+		os.Remove(tmpFile.Name())
+	}
+}
+
+// GetModZipHash returns the SHA-256 checksum of a mod zip, from the index if the zip did not change since it was computed
+func GetModZipHash(zipPath string) (string, error) {
+	return index.hash(zipPath)
+}
+
+// SaveIndex writes the changes to the mod index. Saving rewrites the whole index, so it is called once at the end of each command
+func SaveIndex() {
+	index.save()
+}
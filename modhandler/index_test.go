@@ -0,0 +1,129 @@
+package modhandler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/fakeserver"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+// setupIndex uses a new data directory with a zip of A@1.0.0 in it, and returns the path of the zip.
+// The returned function removes them
+func setupIndex(t *testing.T) (string, func()) {
+	dataDir, tempErr := ioutil.TempDir("", "modhandler")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	if setErr := paths.SetDataDir(dataDir, false); setErr != nil {
+		os.RemoveAll(dataDir)
+		t.Fatal(setErr)
+	}
+	zipPath := path.Join(dataDir, "A_1.0.0.zip")
+	writeZip(t, zipPath, "1.0.0")
+	return zipPath, func() { os.RemoveAll(dataDir) }
+}
+
+func writeZip(t *testing.T, zipPath string, version string) {
+	if writeErr := ioutil.WriteFile(zipPath, fakeserver.ModZip("A", version, nil, nil), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+}
+
+func entryVersion(t *testing.T, idx *modIndex, zipPath string) string {
+	entry, entryErr := idx.entry(zipPath)
+	if entryErr != nil {
+		t.Fatal(entryErr)
+	}
+	return entry.Data.Version
+}
+
+func TestIndexEntryInvalidated(t *testing.T) {
+	zipPath, cleanup := setupIndex(t)
+	defer cleanup()
+	idx := &modIndex{}
+	entry, entryErr := idx.entry(zipPath)
+	if entryErr != nil {
+		t.Fatal(entryErr)
+	}
+	if entry.Data.Version != "1.0.0" {
+		t.Fatalf("indexed version = %s, want 1.0.0", entry.Data.Version)
+	}
+	// an entry of the same size and modification time is used without reading the zip
+	stale := entry
+	stale.Data.Version = "indexed"
+	idx.entries[zipPath] = stale
+	if version := entryVersion(t, idx, zipPath); version != "indexed" {
+		t.Errorf("version of an unchanged zip = %s, want the indexed one", version)
+	}
+
+	stale.Size++
+	idx.entries[zipPath] = stale
+	if version := entryVersion(t, idx, zipPath); version != "1.0.0" {
+		t.Errorf("version of a zip of another size = %s, want it read again as 1.0.0", version)
+	}
+
+	stale.Size--
+	idx.entries[zipPath] = stale
+	modTime := time.Unix(0, stale.ModTime).Add(time.Minute)
+	if chtimesErr := os.Chtimes(zipPath, modTime, modTime); chtimesErr != nil {
+		t.Fatal(chtimesErr)
+	}
+	if version := entryVersion(t, idx, zipPath); version != "1.0.0" {
+		t.Errorf("version of a zip modified since = %s, want it read again as 1.0.0", version)
+	}
+
+	hash, hashErr := idx.hash(zipPath)
+	if hashErr != nil {
+		t.Fatal(hashErr)
+	}
+	writeZip(t, zipPath, "1.1.0")
+	if version := entryVersion(t, idx, zipPath); version != "1.1.0" {
+		t.Errorf("version of a replaced zip = %s, want 1.1.0", version)
+	}
+	if newHash, hashErr := idx.hash(zipPath); hashErr != nil || newHash == hash {
+		t.Errorf("checksum of a replaced zip = %s, %v, want it computed again", newHash, hashErr)
+	}
+}
+
+func TestIndexSaveAndLoad(t *testing.T) {
+	zipPath, cleanup := setupIndex(t)
+	defer cleanup()
+	idx := &modIndex{}
+	if _, hashErr := idx.hash(zipPath); hashErr != nil {
+		t.Fatal(hashErr)
+	}
+	idx.save()
+
+	loaded := &modIndex{}
+	loaded.load()
+	entry, ok := loaded.entries[zipPath]
+	if !ok || entry.Data.Version != "1.0.0" || entry.SHA256 == "" {
+		t.Errorf("loaded entry = %+v, %t, want the saved A@1.0.0 with its checksum", entry, ok)
+	}
+}
+
+func TestCorruptIndexIgnored(t *testing.T) {
+	zipPath, cleanup := setupIndex(t)
+	defer cleanup()
+	if writeErr := ioutil.WriteFile(indexPath(), []byte(`{"broken": `), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	idx := &modIndex{}
+	if version := entryVersion(t, idx, zipPath); version != "1.0.0" {
+		t.Errorf("version with a corrupt index = %s, want 1.0.0", version)
+	}
+	idx.save()
+	content, readErr := ioutil.ReadFile(indexPath())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	var entries map[string]indexEntry
+	if jsonErr := json.Unmarshal(content, &entries); jsonErr != nil || len(entries) != 1 {
+		t.Errorf("index saved over the corrupt one = %s, %v, want only the zip", content, jsonErr)
+	}
+}
@@ -0,0 +1,282 @@
+package modhandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/transaction"
+)
+
+// installStateFile records why the mods of an install were installed. It is kept in the mods dir, so transactions restore it with the mods
+const installStateFile = "smlauncher-installed.json"
+
+// ErrModRequired is returned when uninstalling a mod that other installed mods depend on
+var ErrModRequired = errors.New("mod is required by other installed mods")
+
+// installState lists the mods that were only installed as dependencies. Mods not in it were requested, including the ones installed by older launchers
+type installState struct {
+	AutoInstalled []string `json:"auto_installed"`
+}
+
+func installStatePath(smlPath string) string {
+	return path.Join(smlPath, "mods", installStateFile)
+}
+
+func readInstallState(smlPath string) (installState, error) {
+	state := installState{AutoInstalled: []string{}}
+	content, readErr := ioutil.ReadFile(installStatePath(smlPath))
+	if readErr != nil {
+		if os.IsNotExist(readErr) {
+			return state, nil
+		}
+		return state, readErr
+	}
+	if jsonErr := json.Unmarshal(content, &state); jsonErr != nil {
+		return state, fmt.Errorf("invalid %s: %w", installStatePath(smlPath), jsonErr)
+	}
+	return state, nil
+}
+
+// writeInstallState writes the state in the transaction tx, leaving out the mods that are not installed any more.
+// The file is replaced rather than written in place, as transaction backups hard link it
+func writeInstallState(tx *transaction.Transaction, smlPath string, state installState) error {
+	installedMods, getInstalledErr := GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return getInstalledErr
+	}
+	installed := map[string]bool{}
+	for _, installedMod := range installedMods {
+		installed[installedMod.ModID] = true
+	}
+	autoInstalled := []string{}
+	for _, modID := range state.AutoInstalled {
+		if installed[modID] {
+			autoInstalled = append(autoInstalled, modID)
+		}
+	}
+	sort.Strings(autoInstalled)
+	content, jsonErr := json.MarshalIndent(installState{autoInstalled}, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if mkdirErr := os.MkdirAll(path.Join(smlPath, "mods"), os.ModePerm); mkdirErr != nil {
+		return mkdirErr
+	}
+	statePath := installStatePath(smlPath)
+	if trackErr := tx.Track(statePath + ".tmp"); trackErr != nil {
+		return trackErr
+	}
+	if trackErr := tx.Track(statePath); trackErr != nil {
+		return trackErr
+	}
+	if writeErr := ioutil.WriteFile(statePath+".tmp", content, 0644); writeErr != nil {
+		os.Remove(statePath + ".tmp")
+		return writeErr
+	}
+	return os.Rename(statePath+".tmp", statePath)
+}
+
+func (state installState) isAutoInstalled(modID string) bool {
+	for _, autoInstalled := range state.AutoInstalled {
+		if autoInstalled == modID {
+			return true
+		}
+	}
+	return false
+}
+
+// InstalledMod is an installed mod, whether it was only installed as a dependency, and the installed mods it is an optional dependency of
+type InstalledMod struct {
+	DataJSON
+	AutoInstalled bool     `json:"auto_installed"`
+	OptionalFor   []string `json:"optional_for"`
+}
+
+// GetInstalledModsWithState returns the installed mods, whether they were only installed as dependencies, and which mods use them as optional dependencies
+func GetInstalledModsWithState(smlPath string) ([]InstalledMod, error) {
+	state, readErr := readInstallState(smlPath)
+	if readErr != nil {
+		return nil, readErr
+	}
+	installedMods, getInstalledErr := GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return nil, getInstalledErr
+	}
+	mods := []InstalledMod{}
+	for _, installedMod := range installedMods {
+		optionalFor := []string{}
+		for _, otherMod := range installedMods {
+			if _, ok := otherMod.OptDependencies[installedMod.ModID]; ok {
+				optionalFor = append(optionalFor, otherMod.ModID)
+			}
+		}
+		sort.Strings(optionalFor)
+		mods = append(mods, InstalledMod{installedMod, state.isAutoInstalled(installedMod.ModID), optionalFor})
+	}
+	return mods, nil
+}
+
+// IsAutoInstalled returns true if the mod was only installed as a dependency of other mods
+func IsAutoInstalled(modID string, smlPath string) (bool, error) {
+	state, readErr := readInstallState(smlPath)
+	if readErr != nil {
+		return false, readErr
+	}
+	return state.isAutoInstalled(modID), nil
+}
+
+// SetAutoInstalled marks the installed mods as installed only as dependencies, or as requested, in the transaction tx
+func SetAutoInstalled(tx *transaction.Transaction, modIDs []string, autoInstalled bool, smlPath string) error {
+	state, readErr := readInstallState(smlPath)
+	if readErr != nil {
+		return readErr
+	}
+	modIDSet := map[string]bool{}
+	for _, modID := range modIDs {
+		modIDSet[modID] = true
+	}
+	kept := []string{}
+	for _, modID := range state.AutoInstalled {
+		if !modIDSet[modID] {
+			kept = append(kept, modID)
+		}
+	}
+	if autoInstalled {
+		kept = append(kept, modIDs...)
+	}
+	state.AutoInstalled = kept
+	return writeInstallState(tx, smlPath, state)
+}
+
+// pruneInstallState removes the mods that are not installed any more from the state, in the transaction tx
+func pruneInstallState(tx *transaction.Transaction, smlPath string) error {
+	state, readErr := readInstallState(smlPath)
+	if readErr != nil {
+		return readErr
+	}
+	return writeInstallState(tx, smlPath, state)
+}
+
+// GetDependents returns the installed mods that require the mod, directly or through other mods
+func GetDependents(modID string, smlPath string) ([]DataJSON, error) {
+	installedMods, getInstalledErr := GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return nil, getInstalledErr
+	}
+	dependents := []DataJSON{}
+	found := map[string]bool{modID: true}
+	for changed := true; changed; {
+		changed = false
+		for _, installedMod := range installedMods {
+			if found[installedMod.ModID] {
+				continue
+			}
+			for dependencyID := range installedMod.Dependencies {
+				if found[dependencyID] {
+					found[installedMod.ModID] = true
+					dependents = append(dependents, installedMod)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// UninstallModWithDependents uninstalls the mod. If other installed mods require it, they are uninstalled too when cascade is set,
+// otherwise ErrModRequired is returned. Returns the uninstalled dependents
+func UninstallModWithDependents(modID string, modVersion string, smlPath string, cascade bool) ([]DataJSON, error) {
+	if _, findErr := GetInstalledModZip(modID, modVersion, smlPath); findErr != nil {
+		return nil, findErr
+	}
+	dependents, getDependentsErr := GetDependents(modID, smlPath)
+	if getDependentsErr != nil {
+		return nil, getDependentsErr
+	}
+	if len(dependents) > 0 && !cascade {
+		dependentIDs := []string{}
+		for _, dependent := range dependents {
+			dependentIDs = append(dependentIDs, dependent.ModID)
+		}
+		return nil, fmt.Errorf("%w: %s is required by %s", ErrModRequired, modID, strings.Join(dependentIDs, ", "))
+	}
+	uninstallErr := transaction.Run(nil, smlPath, "uninstall "+modID+"@"+modVersion, func(tx *transaction.Transaction) error {
+		for _, dependent := range dependents {
+			if uninstallErr := Uninstall(tx, dependent.ModID, dependent.Version, smlPath); uninstallErr != nil {
+				return uninstallErr
+			}
+		}
+		if uninstallErr := Uninstall(tx, modID, modVersion, smlPath); uninstallErr != nil {
+			return uninstallErr
+		}
+		return pruneInstallState(tx, smlPath)
+	})
+	if uninstallErr != nil {
+		return nil, uninstallErr
+	}
+	return dependents, nil
+}
+
+// AutoRemove uninstalls the mods that were only installed as dependencies and are not needed by any requested mod any more.
+// Optional dependencies of installed mods are kept. Returns the uninstalled mods
+func AutoRemove(smlPath string) ([]DataJSON, error) {
+	state, readErr := readInstallState(smlPath)
+	if readErr != nil {
+		return nil, readErr
+	}
+	installedMods, getInstalledErr := GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return nil, getInstalledErr
+	}
+	installedByID := map[string]DataJSON{}
+	toVisit := []string{}
+	for _, installedMod := range installedMods {
+		installedByID[installedMod.ModID] = installedMod
+		if !state.isAutoInstalled(installedMod.ModID) {
+			toVisit = append(toVisit, installedMod.ModID)
+		}
+	}
+	needed := map[string]bool{}
+	for len(toVisit) > 0 {
+		modID := toVisit[0]
+		toVisit = toVisit[1:]
+		if needed[modID] {
+			continue
+		}
+		needed[modID] = true
+		for dependencyID := range installedByID[modID].Dependencies {
+			toVisit = append(toVisit, dependencyID)
+		}
+		for dependencyID := range installedByID[modID].OptDependencies {
+			toVisit = append(toVisit, dependencyID)
+		}
+	}
+	orphans := []DataJSON{}
+	for _, installedMod := range installedMods {
+		if !needed[installedMod.ModID] {
+			orphans = append(orphans, installedMod)
+		}
+	}
+	if len(orphans) == 0 {
+		return orphans, nil
+	}
+	removeErr := transaction.Run(nil, smlPath, "autoremove", func(tx *transaction.Transaction) error {
+		for _, orphan := range orphans {
+			if uninstallErr := Uninstall(tx, orphan.ModID, orphan.Version, smlPath); uninstallErr != nil {
+				return uninstallErr
+			}
+		}
+		return pruneInstallState(tx, smlPath)
+	})
+	if removeErr != nil {
+		return nil, removeErr
+	}
+	return orphans, nil
+}
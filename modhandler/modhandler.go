@@ -6,17 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
-	"sort"
 	"strings"
 
-	"github.com/Masterminds/semver"
-
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/transaction"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/version"
 )
 
 // ModFile contains the data.json objects information
@@ -37,24 +35,51 @@ type DataJSON struct {
 	OptDependencies map[string]string `json:"optional_dependencies"`
 }
 
-// GetDataFromZip returns the data.json file in the zip
-func GetDataFromZip(zipFileName string) DataJSON {
+var (
+	// ErrModNotFound is returned when no version of the mod is downloaded
+	ErrModNotFound = errors.New("mod not downloaded")
+	// ErrVersionNotFound is returned when the requested version of the mod is not downloaded
+	ErrVersionNotFound = errors.New("mod version not downloaded")
+	// ErrNoDataJSON is returned when a mod zip does not contain a data.json
+	ErrNoDataJSON = errors.New("zip does not contain a data.json")
+	// ErrModNotInstalled is returned when the mod is not installed in the SML mods dir
+	ErrModNotInstalled = errors.New("mod not installed")
+	// ErrModAlreadyInstalled is returned when trying to install a mod that is already installed
+	ErrModAlreadyInstalled = errors.New("mod already installed")
+)
+
+// GetDataFromZip returns the data.json file in the zip, from the index if the zip did not change since it was read
+func GetDataFromZip(zipFileName string) (DataJSON, error) {
+	entry, entryErr := index.entry(zipFileName)
+	if entryErr != nil {
+		return DataJSON{}, entryErr
+	}
+	return entry.Data, nil
+}
+
+// readDataFromZip reads the data.json file in the zip
+func readDataFromZip(zipFileName string) (DataJSON, error) {
 	zipFile, zipErr := zip.OpenReader(zipFileName)
-	util.Check(zipErr)
+	if zipErr != nil {
+		return DataJSON{}, zipErr
+	}
 	defer zipFile.Close()
 	for _, file := range zipFile.File {
 		if file.Name == "data.json" {
-			fileContent := util.ReadAllFromZip(file)
+			fileContent, readErr := util.ReadAllFromZip(file)
+			if readErr != nil {
+				return DataJSON{}, readErr
+			}
 			var data DataJSON
-			json.Unmarshal(fileContent, &data)
-			if strings.HasPrefix(data.Version, "v") {
-				data.Version = data.Version[1:]
+			jsonErr := json.Unmarshal(fileContent, &data)
+			if jsonErr != nil {
+				return DataJSON{}, fmt.Errorf("invalid data.json in %s: %w", zipFileName, jsonErr)
 			}
-			return data
+			data.Version = version.Normalize(data.Version)
+			return data, nil
 		}
 	}
-	log.Fatalln(zipFileName + " does not contain a data.json. Contact the mod author.")
-	return DataJSON{}
+	return DataJSON{}, fmt.Errorf("%w: %s. Contact the mod author", ErrNoDataJSON, zipFileName)
 }
 
 func getModZips(modID string) []string {
@@ -72,311 +97,531 @@ func getModZips(modID string) []string {
 	return zipFiles
 }
 
-func findModZip(modID string, modVersion string) string {
+// FindModZip returns the path of the downloaded zip of the mod version
+func FindModZip(modID string, modVersion string) (string, error) {
 	modZips := getModZips(modID)
 	for _, file := range modZips {
-		modData := GetDataFromZip(file)
+		modData, dataErr := GetDataFromZip(file)
+		if dataErr != nil {
+			return "", dataErr
+		}
 		if modData.Version == modVersion {
-			return file
+			return file, nil
 		}
 	}
-	log.Fatalln("Mod " + modID + "@" + modVersion + " not found")
-	return ""
+	return "", fmt.Errorf("%w: %s@%s", ErrVersionNotFound, modID, modVersion)
 }
 
-// GetDownloadedModVersions Returns the downloaded versions of the mod
+// GetDownloadedModVersions Returns the downloaded versions of the mod, oldest first
 func GetDownloadedModVersions(modID string) ([]string, error) {
 	versions := []string{}
 	modZips := getModZips(modID)
 	if len(modZips) == 0 {
-		return []string{}, errors.New("Mod " + modID + " not downloaded")
+		return []string{}, fmt.Errorf("%w: %s", ErrModNotFound, modID)
 	}
 	for _, file := range modZips {
-		modData := GetDataFromZip(file)
+		modData, dataErr := GetDataFromZip(file)
+		if dataErr != nil {
+			return []string{}, dataErr
+		}
 		versions = append(versions, modData.Version)
 	}
-	sort.Strings(versions)
-	if len(versions) == 0 {
-		return []string{}, errors.New("No version of " + modID + " is downloaded")
-	}
+	version.Sort(versions)
 	return versions, nil
 }
 
 // GetDownloadedMods returns all mods found in the smlauncher mods dir
-func GetDownloadedMods() []DataJSON {
+func GetDownloadedMods() ([]DataJSON, error) {
 	modPath := paths.ModsDir
 	files, listDirErr := ioutil.ReadDir(modPath)
-	util.Check(listDirErr)
+	if listDirErr != nil {
+		return nil, listDirErr
+	}
 	mods := []DataJSON{}
 	for _, file := range files {
 		if file.IsDir() {
 			modZips := getModZips(file.Name())
 			for _, modZip := range modZips {
-				mods = append(mods, GetDataFromZip(modZip))
+				modData, dataErr := GetDataFromZip(modZip)
+				if dataErr != nil {
+					return nil, dataErr
+				}
+				mods = append(mods, modData)
 			}
 		}
 	}
-	return mods
+	return mods, nil
 }
 
-// GetInstalledMods returns all mods found in the sml mods dir
-func GetInstalledMods(smlPath string) []DataJSON {
+func getInstalledModZips(smlPath string) ([]string, error) {
 	smlModsDir := path.Join(smlPath, "mods")
 	files, listDirErr := ioutil.ReadDir(smlModsDir)
-	util.Check(listDirErr)
+	if listDirErr != nil {
+		if os.IsNotExist(listDirErr) {
+			return []string{}, nil
+		}
+		return nil, listDirErr
+	}
 	zipFiles := []string{}
 	for _, file := range files {
 		if !file.IsDir() && strings.HasSuffix(file.Name(), ".zip") {
 			zipFiles = append(zipFiles, path.Join(smlModsDir, file.Name()))
 		}
 	}
+	return zipFiles, nil
+}
+
+// GetInstalledMods returns all mods found in the sml mods dir
+func GetInstalledMods(smlPath string) ([]DataJSON, error) {
+	zipFiles, listErr := getInstalledModZips(smlPath)
+	if listErr != nil {
+		return nil, listErr
+	}
 	mods := []DataJSON{}
 	for _, zipFile := range zipFiles {
-		mods = append(mods, GetDataFromZip(zipFile))
+		modData, dataErr := GetDataFromZip(zipFile)
+		if dataErr != nil {
+			return nil, dataErr
+		}
+		mods = append(mods, modData)
 	}
-	return mods
+	return mods, nil
 }
 
 // GetLatestDownloadedVersion Returns the latest downloaded version of the mod
 func GetLatestDownloadedVersion(modID string) (string, error) {
 	versions, getDownloadedErr := GetDownloadedModVersions(modID)
-	if len(versions) == 0 {
+	if getDownloadedErr != nil {
 		return "", getDownloadedErr
 	}
-	return versions[len(versions)-1], getDownloadedErr
+	return versions[len(versions)-1], nil
 }
 
 // GetDependencies returns the non optional dependencies of a mod
-func GetDependencies(modID string, modVersion string) map[string]string {
-	data := GetDataFromZip(findModZip(modID, modVersion))
-	return data.Dependencies
+func GetDependencies(modID string, modVersion string) (map[string]string, error) {
+	modZip, findErr := FindModZip(modID, modVersion)
+	if findErr != nil {
+		return nil, findErr
+	}
+	data, dataErr := GetDataFromZip(modZip)
+	if dataErr != nil {
+		return nil, dataErr
+	}
+	return data.Dependencies, nil
 }
 
 // Remove Removes the mod file from the downloaded mods
-func Remove(modID string, modVersion string) bool {
-	modZip := findModZip(modID, modVersion)
-	if modZip == "" {
-		return false
+func Remove(modID string, modVersion string) error {
+	modZip, findErr := FindModZip(modID, modVersion)
+	if findErr != nil {
+		return findErr
 	}
 	removeErr := os.Remove(modZip)
-	util.Check(removeErr)
+	if removeErr != nil {
+		return removeErr
+	}
 	dirEmpty, _ := paths.IsEmpty(paths.ModDir(modID))
 	if dirEmpty {
-		removeErr := os.Remove(paths.ModDir(modID))
-		util.Check(removeErr)
+		return os.Remove(paths.ModDir(modID))
 	}
-	return true
+	return nil
 }
 
-func shouldDownloadUpdate(oldVersion string, updateVersion string) bool {
-	old, oldErr := semver.NewVersion(oldVersion)
-	util.Check(oldErr)
-	new, newErr := semver.NewVersion(updateVersion)
-	util.Check(newErr)
-	return old.Compare(new) == -1
+func shouldDownloadUpdate(oldVersion string, updateVersion string) (bool, error) {
+	old, oldErr := version.Parse(oldVersion)
+	if oldErr != nil {
+		return false, oldErr
+	}
+	new, newErr := version.Parse(updateVersion)
+	if newErr != nil {
+		return false, newErr
+	}
+	return old.Compare(new) == -1, nil
 }
 
-// Update Tries to update the mod. Returns true if the mod was updated, false if the local file is already up to date
-func Update(modID string) (bool, int) {
-	ficsitAppModVersion := ficsitapp.GetLatestModVersion(modID)
+// Update Tries to update the mod to the latest version that meets its stability policy.
+// Returns true if the mod was updated, false if the local file is already up to date
+func Update(reporter *util.Reporter, modID string) (bool, int, error) {
+	ficsitAppModVersion, getLatestErr := ficsitapp.GetLatestModVersion(reporter, modID)
+	if getLatestErr != nil {
+		return false, 0, getLatestErr
+	}
 	localModVersion, getLatestDownloadedErr := GetLatestDownloadedVersion(modID)
-	util.Check(getLatestDownloadedErr)
-	if ficsitAppModVersion != localModVersion {
-		modVersions, getDownloadedErr := GetDownloadedModVersions(modID)
-		util.Check(getDownloadedErr)
-		for _, modVersion := range modVersions {
-			modFile := findModZip(modID, modVersion)
-			removeErr := os.Remove(modFile)
-			util.Check(removeErr)
-		}
-		success, dependencyCnt := DownloadModWithDependencies(modID, ficsitapp.GetLatestModVersion(modID))
-		return success, dependencyCnt
-	}
-	return false, 0
+	if getLatestDownloadedErr != nil {
+		return false, 0, getLatestDownloadedErr
+	}
+	// a newer local version is kept, like a beta downloaded before the policy was changed to release
+	hasUpdate, compareErr := shouldDownloadUpdate(localModVersion, ficsitAppModVersion)
+	if compareErr != nil {
+		return false, 0, compareErr
+	}
+	if hasUpdate {
+		oldVersions, getDownloadedErr := GetDownloadedModVersions(modID)
+		if getDownloadedErr != nil {
+			return false, 0, getDownloadedErr
+		}
+		// download the new version first, so the old ones are kept if it fails
+		_, dependencyCnt, downloadErr := DownloadModWithDependencies(reporter, modID, ficsitAppModVersion, false)
+		if downloadErr != nil {
+			return false, 0, downloadErr
+		}
+		// the old versions are removed together, or not at all
+		removeErr := transaction.Run(nil, paths.ModsDir, "remove the old versions of "+modID, func(tx *transaction.Transaction) error {
+			for _, modVersion := range oldVersions {
+				if modVersion == version.Normalize(ficsitAppModVersion) {
+					continue
+				}
+				modFile, findErr := FindModZip(modID, modVersion)
+				if findErr != nil {
+					return findErr
+				}
+				if trackErr := tx.Track(modFile); trackErr != nil {
+					return trackErr
+				}
+				if removeErr := os.Remove(modFile); removeErr != nil {
+					return removeErr
+				}
+			}
+			return nil
+		})
+		if removeErr != nil {
+			return false, 0, removeErr
+		}
+		return true, dependencyCnt, nil
+	}
+	return false, 0, nil
 }
 
-// Install the mod to the SML path
-func Install(modID string, modVersion string, smlPath string) bool {
-	if IsModInstalled(modID, smlPath) {
-		return false
+// Install the mod to the SML path, in the transaction tx if it is not nil
+func Install(tx *transaction.Transaction, modID string, modVersion string, smlPath string) error {
+	installed, installedErr := IsModInstalled(modID, smlPath)
+	if installedErr != nil {
+		return installedErr
 	}
-	smlModsDir := path.Join(smlPath, "mods")
-	os.MkdirAll(smlModsDir, os.ModePerm)
-	modZipPath := findModZip(modID, modVersion)
-	copyErr := paths.CopyFile(modZipPath, path.Join(smlModsDir, path.Base(modZipPath)))
-	util.Check(copyErr)
-	return true
+	if installed {
+		return fmt.Errorf("%w: %s", ErrModAlreadyInstalled, modID)
+	}
+	modZipPath, findErr := FindModZip(modID, modVersion)
+	if findErr != nil {
+		return findErr
+	}
+	return transaction.Run(tx, smlPath, "install "+modID+"@"+modVersion, func(tx *transaction.Transaction) error {
+		smlModsDir := path.Join(smlPath, "mods")
+		// copy next to the destination and rename, so files in the mods dir are never written in place
+		destination := path.Join(smlModsDir, path.Base(modZipPath))
+		if trackErr := tx.Track(destination + ".tmp"); trackErr != nil {
+			return trackErr
+		}
+		if trackErr := tx.Track(destination); trackErr != nil {
+			return trackErr
+		}
+		mkdirErr := os.MkdirAll(smlModsDir, os.ModePerm)
+		if mkdirErr != nil {
+			return mkdirErr
+		}
+		copyErr := paths.CopyFile(modZipPath, destination+".tmp")
+		if copyErr != nil {
+			os.Remove(destination + ".tmp")
+			return copyErr
+		}
+		return os.Rename(destination+".tmp", destination)
+	})
 }
 
-// Uninstall the mod from the SML path
-func Uninstall(modID string, modVersion string, smlPath string) bool {
-	smlModsDir := path.Join(smlPath, "mods")
-	files, listDirErr := ioutil.ReadDir(smlModsDir)
-	util.Check(listDirErr)
-	zipFiles := []string{}
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".zip") {
-			zipFiles = append(zipFiles, path.Join(smlModsDir, file.Name()))
-		}
+// GetInstalledModZip returns the path of the installed zip of the mod version
+func GetInstalledModZip(modID string, modVersion string, smlPath string) (string, error) {
+	zipFiles, listErr := getInstalledModZips(smlPath)
+	if listErr != nil {
+		return "", listErr
 	}
 	for _, zipFile := range zipFiles {
-		modData := GetDataFromZip(zipFile)
+		modData, dataErr := GetDataFromZip(zipFile)
+		if dataErr != nil {
+			return "", dataErr
+		}
 		if modData.ModID == modID && modData.Version == modVersion {
-			err := os.Remove(zipFile)
-			util.Check(err)
-			return true
+			return zipFile, nil
 		}
 	}
-	log.Fatalln("Mod " + modID + "@" + modVersion + " is not installed")
-	return false
+	return "", fmt.Errorf("%w: %s@%s", ErrModNotInstalled, modID, modVersion)
+}
+
+// Uninstall the mod from the SML path, in the transaction tx if it is not nil
+func Uninstall(tx *transaction.Transaction, modID string, modVersion string, smlPath string) error {
+	zipFile, findErr := GetInstalledModZip(modID, modVersion, smlPath)
+	if findErr != nil {
+		return findErr
+	}
+	return transaction.Run(tx, smlPath, "uninstall "+modID+"@"+modVersion, func(tx *transaction.Transaction) error {
+		if trackErr := tx.Track(zipFile); trackErr != nil {
+			return trackErr
+		}
+		return os.Remove(zipFile)
+	})
 }
 
-// CheckForUpdates compares the installed version with the newest available and optionally downloads it
-func CheckForUpdates(install bool) bool {
-	downloadedMods := GetDownloadedMods()
+// ModUpdate is a newer version on ficsit.app of a downloaded mod
+type ModUpdate struct {
+	ModID           string `json:"mod_id"`
+	CurrentVersion  string `json:"current_version"`
+	LatestVersion   string `json:"latest_version"`
+	Stability       string `json:"stability"`
+	Updated         bool   `json:"updated"`
+	DependencyCount int    `json:"dependency_count"`
+}
+
+// CheckForUpdates returns the downloaded mods that have a newer version on ficsit.app meeting their stability policy, downloading the updates if install is set
+func CheckForUpdates(reporter *util.Reporter, install bool) ([]ModUpdate, error) {
+	downloadedMods, getDownloadedErr := GetDownloadedMods()
+	if getDownloadedErr != nil {
+		return nil, getDownloadedErr
+	}
 	uniqueMods := []string{}
 	for _, downloadedMod := range downloadedMods {
 		if !util.Contains(uniqueMods, downloadedMod.ModID) {
 			uniqueMods = append(uniqueMods, downloadedMod.ModID)
 		}
 	}
-	hasUpdates := false
+	updates := []ModUpdate{}
+	latestVersions, getLatestErr := ficsitapp.GetLatestVersions(reporter, uniqueMods)
+	if getLatestErr != nil {
+		return updates, getLatestErr
+	}
 	for _, mod := range uniqueMods {
-		latestVersion := ficsitapp.GetLatestModVersion(mod)
+		latestVersion, ok := latestVersions[mod]
+		if !ok {
+			// no version meets the stability policy
+			continue
+		}
 		downloadedVersion, _ := GetLatestDownloadedVersion(mod)
-		hasUpdate := shouldDownloadUpdate(downloadedVersion, latestVersion)
-		if hasUpdate {
-			if install {
-				Update(mod)
-				fmt.Println("Updated " + mod + " to " + latestVersion)
-			} else {
-				fmt.Println(mod + "@" + latestVersion + " available")
+		hasUpdate, compareErr := shouldDownloadUpdate(downloadedVersion, latestVersion.Version)
+		if compareErr != nil {
+			return updates, compareErr
+		}
+		if !hasUpdate {
+			continue
+		}
+		update := ModUpdate{ModID: mod, CurrentVersion: downloadedVersion, LatestVersion: latestVersion.Version, Stability: latestVersion.Stability}
+		if install {
+			_, dependencyCnt, updateErr := Update(reporter, mod)
+			if updateErr != nil {
+				return updates, updateErr
 			}
-			hasUpdates = true
+			update.Updated = true
+			update.DependencyCount = dependencyCnt - 1
 		}
+		updates = append(updates, update)
 	}
-	return hasUpdates
+	return updates, nil
 }
 
 // GetDownloadedModVersionWithConstraint returns the latest downloaded version that meets the constraint
-func GetDownloadedModVersionWithConstraint(modID string, versionConstraint string) string {
+func GetDownloadedModVersionWithConstraint(modID string, versionConstraint string) (string, error) {
 	versions, _ := GetDownloadedModVersions(modID)
-	constraint, constraintErr := semver.NewConstraint(versionConstraint)
-	util.Check(constraintErr)
-	for _, version := range versions {
-		ver, err := semver.NewVersion(version)
-		util.Check(err)
-		if constraint.Check(ver) {
-			return version
-		}
-	}
-	return ""
+	return version.LatestSatisfying(versions, versionConstraint)
 }
 
 // GetInstalledModVersions returns the data.jsons of the versions of the mod
-func GetInstalledModVersions(modID string, smlPath string) []DataJSON {
-	mods := GetInstalledMods(smlPath)
+func GetInstalledModVersions(modID string, smlPath string) ([]DataJSON, error) {
+	mods, getInstalledErr := GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return nil, getInstalledErr
+	}
 	modVersions := []DataJSON{}
 	for _, mod := range mods {
 		if mod.ModID == modID {
 			modVersions = append(modVersions, mod)
 		}
 	}
-	return modVersions
+	return modVersions, nil
+}
+
+// GetInstalledModVersion returns the installed version of the mod
+func GetInstalledModVersion(modID string, smlPath string) (string, error) {
+	mods, getInstalledErr := GetInstalledModVersions(modID, smlPath)
+	if getInstalledErr != nil {
+		return "", getInstalledErr
+	}
+	if len(mods) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrModNotInstalled, modID)
+	}
+	return mods[0].Version, nil
 }
 
 // IsModInstalled checks if a mod is installed
-func IsModInstalled(modID string, smlPath string) bool {
-	versions := GetInstalledModVersions(modID, smlPath)
-	return len(versions) > 0
+func IsModInstalled(modID string, smlPath string) (bool, error) {
+	versions, getInstalledErr := GetInstalledModVersions(modID, smlPath)
+	return len(versions) > 0, getInstalledErr
 }
 
 // IsModVersionWithConstraintInstalled checks if a mod is installed
-func IsModVersionWithConstraintInstalled(modID string, versionConstraint string, smlPath string) bool {
-	versions := GetInstalledModVersionWithConstraint(modID, versionConstraint, smlPath)
-	return len(versions) > 0
+func IsModVersionWithConstraintInstalled(modID string, versionConstraint string, smlPath string) (bool, error) {
+	version, getInstalledErr := GetInstalledModVersionWithConstraint(modID, versionConstraint, smlPath)
+	return len(version) > 0, getInstalledErr
 }
 
 // IsModVersionInstalled checks if a mod is installed
-func IsModVersionInstalled(modID string, version string, smlPath string) bool {
-	versions := GetInstalledModVersions(modID, smlPath)
+func IsModVersionInstalled(modID string, version string, smlPath string) (bool, error) {
+	versions, getInstalledErr := GetInstalledModVersions(modID, smlPath)
+	if getInstalledErr != nil {
+		return false, getInstalledErr
+	}
 	versionsString := []string{}
-	fmt.Println(modID, version, versionsString)
 	for _, ver := range versions {
 		versionsString = append(versionsString, ver.Version)
 	}
-	fmt.Println(modID, version, versionsString)
-	return util.Contains(versionsString, version)
+	return util.Contains(versionsString, version), nil
 }
 
 // GetInstalledModVersionWithConstraint returns the latest installed version that meets the constraint
-func GetInstalledModVersionWithConstraint(modID string, versionConstraint string, smlPath string) string {
-	mods := GetInstalledModVersions(modID, smlPath)
-	constraint, constraintErr := semver.NewConstraint(versionConstraint)
-	util.Check(constraintErr)
+func GetInstalledModVersionWithConstraint(modID string, versionConstraint string, smlPath string) (string, error) {
+	mods, getInstalledErr := GetInstalledModVersions(modID, smlPath)
+	if getInstalledErr != nil {
+		return "", getInstalledErr
+	}
+	versions := []string{}
 	for _, modVersion := range mods {
-		ver, err := semver.NewVersion(modVersion.Version)
-		util.Check(err)
-		if constraint.Check(ver) {
-			return modVersion.Version
+		versions = append(versions, modVersion.Version)
+	}
+	return version.LatestSatisfying(versions, versionConstraint)
+}
+
+// DownloadModWithDependencies resolves the dependencies of the mod and downloads the mod and the dependencies that are not downloaded yet.
+// Optional dependencies are downloaded too if withOptional is set. Returns the resolved mods and the number of them
+// that are downloaded, either now or before
+func DownloadModWithDependencies(reporter *util.Reporter, modID string, version string, withOptional bool) ([]ResolvedMod, int, error) {
+	plan, resolveErr := resolvePlan(reporter, []Requirement{{ModID: modID, Constraint: version}}, withOptional)
+	if resolveErr != nil {
+		return nil, 0, resolveErr
+	}
+	downloads, downloadErrs, findErr := downloadMissing(reporter, plan, modID)
+	if findErr != nil {
+		return plan, 0, findErr
+	}
+	downloadedCnt := len(plan)
+	for _, downloadErr := range downloadErrs {
+		if downloadErr != nil {
+			downloadedCnt--
 		}
 	}
-	return ""
+	for i, downloadErr := range downloadErrs {
+		if downloadErr == nil {
+			continue
+		}
+		if downloads[i].ModID != modID {
+			return plan, downloadedCnt, fmt.Errorf("downloading dependency %s@%s for mod %s@%s: %w", downloads[i].ModID, downloads[i].Version, modID, version, downloadErr)
+		}
+		return plan, downloadedCnt, downloadErr
+	}
+	return plan, downloadedCnt, nil
 }
 
-// DownloadModWithDependencies downloads the mod and its dependencies
-func DownloadModWithDependencies(modID string, version string) (bool, int) {
-	success, downloadErr := ficsitapp.DownloadModVersion(modID, version)
-	util.Check(downloadErr)
-	if success {
-		dependencyCnt := 0
-		dependencies := GetDependencies(modID, version)
-		for dependencyID, dependencyVersionConstraint := range dependencies {
-			if GetDownloadedModVersionWithConstraint(dependencyID, dependencyVersionConstraint) == "" {
-				depVersion, depErr := ficsitapp.GetModFromVersionConstraint(dependencyID, dependencyVersionConstraint)
-				util.Check(depErr)
-				dependencySuccess, depDepCnt := DownloadModWithDependencies(dependencyID, depVersion)
-				if !dependencySuccess {
-					success = false
-					log.Println("Error downloading dependency " + dependencyID + "@" + dependencyVersionConstraint + " for mod " + modID + "@" + version)
-				} else {
-					dependencyCnt = dependencyCnt + depDepCnt
-				}
+// downloadMissing downloads the mods of the plan that are not downloaded yet, several at a time.
+// The mod with redownloadID is downloaded even if it already is, unless offline. Returns the mods it tried to download in plan order,
+// with the error of each at the same index, so callers report the same failure whatever order the downloads finish in
+func downloadMissing(reporter *util.Reporter, plan []ResolvedMod, redownloadID string) ([]ResolvedMod, []error, error) {
+	downloads := []ResolvedMod{}
+	refs := []ficsitapp.ModVersionRef{}
+	for _, mod := range plan {
+		if mod.ModID != redownloadID || ficsitapp.Offline() {
+			_, findErr := FindModZip(mod.ModID, mod.Version)
+			if findErr == nil {
+				continue
+			}
+			if !errors.Is(findErr, ErrVersionNotFound) {
+				return nil, nil, findErr
 			}
 		}
-		return success, dependencyCnt + 1
+		downloads = append(downloads, mod)
+		refs = append(refs, ficsitapp.ModVersionRef{ModID: mod.ModID, Version: mod.Version})
+	}
+	if len(refs) == 0 {
+		return downloads, []error{}, nil
 	}
-	return false, 0
+	return downloads, ficsitapp.DownloadModVersions(reporter, refs), nil
 }
 
-// InstallModWithDependencies installs the mod and its dependencies
-func InstallModWithDependencies(modID string, version string, smlPath string) bool {
-	success := Install(modID, version, smlPath)
-	if success {
-		dependencies := GetDependencies(modID, version)
-		for dependencyID, dependencyVersionConstraint := range dependencies {
-			if GetInstalledModVersionWithConstraint(dependencyID, dependencyVersionConstraint, smlPath) == "" {
-				depVersion := GetDownloadedModVersionWithConstraint(dependencyID, dependencyVersionConstraint)
-				if depVersion == "" {
-					var depErr error
-					depVersion, depErr = ficsitapp.GetModFromVersionConstraint(dependencyID, dependencyVersionConstraint)
-					fmt.Println("Dependency " + dependencyID + "@" + dependencyVersionConstraint + " is not downloaded. Downloading " + dependencyID + "@" + depVersion)
-					util.Check(depErr)
-					downloadSuccess, _ := DownloadModWithDependencies(dependencyID, depVersion)
-					if !downloadSuccess {
-						log.Println("Error downloading dependency " + dependencyID + "@" + dependencyVersionConstraint + " for mod " + modID + "@" + version)
-					}
+// InstallModWithDependencies resolves a consistent set of versions for the mod, its dependencies and the already installed mods,
+// then downloads and installs the missing ones. The dependencies are marked as auto installed.
+// Optional dependencies are installed too if withOptional is set. Installed optional dependencies that do not meet
+// the constraints of the mods using them are upgraded if possible, and reported with a warning otherwise.
+// Installing a mod that was auto installed only marks it as requested. The changes are made in the transaction tx if it is not nil
+func InstallModWithDependencies(tx *transaction.Transaction, reporter *util.Reporter, modID string, version string, smlPath string, withOptional bool) error {
+	installed, installedErr := IsModInstalled(modID, smlPath)
+	if installedErr != nil {
+		return installedErr
+	}
+	if installed {
+		autoInstalled, autoInstalledErr := IsAutoInstalled(modID, smlPath)
+		if autoInstalledErr != nil {
+			return autoInstalledErr
+		}
+		if !autoInstalled {
+			return fmt.Errorf("%w: %s", ErrModAlreadyInstalled, modID)
+		}
+		return transaction.Run(tx, smlPath, "mark "+modID+" as requested", func(tx *transaction.Transaction) error {
+			return SetAutoInstalled(tx, []string{modID}, false, smlPath)
+		})
+	}
+	installedMods, getInstalledErr := GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return getInstalledErr
+	}
+	requirements := []Requirement{{ModID: modID, Constraint: version}}
+	installedVersions := map[string]string{}
+	for _, installedMod := range installedMods {
+		requirements = append(requirements, Requirement{installedMod.ModID, installedMod.Version, "installed " + installedMod.ModID})
+		installedVersions[installedMod.ModID] = installedMod.Version
+	}
+	plan, resolveErr := resolvePlan(reporter, requirements, withOptional)
+	if resolveErr != nil {
+		return resolveErr
+	}
+	plan, mismatches := fixOptionalMismatches(reporter, requirements, plan, installedVersions, withOptional)
+	for _, mismatch := range mismatches {
+		reporter.Progress("Warning: " + mismatch.String())
+	}
+	toInstall := []ResolvedMod{}
+	for _, mod := range plan {
+		if installedVersions[mod.ModID] != mod.Version {
+			toInstall = append(toInstall, mod)
+		}
+	}
+	// download everything before touching the install, so a failed download leaves it unchanged
+	downloads, downloadErrs, findErr := downloadMissing(reporter, toInstall, "")
+	if findErr != nil {
+		return findErr
+	}
+	for i, downloadErr := range downloadErrs {
+		if downloadErr != nil {
+			return fmt.Errorf("downloading dependency %s@%s for mod %s@%s: %w", downloads[i].ModID, downloads[i].Version, modID, version, downloadErr)
+		}
+	}
+	return transaction.Run(tx, smlPath, "install "+modID+"@"+version, func(tx *transaction.Transaction) error {
+		dependencyIDs := []string{}
+		for _, mod := range toInstall {
+			if oldVersion, ok := installedVersions[mod.ModID]; ok {
+				if uninstallErr := Uninstall(tx, mod.ModID, oldVersion, smlPath); uninstallErr != nil {
+					return uninstallErr
 				}
-				dependencySuccess := InstallModWithDependencies(dependencyID, depVersion, smlPath)
-				if !dependencySuccess {
-					success = false
-					log.Println("Error installing dependency " + dependencyID + "@" + dependencyVersionConstraint + " for mod " + modID + "@" + version)
-				} else {
-					fmt.Println("Installed dependency " + dependencyID + "@" + depVersion + " for mod " + modID + "@" + version)
+				if installErr := Install(tx, mod.ModID, mod.Version, smlPath); installErr != nil {
+					return installErr
 				}
+				reporter.Progress("Upgraded installed mod " + mod.ModID + " from " + oldVersion + " to " + mod.Version + " to meet an optional dependency")
+				continue
+			}
+			installErr := Install(tx, mod.ModID, mod.Version, smlPath)
+			if installErr != nil {
+				return installErr
+			}
+			if mod.ModID != modID {
+				dependencyIDs = append(dependencyIDs, mod.ModID)
+				reporter.Progress("Installed dependency " + mod.ModID + "@" + mod.Version + " for mod " + modID + "@" + version)
 			}
 		}
-		return success
-	}
-	return false
+		if markErr := SetAutoInstalled(tx, []string{modID}, false, smlPath); markErr != nil {
+			return markErr
+		}
+		return SetAutoInstalled(tx, dependencyIDs, true, smlPath)
+	})
 }
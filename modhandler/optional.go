@@ -0,0 +1,76 @@
+package modhandler
+
+import (
+	"sort"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/version"
+)
+
+// OptionalMismatch is an optional dependency that is part of a mod set, with a version that does not meet the constraint of the mod using it
+type OptionalMismatch struct {
+	ModID             string `json:"mod_id"`
+	Version           string `json:"version"`
+	DependencyID      string `json:"dependency_id"`
+	DependencyVersion string `json:"dependency_version"`
+	Constraint        string `json:"constraint"`
+}
+
+func (mismatch OptionalMismatch) String() string {
+	return mismatch.ModID + "@" + mismatch.Version + " optionally depends on " + mismatch.DependencyID + "@" + mismatch.Constraint +
+		", but " + mismatch.DependencyID + "@" + mismatch.DependencyVersion + " is used"
+}
+
+// FindOptionalMismatches returns the optional dependencies in the mod set that do not meet the constraints of the mods using them.
+// Optional dependencies that are not in the set are fine
+func FindOptionalMismatches(mods []ResolvedMod) []OptionalMismatch {
+	versions := map[string]string{}
+	for _, mod := range mods {
+		versions[mod.ModID] = mod.Version
+	}
+	mismatches := []OptionalMismatch{}
+	for _, mod := range mods {
+		for dependencyID, constraint := range mod.OptionalDependencies {
+			dependencyVersion, ok := versions[dependencyID]
+			if ok && !version.Satisfies(dependencyVersion, constraint) {
+				mismatches = append(mismatches, OptionalMismatch{mod.ModID, mod.Version, dependencyID, dependencyVersion, constraint})
+			}
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		if mismatches[i].ModID != mismatches[j].ModID {
+			return mismatches[i].ModID < mismatches[j].ModID
+		}
+		return mismatches[i].DependencyID < mismatches[j].DependencyID
+	})
+	return mismatches
+}
+
+// fixOptionalMismatches resolves the requirements again with the optional constraints that are not met made required,
+// letting the installed versions of the optional dependencies be upgraded but not downgraded.
+// Returns the new plan and the mismatches left, or the old plan and its mismatches if there is no such set of versions
+func fixOptionalMismatches(reporter *util.Reporter, requirements []Requirement, plan []ResolvedMod, installedVersions map[string]string, withOptional bool) ([]ResolvedMod, []OptionalMismatch) {
+	mismatches := FindOptionalMismatches(plan)
+	if len(mismatches) == 0 {
+		return plan, mismatches
+	}
+	mismatched := map[string]bool{}
+	for _, mismatch := range mismatches {
+		mismatched[mismatch.DependencyID] = true
+	}
+	fixedRequirements := []Requirement{}
+	for _, requirement := range requirements {
+		if mismatched[requirement.ModID] && installedVersions[requirement.ModID] == requirement.Constraint {
+			requirement.Constraint = ">=" + requirement.Constraint
+		}
+		fixedRequirements = append(fixedRequirements, requirement)
+	}
+	for _, mismatch := range mismatches {
+		fixedRequirements = append(fixedRequirements, Requirement{mismatch.DependencyID, mismatch.Constraint, mismatch.ModID + "@" + mismatch.Version + " (optional)"})
+	}
+	fixedPlan, resolveErr := resolvePlan(reporter, fixedRequirements, withOptional)
+	if resolveErr != nil {
+		return plan, mismatches
+	}
+	return fixedPlan, FindOptionalMismatches(fixedPlan)
+}
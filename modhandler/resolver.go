@@ -0,0 +1,348 @@
+package modhandler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/version"
+)
+
+// Requirement is a mod that must be part of the resolved set, and the constraint its version must meet
+type Requirement struct {
+	ModID      string
+	Constraint string
+	RequiredBy string
+}
+
+// ResolvedMod is the version of a mod picked by the resolver
+type ResolvedMod struct {
+	ModID        string            `json:"mod_id"`
+	Version      string            `json:"version"`
+	Stability    string            `json:"stability,omitempty"`
+	Link         string            `json:"link"`
+	Dependencies map[string]string `json:"dependencies"`
+	// OptionalDependencies are only required by the resolver when resolving with optional dependencies
+	OptionalDependencies map[string]string `json:"optional_dependencies,omitempty"`
+}
+
+// VersionSource returns the versions of a mod the resolver can pick from, in order of preference
+type VersionSource func(modID string) ([]ficsitapp.ModVersion, error)
+
+// ConstraintSource is a constraint on a mod version and what it was required by
+type ConstraintSource struct {
+	RequiredBy string
+	Constraint string
+}
+
+// ConflictError is returned when no version of a mod meets all the constraints on it
+type ConflictError struct {
+	ModID       string
+	Constraints []ConstraintSource
+}
+
+func (e *ConflictError) Error() string {
+	requirements := []string{}
+	for _, constraint := range e.Constraints {
+		requirements = append(requirements, constraint.RequiredBy+" requires "+e.ModID+"@"+constraint.Constraint)
+	}
+	return "no version of " + e.ModID + " meets all constraints: " + strings.Join(requirements, ", ")
+}
+
+type resolverConstraint struct {
+	ConstraintSource
+	constraint *semver.Constraints
+}
+
+type resolver struct {
+	source       VersionSource
+	withOptional bool
+	versions     map[string][]ficsitapp.ModVersion
+	assigned     map[string]ResolvedMod
+	constraints  map[string][]resolverConstraint
+}
+
+// ResolveDependencies picks a version for each required mod and all their dependencies, such that every constraint is met.
+// Optional dependencies are treated like required ones if withOptional is set, and ignored otherwise.
+// Returns a *ConflictError explaining which mods require which versions if there is no such set of versions
+func ResolveDependencies(requirements []Requirement, source VersionSource, withOptional bool) ([]ResolvedMod, error) {
+	r := resolver{
+		source:       source,
+		withOptional: withOptional,
+		versions:     map[string][]ficsitapp.ModVersion{},
+		assigned:     map[string]ResolvedMod{},
+		constraints:  map[string][]resolverConstraint{},
+	}
+	for _, requirement := range requirements {
+		requiredBy := requirement.RequiredBy
+		if requiredBy == "" {
+			requiredBy = "request"
+		}
+		addErr := r.addConstraint(requirement.ModID, requirement.Constraint, requiredBy)
+		if addErr != nil {
+			return nil, addErr
+		}
+	}
+	resolveErr := r.resolve()
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	resolved := []ResolvedMod{}
+	for _, mod := range r.assigned {
+		resolved = append(resolved, mod)
+	}
+	sort.Slice(resolved, func(i, j int) bool {
+		return resolved[i].ModID < resolved[j].ModID
+	})
+	return resolved, nil
+}
+
+func (r *resolver) addConstraint(modID string, versionConstraint string, requiredBy string) error {
+	constraint, constraintErr := semver.NewConstraint(versionConstraint)
+	if constraintErr != nil {
+		return constraintErr
+	}
+	r.constraints[modID] = append(r.constraints[modID], resolverConstraint{ConstraintSource{requiredBy, versionConstraint}, constraint})
+	return nil
+}
+
+func (r *resolver) removeConstraint(modID string) {
+	r.constraints[modID] = r.constraints[modID][:len(r.constraints[modID])-1]
+	if len(r.constraints[modID]) == 0 {
+		delete(r.constraints, modID)
+	}
+}
+
+func (r *resolver) conflict(modID string) *ConflictError {
+	sources := []ConstraintSource{}
+	for _, constraint := range r.constraints[modID] {
+		sources = append(sources, constraint.ConstraintSource)
+	}
+	return &ConflictError{modID, sources}
+}
+
+func (r *resolver) satisfies(modID string, modVersion string) bool {
+	ver, verErr := version.Parse(modVersion)
+	if verErr != nil {
+		return false
+	}
+	for _, constraint := range r.constraints[modID] {
+		if !constraint.constraint.Check(ver) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *resolver) getVersions(modID string) ([]ficsitapp.ModVersion, error) {
+	if versions, ok := r.versions[modID]; ok {
+		return versions, nil
+	}
+	versions, sourceErr := r.source(modID)
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+	r.versions[modID] = versions
+	return versions, nil
+}
+
+func (r *resolver) nextUnassigned() string {
+	unassigned := []string{}
+	for modID := range r.constraints {
+		if _, ok := r.assigned[modID]; !ok {
+			unassigned = append(unassigned, modID)
+		}
+	}
+	if len(unassigned) == 0 {
+		return ""
+	}
+	sort.Strings(unassigned)
+	return unassigned[0]
+}
+
+// assign picks the version for the mod and adds the constraints of its dependencies.
+// Returns the dependencies that received a constraint, so they can be removed when backtracking
+func (r *resolver) assign(modID string, modVersion ficsitapp.ModVersion) ([]string, error) {
+	resolvedVersion := version.Normalize(modVersion.Version)
+	r.assigned[modID] = ResolvedMod{modID, resolvedVersion, modVersion.Stability, modVersion.Link, modVersion.Dependencies, modVersion.OptionalDependencies}
+	dependencies := map[string]string{}
+	requiredBy := map[string]string{}
+	if r.withOptional {
+		for dependencyID, constraint := range modVersion.OptionalDependencies {
+			dependencies[dependencyID] = constraint
+			requiredBy[dependencyID] = modID + "@" + resolvedVersion + " (optional)"
+		}
+	}
+	for dependencyID, constraint := range modVersion.Dependencies {
+		dependencies[dependencyID] = constraint
+		requiredBy[dependencyID] = modID + "@" + resolvedVersion
+	}
+	dependencyIDs := []string{}
+	for dependencyID := range dependencies {
+		dependencyIDs = append(dependencyIDs, dependencyID)
+	}
+	sort.Strings(dependencyIDs)
+	added := []string{}
+	for _, dependencyID := range dependencyIDs {
+		addErr := r.addConstraint(dependencyID, dependencies[dependencyID], requiredBy[dependencyID])
+		if addErr != nil {
+			return added, addErr
+		}
+		added = append(added, dependencyID)
+		if dependency, ok := r.assigned[dependencyID]; ok && !r.satisfies(dependencyID, dependency.Version) {
+			return added, r.conflict(dependencyID)
+		}
+	}
+	return added, nil
+}
+
+func (r *resolver) unassign(modID string, added []string) {
+	for _, dependencyID := range added {
+		r.removeConstraint(dependencyID)
+	}
+	delete(r.assigned, modID)
+}
+
+func (r *resolver) resolve() error {
+	modID := r.nextUnassigned()
+	if modID == "" {
+		return nil
+	}
+	versions, getVersionsErr := r.getVersions(modID)
+	if getVersionsErr != nil {
+		return getVersionsErr
+	}
+	var lastErr error = r.conflict(modID)
+	for _, version := range versions {
+		if !r.satisfies(modID, version.Version) {
+			continue
+		}
+		added, assignErr := r.assign(modID, version)
+		if assignErr == nil {
+			assignErr = r.resolve()
+			if assignErr == nil {
+				return nil
+			}
+		}
+		r.unassign(modID, added)
+		var conflictErr *ConflictError
+		if !errors.As(assignErr, &conflictErr) {
+			return assignErr
+		}
+		lastErr = assignErr
+	}
+	return lastErr
+}
+
+func sortVersionsNewestFirst(versions []ficsitapp.ModVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return version.Less(versions[j].Version, versions[i].Version)
+	})
+}
+
+// DownloadedVersions is a VersionSource of the downloaded versions of the mod, newest first
+func DownloadedVersions(modID string) ([]ficsitapp.ModVersion, error) {
+	modZips := getModZips(modID)
+	if len(modZips) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrModNotFound, modID)
+	}
+	versions := []ficsitapp.ModVersion{}
+	for _, modZip := range modZips {
+		modData, dataErr := GetDataFromZip(modZip)
+		if dataErr != nil {
+			return nil, dataErr
+		}
+		versions = append(versions, ficsitapp.ModVersion{Version: modData.Version, Dependencies: modData.Dependencies, OptionalDependencies: modData.OptDependencies})
+	}
+	sortVersionsNewestFirst(versions)
+	return versions, nil
+}
+
+// AvailableVersions returns a VersionSource of the downloaded versions of the mod, followed by the ones on ficsit.app that are not downloaded.
+// The downloaded versions get their stability from ficsit.app. Warnings about the ficsit.app requests go to the reporter
+func AvailableVersions(reporter *util.Reporter) VersionSource {
+	return func(modID string) ([]ficsitapp.ModVersion, error) {
+		versions, downloadedErr := DownloadedVersions(modID)
+		if downloadedErr != nil && !errors.Is(downloadedErr, ErrModNotFound) {
+			return nil, downloadedErr
+		}
+		downloaded := []string{}
+		for _, downloadedVersion := range versions {
+			downloaded = append(downloaded, downloadedVersion.Version)
+		}
+		remoteVersions, remoteErr := ficsitapp.GetModVersions(reporter, modID)
+		if remoteErr != nil {
+			// offline, the downloaded versions are enough if ficsit.app was never asked about the mod
+			if (errors.Is(remoteErr, ficsitapp.ErrModNotFound) || errors.Is(remoteErr, ficsitapp.ErrNotCached)) && len(versions) > 0 {
+				return versions, nil
+			}
+			return nil, remoteErr
+		}
+		stabilities := map[string]string{}
+		notDownloaded := []ficsitapp.ModVersion{}
+		for _, remoteVersion := range remoteVersions {
+			stabilities[version.Normalize(remoteVersion.Version)] = remoteVersion.Stability
+			if !util.Contains(downloaded, version.Normalize(remoteVersion.Version)) {
+				notDownloaded = append(notDownloaded, remoteVersion)
+			}
+		}
+		for i := range versions {
+			versions[i].Stability = stabilities[version.Normalize(versions[i].Version)]
+		}
+		sortVersionsNewestFirst(notDownloaded)
+		return append(versions, notDownloaded...), nil
+	}
+}
+
+// withStability leaves out the versions less stable than the stability policy of their mod.
+// Versions required exactly are kept, as asking for a version is a choice of its stability
+func withStability(source VersionSource, requirements []Requirement) VersionSource {
+	exact := map[string][]*semver.Version{}
+	for _, requirement := range requirements {
+		if ver, verErr := version.Parse(requirement.Constraint); verErr == nil {
+			exact[requirement.ModID] = append(exact[requirement.ModID], ver)
+		}
+	}
+	return func(modID string) ([]ficsitapp.ModVersion, error) {
+		versions, sourceErr := source(modID)
+		if sourceErr != nil {
+			return nil, sourceErr
+		}
+		stability := ficsitapp.ModStability(modID)
+		stable := []ficsitapp.ModVersion{}
+		for _, version := range versions {
+			if ficsitapp.MeetsStability(version.Stability, stability) || isExactVersion(exact[modID], version.Version) {
+				stable = append(stable, version)
+			}
+		}
+		return stable, nil
+	}
+}
+
+func isExactVersion(exact []*semver.Version, modVersion string) bool {
+	ver, verErr := version.Parse(modVersion)
+	if verErr != nil {
+		return false
+	}
+	for _, exactVersion := range exact {
+		if exactVersion.Equal(ver) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePlan resolves the requirements from the downloaded and the ficsit.app versions, following the stability policy of each mod.
+// The downloaded versions are preferred. Only when ficsit.app can not be asked are the downloaded mods used on their own
+func resolvePlan(reporter *util.Reporter, requirements []Requirement, withOptional bool) ([]ResolvedMod, error) {
+	plan, resolveErr := ResolveDependencies(requirements, withStability(AvailableVersions(reporter), requirements), withOptional)
+	if resolveErr != nil && (ficsitapp.Offline() || errors.Is(resolveErr, ficsitapp.ErrNotCached)) {
+		return ResolveDependencies(requirements, DownloadedVersions, withOptional)
+	}
+	return plan, resolveErr
+}
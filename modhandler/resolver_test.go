@@ -0,0 +1,194 @@
+package modhandler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+)
+
+var errTestSource = errors.New("source failed")
+
+// testSource is a VersionSource of fixed versions, listed in order of preference
+func testSource(versions map[string][]ficsitapp.ModVersion) VersionSource {
+	return func(modID string) ([]ficsitapp.ModVersion, error) {
+		modVersions, ok := versions[modID]
+		if !ok {
+			return nil, errTestSource
+		}
+		return modVersions, nil
+	}
+}
+
+func testModVersion(modVersion string, dependencies map[string]string, optionalDependencies map[string]string) ficsitapp.ModVersion {
+	return ficsitapp.ModVersion{Version: modVersion, Dependencies: dependencies, OptionalDependencies: optionalDependencies}
+}
+
+// resolvedVersions returns the resolved version of each mod
+func resolvedVersions(plan []ResolvedMod) map[string]string {
+	versions := map[string]string{}
+	for _, mod := range plan {
+		versions[mod.ModID] = mod.Version
+	}
+	return versions
+}
+
+func TestResolveDependencies(t *testing.T) {
+	diamond := map[string][]ficsitapp.ModVersion{
+		"A": {testModVersion("1.0.0", map[string]string{"B": "^1.0.0", "C": "^1.0.0"}, nil)},
+		"B": {testModVersion("1.0.0", map[string]string{"D": "^1.0.0"}, nil)},
+		"C": {testModVersion("1.0.0", map[string]string{"D": "^2.0.0"}, nil)},
+		"D": {testModVersion("2.0.0", nil, nil), testModVersion("1.0.0", nil, nil)},
+	}
+	// the newest B needs a C that A does not allow, so B goes back to 1.0.0
+	backtrack := map[string][]ficsitapp.ModVersion{
+		"A": {testModVersion("1.0.0", map[string]string{"B": ">=1.0.0", "C": "^1.0.0"}, nil)},
+		"B": {
+			testModVersion("2.0.0", map[string]string{"C": "^2.0.0"}, nil),
+			testModVersion("1.0.0", map[string]string{"C": "^1.0.0"}, nil),
+		},
+		"C": {testModVersion("2.0.0", nil, nil), testModVersion("1.1.0", nil, nil), testModVersion("1.0.0", nil, nil)},
+	}
+	optional := map[string][]ficsitapp.ModVersion{
+		"A": {testModVersion("1.0.0", map[string]string{"B": "^1.0.0"}, map[string]string{"C": "^2.0.0"})},
+		"B": {testModVersion("1.0.0", nil, nil)},
+		"C": {testModVersion("2.0.0", nil, nil), testModVersion("1.0.0", nil, nil)},
+	}
+	tests := []struct {
+		name         string
+		versions     map[string][]ficsitapp.ModVersion
+		requirements []Requirement
+		withOptional bool
+		want         map[string]string
+		wantConflict string
+		wantErr      error
+	}{
+		{
+			name:         "newest versions",
+			versions:     diamond,
+			requirements: []Requirement{{ModID: "D", Constraint: "*"}},
+			want:         map[string]string{"D": "2.0.0"},
+		},
+		{
+			name:         "diamond conflict",
+			versions:     diamond,
+			requirements: []Requirement{{ModID: "A", Constraint: "*"}},
+			wantConflict: "no version of D meets all constraints: B@1.0.0 requires D@^1.0.0, C@1.0.0 requires D@^2.0.0",
+		},
+		{
+			name:         "backtrack onto an older version",
+			versions:     backtrack,
+			requirements: []Requirement{{ModID: "A", Constraint: "1.0.0"}},
+			want:         map[string]string{"A": "1.0.0", "B": "1.0.0", "C": "1.1.0"},
+		},
+		{
+			name:         "backtracking removes the constraints of the discarded version",
+			versions:     backtrack,
+			requirements: []Requirement{{ModID: "A", Constraint: "1.0.0"}, {ModID: "C", Constraint: "<2.0.0", RequiredBy: "profile"}},
+			want:         map[string]string{"A": "1.0.0", "B": "1.0.0", "C": "1.1.0"},
+		},
+		{
+			name:         "conflict with the request",
+			versions:     backtrack,
+			requirements: []Requirement{{ModID: "A", Constraint: "1.0.0"}, {ModID: "C", Constraint: "2.0.0"}},
+			wantConflict: "no version of C meets all constraints: request requires C@2.0.0, A@1.0.0 requires C@^1.0.0, B@1.0.0 requires C@^1.0.0",
+		},
+		{
+			name:         "optional dependencies left out",
+			versions:     optional,
+			requirements: []Requirement{{ModID: "A", Constraint: "*"}},
+			want:         map[string]string{"A": "1.0.0", "B": "1.0.0"},
+		},
+		{
+			name:         "optional dependencies included",
+			versions:     optional,
+			requirements: []Requirement{{ModID: "A", Constraint: "*"}},
+			withOptional: true,
+			want:         map[string]string{"A": "1.0.0", "B": "1.0.0", "C": "2.0.0"},
+		},
+		{
+			name:         "optional dependency constraint ignored without optional dependencies",
+			versions:     optional,
+			requirements: []Requirement{{ModID: "A", Constraint: "*"}, {ModID: "C", Constraint: "1.0.0"}},
+			want:         map[string]string{"A": "1.0.0", "B": "1.0.0", "C": "1.0.0"},
+		},
+		{
+			name:         "optional dependency conflict",
+			versions:     optional,
+			requirements: []Requirement{{ModID: "A", Constraint: "*"}, {ModID: "C", Constraint: "1.0.0"}},
+			withOptional: true,
+			wantConflict: "no version of C meets all constraints: request requires C@1.0.0, A@1.0.0 (optional) requires C@^2.0.0",
+		},
+		{
+			name:         "source error",
+			versions:     map[string][]ficsitapp.ModVersion{"A": {testModVersion("1.0.0", map[string]string{"Missing": "*"}, nil)}},
+			requirements: []Requirement{{ModID: "A", Constraint: "*"}},
+			wantErr:      errTestSource,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, resolveErr := ResolveDependencies(test.requirements, testSource(test.versions), test.withOptional)
+			if test.wantConflict != "" {
+				var conflictErr *ConflictError
+				if !errors.As(resolveErr, &conflictErr) {
+					t.Fatalf("ResolveDependencies error = %v, want a conflict", resolveErr)
+				}
+				if conflictErr.Error() != test.wantConflict {
+					t.Errorf("conflict = %q, want %q", conflictErr.Error(), test.wantConflict)
+				}
+				return
+			}
+			if test.wantErr != nil {
+				if !errors.Is(resolveErr, test.wantErr) {
+					t.Errorf("ResolveDependencies error = %v, want %v", resolveErr, test.wantErr)
+				}
+				return
+			}
+			if resolveErr != nil {
+				t.Fatal(resolveErr)
+			}
+			if got := resolvedVersions(plan); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ResolveDependencies = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveWithStability(t *testing.T) {
+	config.SetFlag("stability", ficsitapp.StabilityRelease)
+	defer config.SetFlag("stability", ficsitapp.StabilityAlpha)
+	versions := map[string][]ficsitapp.ModVersion{
+		"A": {
+			{Version: "1.2.0", Stability: ficsitapp.StabilityAlpha},
+			{Version: "1.1.0", Stability: ficsitapp.StabilityBeta},
+			{Version: "1.0.0", Stability: ficsitapp.StabilityRelease},
+		},
+	}
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"*", "1.0.0"},
+		{"1.1.0", "1.1.0"},
+		{">=1.1.0", ""},
+	}
+	for _, test := range tests {
+		requirements := []Requirement{{ModID: "A", Constraint: test.constraint}}
+		plan, resolveErr := ResolveDependencies(requirements, withStability(testSource(versions), requirements), false)
+		if test.want == "" {
+			var conflictErr *ConflictError
+			if !errors.As(resolveErr, &conflictErr) {
+				t.Errorf("resolving A@%s error = %v, want a conflict", test.constraint, resolveErr)
+			}
+			continue
+		}
+		if resolveErr != nil {
+			t.Errorf("resolving A@%s: %v", test.constraint, resolveErr)
+		} else if got := resolvedVersions(plan)["A"]; got != test.want {
+			t.Errorf("resolving A@%s = %s, want %s", test.constraint, got, test.want)
+		}
+	}
+}
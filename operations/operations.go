@@ -0,0 +1,191 @@
+package operations
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/lockfile"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/profiles"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+// writeDownloadsLockfile adds the resolved mods to the lockfile of the downloaded mods.
+// The download already succeeded, so failing to write the lockfile is only a warning
+func writeDownloadsLockfile(reporter *util.Reporter, plan []modhandler.ResolvedMod) {
+	lock, lockErr := lockfile.FromPlan(reporter, plan)
+	if lockErr == nil {
+		existing, readErr := lockfile.Read(lockfile.DownloadsPath())
+		if readErr == nil {
+			lock = existing.Merge(lock)
+		}
+		lockErr = lockfile.Write(lockfile.DownloadsPath(), lock)
+	}
+	if lockErr != nil {
+		reporter.Progress("Warning: failed to write lockfile: " + lockErr.Error())
+	}
+}
+
+// writeInstallLockfile writes the lockfile of the mods installed in the SML path.
+// The install already changed, so failing to write the lockfile is only a warning
+func writeInstallLockfile(reporter *util.Reporter, smlPath string) {
+	lock, lockErr := lockfile.FromInstalled(reporter, smlPath)
+	if lockErr == nil {
+		lockErr = lockfile.Write(lockfile.InstallPath(smlPath), lock)
+	}
+	if lockErr != nil {
+		reporter.Progress("Warning: failed to write lockfile: " + lockErr.Error())
+	}
+}
+
+// Download downloads the mod and its dependencies, and adds them to the lockfile of the downloaded mods.
+// An empty version downloads the latest one meeting the stability policy of the mod
+func Download(reporter *util.Reporter, modID string, version string, withOptional bool) (DownloadResult, error) {
+	stability := ""
+	if version == "" {
+		latest, getLatestErr := ficsitapp.GetLatestVersion(reporter, modID)
+		if getLatestErr != nil {
+			return DownloadResult{}, getLatestErr
+		}
+		version = latest.Version
+		stability = latest.Stability
+	}
+	plan, dependencyCnt, downloadErr := modhandler.DownloadModWithDependencies(reporter, modID, version, withOptional)
+	if downloadErr != nil {
+		return DownloadResult{}, fmt.Errorf("mod %s@%s could not be downloaded: %w", modID, version, downloadErr)
+	}
+	for _, mod := range plan {
+		if mod.ModID == modID && mod.Stability != "" {
+			stability = mod.Stability
+		}
+	}
+	writeDownloadsLockfile(reporter, plan)
+	return DownloadResult{ModID: modID, Version: version, Stability: stability, DependencyCount: dependencyCnt - 1, Resolved: plan}, nil
+}
+
+// Update updates the downloaded mod to the latest version meeting its stability policy
+func Update(reporter *util.Reporter, modID string) (UpdateResult, error) {
+	updated, dependencyCnt, updateErr := modhandler.Update(reporter, modID)
+	if updateErr != nil {
+		return UpdateResult{}, updateErr
+	}
+	currentVersion, getLatestDownloadedErr := modhandler.GetLatestDownloadedVersion(modID)
+	if getLatestDownloadedErr != nil {
+		return UpdateResult{}, getLatestDownloadedErr
+	}
+	return UpdateResult{ModID: modID, Version: currentVersion, Updated: updated, DependencyCount: dependencyCnt - 1}, nil
+}
+
+// Remove removes the downloaded version of the mod, or all of them if version is empty, and removes them from the lockfile of the downloaded mods.
+// The versions that can not be removed are reported in the result with the code given by errorCode, and the others are still removed
+func Remove(reporter *util.Reporter, modID string, version string, errorCode func(err error) string) (RemoveResult, error) {
+	versions := []string{version}
+	if version == "" {
+		var getDownloadedErr error
+		versions, getDownloadedErr = modhandler.GetDownloadedModVersions(modID)
+		if getDownloadedErr != nil {
+			return RemoveResult{}, getDownloadedErr
+		}
+	}
+	result := RemoveResult{ModID: modID, Removed: []string{}, Errors: []Error{}}
+	for _, modVersion := range versions {
+		if removeErr := modhandler.Remove(modID, modVersion); removeErr != nil {
+			removeErr = fmt.Errorf("failed to remove %s@%s: %w", modID, modVersion, removeErr)
+			result.Errors = append(result.Errors, Error{Code: errorCode(removeErr), Message: removeErr.Error()})
+		} else {
+			result.Removed = append(result.Removed, modVersion)
+		}
+	}
+	if len(result.Removed) > 0 {
+		removeFromDownloadsLockfile(reporter, modID, result.Removed)
+	}
+	return result, nil
+}
+
+// removeFromDownloadsLockfile removes the versions of the mod from the lockfile of the downloaded mods.
+// The versions are already removed, so failing to write the lockfile is only a warning
+func removeFromDownloadsLockfile(reporter *util.Reporter, modID string, versions []string) {
+	lock, lockErr := lockfile.Read(lockfile.DownloadsPath())
+	if os.IsNotExist(lockErr) {
+		return
+	}
+	if lockErr == nil {
+		for _, version := range versions {
+			lock = lock.Without(modID, version)
+		}
+		lockErr = lockfile.Write(lockfile.DownloadsPath(), lock)
+	}
+	if lockErr != nil {
+		reporter.Progress("Warning: failed to write lockfile: " + lockErr.Error())
+	}
+}
+
+// defaultVersion returns the version, or the latest downloaded version of the mod if it is empty
+func defaultVersion(modID string, version string) (string, error) {
+	if version != "" {
+		return version, nil
+	}
+	return modhandler.GetLatestDownloadedVersion(modID)
+}
+
+// Install installs the mod and its dependencies to the SML path, and writes the lockfile of the install.
+// An empty version installs the latest downloaded one
+func Install(reporter *util.Reporter, modID string, version string, smlPath string, withOptional bool) (InstallResult, error) {
+	version, versionErr := defaultVersion(modID, version)
+	if versionErr != nil {
+		return InstallResult{}, versionErr
+	}
+	if installErr := modhandler.InstallModWithDependencies(nil, reporter, modID, version, smlPath, withOptional); installErr != nil {
+		return InstallResult{}, fmt.Errorf("failed to install mod %s@%s: %w", modID, version, installErr)
+	}
+	writeInstallLockfile(reporter, smlPath)
+	installed, getInstalledErr := modhandler.GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return InstallResult{}, getInstalledErr
+	}
+	return InstallResult{ModID: modID, Version: version, Path: smlPath, Installed: installed}, nil
+}
+
+// Uninstall uninstalls the mod from the SML path, and its dependents too if cascade is set, then writes the lockfile of the install.
+// An empty version uninstalls the installed one
+func Uninstall(reporter *util.Reporter, modID string, version string, smlPath string, cascade bool) (UninstallResult, error) {
+	if version == "" {
+		var getInstalledErr error
+		version, getInstalledErr = modhandler.GetInstalledModVersion(modID, smlPath)
+		if getInstalledErr != nil {
+			return UninstallResult{}, getInstalledErr
+		}
+	}
+	dependents, uninstallErr := modhandler.UninstallModWithDependents(modID, version, smlPath, cascade)
+	if uninstallErr != nil {
+		return UninstallResult{}, fmt.Errorf("failed to uninstall mod %s@%s: %w", modID, version, uninstallErr)
+	}
+	writeInstallLockfile(reporter, smlPath)
+	installed, getInstalledErr := modhandler.GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return UninstallResult{}, getInstalledErr
+	}
+	return UninstallResult{ModID: modID, Version: version, Path: smlPath, Removed: dependents, Mods: installed}, nil
+}
+
+// AutoRemove uninstalls the unneeded dependencies from the SML path, and writes the lockfile of the install if any were
+func AutoRemove(reporter *util.Reporter, smlPath string) (AutoRemoveResult, error) {
+	removed, removeErr := modhandler.AutoRemove(smlPath)
+	if removeErr != nil {
+		return AutoRemoveResult{}, removeErr
+	}
+	if len(removed) > 0 {
+		writeInstallLockfile(reporter, smlPath)
+	}
+	return AutoRemoveResult{Path: smlPath, Removed: removed}, nil
+}
+
+// ApplyProfile makes the mods installed in the SML path match the profile, and writes the lockfile of the install
+func ApplyProfile(reporter *util.Reporter, name string, smlPath string) error {
+	if applyErr := profiles.Apply(reporter, name, smlPath); applyErr != nil {
+		return applyErr
+	}
+	writeInstallLockfile(reporter, smlPath)
+	return nil
+}
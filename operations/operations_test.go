@@ -0,0 +1,311 @@
+package operations
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/fakeserver"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/lockfile"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+var testCreatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testVersion(modID string, version string, dependencies map[string]string) fakeserver.Version {
+	fakeDependencies := []fakeserver.Dependency{}
+	for dependencyID, constraint := range dependencies {
+		fakeDependencies = append(fakeDependencies, fakeserver.Dependency{ModID: dependencyID, Condition: constraint})
+	}
+	return fakeserver.Version{
+		Version:      version,
+		Stability:    ficsitapp.StabilityRelease,
+		CreatedAt:    testCreatedAt,
+		Dependencies: fakeDependencies,
+		Zip:          fakeserver.ModZip(modID, version, dependencies, nil),
+	}
+}
+
+// testLib is the Lib mod with the versions published, so the update test can publish a new one
+func testLib(versions ...string) fakeserver.Mod {
+	mod := fakeserver.Mod{ID: "Lib", Name: "Lib"}
+	for _, version := range versions {
+		mod.Versions = append(mod.Versions, testVersion("Lib", version, nil))
+	}
+	return mod
+}
+
+// setup starts a fake ficsit.app with App depending on Lib, and Extra depending on App,
+// and uses a new data directory and Satisfactory install. The returned function cleans them up
+func setup(t *testing.T) (*fakeserver.Server, string, func()) {
+	server := fakeserver.New([]fakeserver.Mod{
+		testLib("1.0.0", "1.1.0"),
+		{ID: "App", Name: "App", Versions: []fakeserver.Version{testVersion("App", "1.0.0", map[string]string{"Lib": "^1.0.0"})}},
+		{ID: "Extra", Name: "Extra", Versions: []fakeserver.Version{testVersion("Extra", "1.0.0", map[string]string{"App": "^1.0.0"})}},
+	}, nil)
+	dataDir, tempErr := ioutil.TempDir("", "operations")
+	if tempErr != nil {
+		server.Close()
+		t.Fatal(tempErr)
+	}
+	cleanup := func() {
+		server.Close()
+		os.RemoveAll(dataDir)
+	}
+	if setErr := paths.SetDataDir(path.Join(dataDir, "data"), false); setErr != nil {
+		cleanup()
+		t.Fatal(setErr)
+	}
+	paths.Init()
+	smlPath := path.Join(dataDir, "Satisfactory")
+	if mkdirErr := os.MkdirAll(smlPath, 0755); mkdirErr != nil {
+		cleanup()
+		t.Fatal(mkdirErr)
+	}
+	ficsitapp.SetAPIURL(server.APIURL())
+	config.SetFlag("offline", "false")
+	config.SetFlag("stability", ficsitapp.StabilityRelease)
+	config.SetFlag("mod-stability", "")
+	config.SetFlag("cache-ttl", "0s")
+	return server, smlPath, cleanup
+}
+
+func modIDs(mods []modhandler.DataJSON) []string {
+	ids := []string{}
+	for _, mod := range mods {
+		ids = append(ids, mod.ModID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func lockedMods(t *testing.T, lockfilePath string) map[string]string {
+	lock, readErr := lockfile.Read(lockfilePath)
+	if readErr != nil {
+		t.Fatalf("lockfile %s: %v", lockfilePath, readErr)
+	}
+	versions := map[string]string{}
+	for _, mod := range lock.Mods {
+		versions[mod.ModID] = mod.Version
+	}
+	return versions
+}
+
+func equalIDs(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDownload(t *testing.T) {
+	_, _, cleanup := setup(t)
+	defer cleanup()
+	result, downloadErr := Download(nil, "App", "", false)
+	if downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	if result.Version != "1.0.0" || result.Stability != ficsitapp.StabilityRelease || result.DependencyCount != 1 {
+		t.Errorf("Download = %s@%s (%s) with %d dependencies, want App@1.0.0 (release) with 1", result.ModID, result.Version, result.Stability, result.DependencyCount)
+	}
+	libVersion, getLatestErr := modhandler.GetLatestDownloadedVersion("Lib")
+	if getLatestErr != nil || libVersion != "1.1.0" {
+		t.Errorf("downloaded Lib = %q, %v, want 1.1.0", libVersion, getLatestErr)
+	}
+	locked := lockedMods(t, lockfile.DownloadsPath())
+	if locked["App"] != "1.0.0" || locked["Lib"] != "1.1.0" {
+		t.Errorf("downloads lockfile = %v, want App@1.0.0 and Lib@1.1.0", locked)
+	}
+	if _, downloadErr := Download(nil, "Missing", "", false); !errors.Is(downloadErr, ficsitapp.ErrModNotFound) {
+		t.Errorf("Download of a missing mod error = %v, want %v", downloadErr, ficsitapp.ErrModNotFound)
+	}
+}
+
+func TestInstallWithDependencies(t *testing.T) {
+	_, smlPath, cleanup := setup(t)
+	defer cleanup()
+	if _, downloadErr := Download(nil, "App", "", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	result, installErr := Install(nil, "App", "", smlPath, false)
+	if installErr != nil {
+		t.Fatal(installErr)
+	}
+	if result.Version != "1.0.0" || !equalIDs(modIDs(result.Installed), "App", "Lib") {
+		t.Errorf("Install = App@%s with %v installed, want App@1.0.0 with App and Lib", result.Version, modIDs(result.Installed))
+	}
+	autoInstalled, stateErr := modhandler.IsAutoInstalled("Lib", smlPath)
+	if stateErr != nil || !autoInstalled {
+		t.Errorf("Lib installed as a dependency = %t, %v, want true", autoInstalled, stateErr)
+	}
+	locked := lockedMods(t, lockfile.InstallPath(smlPath))
+	if locked["App"] != "1.0.0" || locked["Lib"] != "1.1.0" {
+		t.Errorf("install lockfile = %v, want App@1.0.0 and Lib@1.1.0", locked)
+	}
+}
+
+func TestUninstallBlockedByDependent(t *testing.T) {
+	_, smlPath, cleanup := setup(t)
+	defer cleanup()
+	if _, downloadErr := Download(nil, "Extra", "", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	if _, installErr := Install(nil, "Extra", "", smlPath, false); installErr != nil {
+		t.Fatal(installErr)
+	}
+	if _, uninstallErr := Uninstall(nil, "App", "", smlPath, false); !errors.Is(uninstallErr, modhandler.ErrModRequired) {
+		t.Fatalf("Uninstall of a required mod error = %v, want %v", uninstallErr, modhandler.ErrModRequired)
+	}
+	installed, getInstalledErr := modhandler.GetInstalledMods(smlPath)
+	if getInstalledErr != nil || !equalIDs(modIDs(installed), "App", "Extra", "Lib") {
+		t.Errorf("installed after the blocked uninstall = %v, %v, want App, Extra and Lib", modIDs(installed), getInstalledErr)
+	}
+	result, uninstallErr := Uninstall(nil, "App", "", smlPath, true)
+	if uninstallErr != nil {
+		t.Fatal(uninstallErr)
+	}
+	if !equalIDs(modIDs(result.Removed), "Extra") || !equalIDs(modIDs(result.Mods), "Lib") {
+		t.Errorf("cascading Uninstall removed %v leaving %v, want Extra removed leaving Lib", modIDs(result.Removed), modIDs(result.Mods))
+	}
+	if locked := lockedMods(t, lockfile.InstallPath(smlPath)); len(locked) != 1 || locked["Lib"] == "" {
+		t.Errorf("install lockfile = %v, want only Lib", locked)
+	}
+}
+
+func TestUninstallInstalledVersion(t *testing.T) {
+	_, smlPath, cleanup := setup(t)
+	defer cleanup()
+	if _, downloadErr := Download(nil, "Lib", "1.0.0", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	if _, installErr := Install(nil, "Lib", "1.0.0", smlPath, false); installErr != nil {
+		t.Fatal(installErr)
+	}
+	if _, downloadErr := Download(nil, "Lib", "1.1.0", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	result, uninstallErr := Uninstall(nil, "Lib", "", smlPath, false)
+	if uninstallErr != nil || result.Version != "1.0.0" || len(result.Mods) != 0 {
+		t.Errorf("Uninstall without a version = %+v, %v, want the installed Lib@1.0.0 uninstalled", result, uninstallErr)
+	}
+	if _, uninstallErr := Uninstall(nil, "Lib", "", smlPath, false); !errors.Is(uninstallErr, modhandler.ErrModNotInstalled) {
+		t.Errorf("Uninstall of a mod that is not installed error = %v, want %v", uninstallErr, modhandler.ErrModNotInstalled)
+	}
+}
+
+func TestAutoRemove(t *testing.T) {
+	_, smlPath, cleanup := setup(t)
+	defer cleanup()
+	if _, downloadErr := Download(nil, "App", "", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	if _, installErr := Install(nil, "App", "", smlPath, false); installErr != nil {
+		t.Fatal(installErr)
+	}
+	result, removeErr := AutoRemove(nil, smlPath)
+	if removeErr != nil || len(result.Removed) != 0 {
+		t.Fatalf("AutoRemove with App installed removed %v, %v, want nothing", modIDs(result.Removed), removeErr)
+	}
+	if _, uninstallErr := Uninstall(nil, "App", "", smlPath, false); uninstallErr != nil {
+		t.Fatal(uninstallErr)
+	}
+	result, removeErr = AutoRemove(nil, smlPath)
+	if removeErr != nil || !equalIDs(modIDs(result.Removed), "Lib") {
+		t.Errorf("AutoRemove removed %v, %v, want Lib", modIDs(result.Removed), removeErr)
+	}
+	if locked := lockedMods(t, lockfile.InstallPath(smlPath)); len(locked) != 0 {
+		t.Errorf("install lockfile = %v, want no mods", locked)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	server, _, cleanup := setup(t)
+	defer cleanup()
+	if _, downloadErr := Download(nil, "Lib", "1.0.0", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	result, updateErr := Update(nil, "Lib")
+	if updateErr != nil || !result.Updated || result.Version != "1.1.0" {
+		t.Fatalf("Update = %+v, %v, want Lib updated to 1.1.0", result, updateErr)
+	}
+	result, updateErr = Update(nil, "Lib")
+	if updateErr != nil || result.Updated || result.Version != "1.1.0" {
+		t.Errorf("Update when up to date = %+v, %v, want Lib not updated at 1.1.0", result, updateErr)
+	}
+	server.SetMod(testLib("1.0.0", "1.1.0", "1.2.0"))
+	result, updateErr = Update(nil, "Lib")
+	if updateErr != nil || !result.Updated || result.Version != "1.2.0" {
+		t.Errorf("Update after a release = %+v, %v, want Lib updated to 1.2.0", result, updateErr)
+	}
+}
+
+func TestOffline(t *testing.T) {
+	server, smlPath, cleanup := setup(t)
+	defer cleanup()
+	config.SetFlag("cache-ttl", "1h")
+	if _, downloadErr := Download(nil, "App", "", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	config.SetFlag("offline", "true")
+	defer config.SetFlag("offline", "false")
+	queries := server.Requests("/v2/query")
+
+	result, downloadErr := Download(nil, "App", "", false)
+	if downloadErr != nil || result.Version != "1.0.0" || result.DependencyCount != 1 {
+		t.Errorf("offline Download of a cached mod = %+v, %v, want App@1.0.0 with 1 dependency", result, downloadErr)
+	}
+	if _, installErr := Install(nil, "App", "", smlPath, false); installErr != nil {
+		t.Errorf("offline Install of a downloaded mod: %v", installErr)
+	}
+	if _, downloadErr := Download(nil, "Extra", "", false); !errors.Is(downloadErr, ficsitapp.ErrNotCached) {
+		t.Errorf("offline Download of an uncached mod error = %v, want %v", downloadErr, ficsitapp.ErrNotCached)
+	}
+	if _, downloadErr := Download(nil, "Lib", "1.0.0", false); downloadErr == nil {
+		t.Errorf("offline Download of a version that is not downloaded succeeded, want an error")
+	}
+	if server.Requests("/v2/query") != queries || server.Requests("/download/Lib/1.0.0") != 0 {
+		t.Errorf("offline operations made %d queries and %d downloads, want none",
+			server.Requests("/v2/query")-queries, server.Requests("/download/Lib/1.0.0"))
+	}
+}
+
+func TestRemove(t *testing.T) {
+	_, _, cleanup := setup(t)
+	defer cleanup()
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if _, downloadErr := Download(nil, "Lib", version, false); downloadErr != nil {
+			t.Fatal(downloadErr)
+		}
+	}
+	if _, downloadErr := Download(nil, "App", "", false); downloadErr != nil {
+		t.Fatal(downloadErr)
+	}
+	errorCode := func(err error) string { return "error" }
+	result, removeErr := Remove(nil, "Lib", "1.2.0", errorCode)
+	if removeErr != nil || len(result.Removed) != 0 || len(result.Errors) != 1 {
+		t.Errorf("Remove of a version that is not downloaded = %+v, %v, want one error", result, removeErr)
+	}
+	result, removeErr = Remove(nil, "Lib", "", errorCode)
+	if removeErr != nil || len(result.Errors) != 0 {
+		t.Fatalf("Remove = %+v, %v", result, removeErr)
+	}
+	sort.Strings(result.Removed)
+	if !equalIDs(result.Removed, "1.0.0", "1.1.0") {
+		t.Errorf("Remove removed %v, want 1.0.0 and 1.1.0", result.Removed)
+	}
+	if locked := lockedMods(t, lockfile.DownloadsPath()); len(locked) != 1 || locked["App"] != "1.0.0" {
+		t.Errorf("downloads lockfile = %v, want only App@1.0.0", locked)
+	}
+}
@@ -0,0 +1,123 @@
+// Package operations runs the launcher operations shared by the CLI and the HTTP API, so both leave the same state behind,
+// and has the results of the operations printed by the CLI and sent by the API
+package operations
+
+import (
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/lockfile"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/smlhandler"
+)
+
+// Error is the structured form of an error
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// DownloadResult is the result of downloading a mod with its dependencies
+type DownloadResult struct {
+	ModID   string `json:"mod_id"`
+	Version string `json:"version"`
+	// Stability is empty if it is not known
+	Stability       string                   `json:"stability"`
+	DependencyCount int                      `json:"dependency_count"`
+	Resolved        []modhandler.ResolvedMod `json:"resolved"`
+}
+
+// RemoveResult is the result of removing the downloaded versions of a mod
+type RemoveResult struct {
+	ModID   string   `json:"mod_id"`
+	Removed []string `json:"removed"`
+	Errors  []Error  `json:"errors"`
+}
+
+// UpdateResult is the result of updating a downloaded mod
+type UpdateResult struct {
+	ModID           string `json:"mod_id"`
+	Version         string `json:"version"`
+	Updated         bool   `json:"updated"`
+	DependencyCount int    `json:"dependency_count"`
+}
+
+// ListVersionsResult is the downloaded versions of a mod
+type ListVersionsResult struct {
+	ModID    string   `json:"mod_id"`
+	Versions []string `json:"versions"`
+	// Stabilities of the versions, empty if ficsit.app could not be reached
+	Stabilities map[string]string `json:"stabilities"`
+}
+
+// InstallResult is the result of installing a mod, with the mods installed after it
+type InstallResult struct {
+	ModID     string                `json:"mod_id"`
+	Version   string                `json:"version"`
+	Path      string                `json:"path"`
+	Installed []modhandler.DataJSON `json:"installed"`
+}
+
+// LockResult is the lockfile written or synced
+type LockResult struct {
+	Path string               `json:"path"`
+	Mods []lockfile.LockedMod `json:"mods"`
+}
+
+// CheckUpdatesResult is the available updates of the downloaded mods and of SML
+type CheckUpdatesResult struct {
+	Mods []modhandler.ModUpdate `json:"mods"`
+	SML  *smlhandler.Update     `json:"sml"`
+}
+
+// StabilityResult is the stability policy for all mods and the ones set for single mods
+type StabilityResult struct {
+	Stability string            `json:"stability"`
+	Mods      map[string]string `json:"mods"`
+}
+
+// UninstallResult is the result of uninstalling a mod, with the mods installed after it
+type UninstallResult struct {
+	ModID   string                `json:"mod_id"`
+	Version string                `json:"version"`
+	Path    string                `json:"path"`
+	Removed []modhandler.DataJSON `json:"removed_dependents"`
+	Mods    []modhandler.DataJSON `json:"mods"`
+}
+
+// AutoRemoveResult is the unneeded dependencies that were uninstalled
+type AutoRemoveResult struct {
+	Path    string                `json:"path"`
+	Removed []modhandler.DataJSON `json:"removed"`
+}
+
+// WhyResult is the chains of installed mods that require a mod
+type WhyResult struct {
+	ModID string                         `json:"mod_id"`
+	Paths [][]modhandler.RequirementLink `json:"paths"`
+}
+
+// VersionResult is a single version, like the installed SML version
+type VersionResult struct {
+	Version string `json:"version"`
+}
+
+// DirsResult is the directories used by the launcher
+type DirsResult struct {
+	Data   string `json:"data"`
+	Mods   string `json:"mods"`
+	Cache  string `json:"cache"`
+	Config string `json:"config"`
+}
+
+// SettingResult is a setting with its value and where the value comes from
+type SettingResult struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Source      string `json:"source"`
+	Env         string `json:"env,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathResult is a single path
+type PathResult struct {
+	Path string `json:"path"`
+}
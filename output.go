@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akamensky/argparse"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/daemon"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/ficsitapp"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/lockfile"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/operations"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/profiles"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/satisfactoryinstall"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/smlhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+)
+
+var (
+	errInvalidArguments = errors.New("invalid arguments")
+	errUnknownCommand   = errors.New("unrecognized command")
+)
+
+// errorCodes maps the errors to the codes printed with the structured output formats, the first match wins
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{errInvalidArguments, "invalid_arguments"},
+	{errUnknownCommand, "unknown_command"},
+	{daemon.ErrInvalidRequest, "invalid_request"},
+	{daemon.ErrMethodNotAllowed, "method_not_allowed"},
+	{daemon.ErrForbidden, "forbidden"},
+	{daemon.ErrUnauthorized, "unauthorized"},
+	{daemon.ErrUnsupportedMediaType, "unsupported_media_type"},
+	{daemon.ErrNotLoopback, "not_loopback"},
+	{ficsitapp.ErrModNotFound, "mod_not_found"},
+	{ficsitapp.ErrVersionNotFound, "version_not_found"},
+	{ficsitapp.ErrNotCached, "not_cached"},
+	{ficsitapp.ErrOffline, "offline"},
+	{modhandler.ErrModNotFound, "mod_not_downloaded"},
+	{modhandler.ErrVersionNotFound, "version_not_downloaded"},
+	{modhandler.ErrNoDataJSON, "invalid_mod_zip"},
+	{modhandler.ErrModNotInstalled, "mod_not_installed"},
+	{modhandler.ErrModAlreadyInstalled, "mod_already_installed"},
+	{modhandler.ErrModRequired, "mod_required"},
+	{lockfile.ErrHashMismatch, "lockfile_hash_mismatch"},
+	{util.ErrDownloadMismatch, "download_mismatch"},
+	{smlhandler.ErrSMLNotInstalled, "sml_not_installed"},
+	{smlhandler.ErrVersionNotFound, "sml_version_not_found"},
+	{smlhandler.ErrNewerInstalled, "sml_newer_installed"},
+	{smlhandler.ErrUpToDate, "sml_up_to_date"},
+	{smlhandler.ErrNoReleases, "sml_no_releases"},
+	{satisfactoryinstall.ErrInstallNotFound, "install_not_found"},
+	{satisfactoryinstall.ErrInstallExists, "install_exists"},
+	{satisfactoryinstall.ErrInvalidInstallName, "invalid_install_name"},
+	{satisfactoryinstall.ErrNotBinariesDir, "invalid_path"},
+	{satisfactoryinstall.ErrNoDefaultInstall, "no_default_install"},
+	{config.ErrUnknownSetting, "unknown_setting"},
+	{config.ErrNotInFile, "setting_not_in_file"},
+	{config.ErrInvalidValue, "invalid_setting_value"},
+	{profiles.ErrProfileNotFound, "profile_not_found"},
+	{profiles.ErrProfileExists, "profile_exists"},
+	{profiles.ErrInvalidName, "invalid_profile_name"},
+	{profiles.ErrModNotInProfile, "mod_not_in_profile"},
+}
+
+func printProfileResult(name string) {
+	profile, getErr := profiles.Get(name)
+	check(getErr)
+	printResult(profile)
+}
+
+func errorCode(err error) string {
+	var conflictErr *modhandler.ConflictError
+	if errors.As(err, &conflictErr) {
+		return "dependency_conflict"
+	}
+	for _, errorCode := range errorCodes {
+		if errors.Is(err, errorCode.err) {
+			return errorCode.code
+		}
+	}
+	return "error"
+}
+
+// resultOut is where the results are printed in the structured output formats
+var resultOut io.Writer = os.Stdout
+
+// messageWriter is where the human readable messages are printed. With a structured output format
+// it is stderr, so stdout only has the result
+var messageWriter io.Writer = os.Stdout
+
+// reporter prints the progress of the operations run by the command to the message writer
+var reporter = util.NewReporter(func(message string) {
+	fmt.Fprintln(messageWriter, message)
+}, nil)
+
+// outputFormat is the format of the results, read once so changing the setting does not switch it in the middle of a command
+var outputFormat = "text"
+
+// initOutput checks the output format. With a structured format the human readable messages,
+// including the progress messages of the operations, go to stderr
+func initOutput() error {
+	switch format := config.Get("output"); format {
+	case "text":
+	case "json", "yaml":
+		outputFormat = format
+		messageWriter = os.Stderr
+	default:
+		return fmt.Errorf("%w: unknown output format %s", errInvalidArguments, format)
+	}
+	return nil
+}
+
+// progressBarWidth is the number of characters of the download progress bar
+const progressBarWidth = 30
+
+// formatBytes formats a size in bytes with the largest unit that keeps it at least 1
+func formatBytes(size float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.Itoa(int(size)) + " " + units[unit]
+	}
+	return strconv.FormatFloat(size, 'f', 1, 64) + " " + units[unit]
+}
+
+// formatDownloadProgress formats a running download as a progress bar, or only the downloaded size if the total is not known
+func formatDownloadProgress(progress util.DownloadProgress) string {
+	rate := formatBytes(progress.Rate) + "/s"
+	if progress.Total <= 0 {
+		return progress.File + " " + formatBytes(float64(progress.Downloaded)) + " " + rate
+	}
+	done := int(progress.Downloaded * progressBarWidth / progress.Total)
+	bar := strings.Repeat("#", done) + strings.Repeat("-", progressBarWidth-done)
+	percent := strconv.Itoa(int(progress.Downloaded*100/progress.Total)) + "%"
+	line := progress.File + " [" + bar + "] " + percent + " " + formatBytes(float64(progress.Downloaded)) + " / " + formatBytes(float64(progress.Total)) + " " + rate
+	if progress.ETA > 0 {
+		line += " ETA " + progress.ETA.Round(time.Second).String()
+	}
+	return line
+}
+
+// initProgressBar draws the download progress as a bar on stderr when it is a terminal.
+// Progress messages clear the bar first, so they are not printed in the middle of it
+func initProgressBar() {
+	info, statErr := os.Stderr.Stat()
+	if statErr != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	barShown := false
+	clearBar := func() {
+		if barShown {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+			barShown = false
+		}
+	}
+	// the reporter calls both handlers one at a time, so barShown needs no lock
+	reporter = util.NewReporter(func(message string) {
+		clearBar()
+		fmt.Fprintln(messageWriter, message)
+	}, func(progress util.DownloadProgress) {
+		clearBar()
+		if !progress.Done {
+			fmt.Fprint(os.Stderr, formatDownloadProgress(progress))
+			barShown = true
+		}
+	})
+}
+
+// printResult prints the result of the command in the structured output format. Text output is printed by the commands themselves
+func printResult(result interface{}) {
+	check(writeResult(result))
+}
+
+// writeResult writes the result in the structured output format, if one is used
+func writeResult(result interface{}) error {
+	switch outputFormat {
+	case "json":
+		encoder := json.NewEncoder(resultOut)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "yaml":
+		// go through json so the json field names are used
+		jsonResult, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		var value interface{}
+		if unmarshalErr := json.Unmarshal(jsonResult, &value); unmarshalErr != nil {
+			return unmarshalErr
+		}
+		var builder strings.Builder
+		writeYAML(&builder, value, 0)
+		_, writeErr := fmt.Fprint(resultOut, builder.String())
+		return writeErr
+	}
+	return nil
+}
+
+// writeYAML writes the decoded json value as yaml. Strings are quoted the json way, which is valid yaml
+func writeYAML(builder *strings.Builder, value interface{}, indent int) {
+	prefix := strings.Repeat("  ", indent)
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if len(typedValue) == 0 {
+			builder.WriteString(prefix + "{}\n")
+			return
+		}
+		keys := []string{}
+		for key := range typedValue {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			builder.WriteString(prefix + strconv.Quote(key) + ":")
+			writeYAMLValue(builder, typedValue[key], indent)
+		}
+	case []interface{}:
+		if len(typedValue) == 0 {
+			builder.WriteString(prefix + "[]\n")
+			return
+		}
+		for _, item := range typedValue {
+			builder.WriteString(prefix + "-")
+			writeYAMLValue(builder, item, indent)
+		}
+	default:
+		builder.WriteString(prefix + yamlScalar(typedValue) + "\n")
+	}
+}
+
+// writeYAMLValue writes the value after a key or list item marker
+func writeYAMLValue(builder *strings.Builder, value interface{}, indent int) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if len(typedValue) == 0 {
+			builder.WriteString(" {}\n")
+			return
+		}
+		builder.WriteString("\n")
+		writeYAML(builder, typedValue, indent+1)
+	case []interface{}:
+		if len(typedValue) == 0 {
+			builder.WriteString(" []\n")
+			return
+		}
+		builder.WriteString("\n")
+		writeYAML(builder, typedValue, indent+1)
+	default:
+		builder.WriteString(" " + yamlScalar(typedValue) + "\n")
+	}
+}
+
+func yamlScalar(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(typedValue)
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typedValue)
+	}
+	return fmt.Sprint(value)
+}
+
+// check prints the error and exits. With a structured output format the error is also printed as the result, with its code
+func check(err error) {
+	if err == nil {
+		return
+	}
+	// the error is printed to stderr too, so failing to write the result again is ignored rather than reported in a loop
+	writeResult(map[string]interface{}{"error": operations.Error{Code: errorCode(err), Message: err.Error()}})
+	// exiting skips the deferred save
+	modhandler.SaveIndex()
+	util.Check(err)
+}
+
+// parseArgs parses the command arguments, exiting on invalid ones
+func parseArgs(parser *argparse.Parser) {
+	parseErr := parser.Parse(args)
+	if parseErr != nil {
+		check(fmt.Errorf("%w: %s", errInvalidArguments, parseErr.Error()))
+	}
+}
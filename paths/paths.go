@@ -5,10 +5,138 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
 )
 
-var SMLauncherDir = path.Join(os.Getenv("LOCALAPPDATA"), "SatisfactoryModLauncher")
-var ModsDir = path.Join(SMLauncherDir, "DownloadedMods")
+// appDirName is the name of the launcher directory in the OS data, cache and config directories
+const appDirName = "SatisfactoryModLauncher"
+
+// PortableMarker is the file that turns on portable mode when it is next to the executable
+const PortableMarker = "smlauncher.portable"
+
+var (
+	// SMLauncherDir is where the launcher keeps its data, like the downloaded mods
+	SMLauncherDir string
+	// ModsDir is where the mods are downloaded
+	ModsDir string
+	// CacheDir is where the launcher keeps files that can be downloaded again
+	CacheDir string
+	// ConfigDir is where the launcher keeps its settings
+	ConfigDir string
+	// usesDefaultDirs is true when the OS directories are used, rather than a data directory or portable mode
+	usesDefaultDirs bool
+)
+
+func init() {
+	SetDataDir("", false)
+}
+
+// defaultDirs returns the data, cache and config directories of the OS.
+// Windows uses LOCALAPPDATA and APPDATA, macOS Application Support and Caches, and the others the XDG base directories
+func defaultDirs() (string, string, string) {
+	home, _ := os.UserHomeDir()
+	var dataDir string
+	switch runtime.GOOS {
+	case "windows":
+		dataDir = os.Getenv("LOCALAPPDATA")
+	case "darwin":
+		dataDir = filepath.Join(home, "Library", "Application Support")
+	default:
+		dataDir = os.Getenv("XDG_DATA_HOME")
+		if dataDir == "" {
+			dataDir = filepath.Join(home, ".local", "share")
+		}
+	}
+	dataDir = filepath.Join(dataDir, appDirName)
+	cacheDir := filepath.Join(dataDir, "cache")
+	if userCacheDir, cacheErr := os.UserCacheDir(); cacheErr == nil {
+		cacheDir = filepath.Join(userCacheDir, appDirName)
+	}
+	configDir := filepath.Join(dataDir, "config")
+	if userConfigDir, configErr := os.UserConfigDir(); configErr == nil {
+		configDir = filepath.Join(userConfigDir, appDirName)
+	}
+	// on Windows LOCALAPPDATA is also the cache directory, and on macOS Application Support is also the config directory
+	if cacheDir == dataDir {
+		cacheDir = filepath.Join(dataDir, "cache")
+	}
+	if configDir == dataDir {
+		configDir = filepath.Join(dataDir, "config")
+	}
+	return dataDir, cacheDir, configDir
+}
+
+// portableDir returns the directory next to the executable used in portable mode
+func portableDir() (string, error) {
+	executable, executableErr := os.Executable()
+	if executableErr != nil {
+		return "", executableErr
+	}
+	return filepath.Join(filepath.Dir(executable), appDirName), nil
+}
+
+// IsPortable returns true if there is a portable mode marker next to the executable
+func IsPortable() bool {
+	executable, executableErr := os.Executable()
+	if executableErr != nil {
+		return false
+	}
+	return Exists(filepath.Join(filepath.Dir(executable), PortableMarker))
+}
+
+// SetDataDir changes where the launcher keeps its files. With a data directory, or in portable mode,
+// everything is kept in it, with the cache and config in subdirectories. Otherwise the OS directories are used.
+// Portable mode is also on if IsPortable
+func SetDataDir(dataDir string, portable bool) error {
+	if dataDir == "" && (portable || IsPortable()) {
+		var portableErr error
+		dataDir, portableErr = portableDir()
+		if portableErr != nil {
+			return portableErr
+		}
+	}
+	usesDefaultDirs = dataDir == ""
+	if usesDefaultDirs {
+		SMLauncherDir, CacheDir, ConfigDir = defaultDirs()
+	} else {
+		absoluteDir, absErr := filepath.Abs(dataDir)
+		if absErr != nil {
+			return absErr
+		}
+		SMLauncherDir = absoluteDir
+		CacheDir = filepath.Join(absoluteDir, "cache")
+		ConfigDir = filepath.Join(absoluteDir, "config")
+	}
+	ModsDir = filepath.Join(SMLauncherDir, "DownloadedMods")
+	return nil
+}
+
+// MigrateLegacyDir moves the data of older launchers to the data directory of the OS, if it does not exist yet.
+// Outside Windows they kept their data in a SatisfactoryModLauncher directory in the working directory.
+// Returns the directory that was moved, or an error saying where the data is if it could not be moved
+func MigrateLegacyDir() (string, error) {
+	if !usesDefaultDirs || runtime.GOOS == "windows" {
+		return "", nil
+	}
+	legacyDir, absErr := filepath.Abs(appDirName)
+	if absErr != nil {
+		return "", absErr
+	}
+	if legacyDir == SMLauncherDir || !Exists(filepath.Join(legacyDir, "DownloadedMods")) {
+		return "", nil
+	}
+	if Exists(SMLauncherDir) {
+		return "", fmt.Errorf("the data of an older launcher version is in %s, move its contents to %s to keep using it", legacyDir, SMLauncherDir)
+	}
+	if mkdirErr := os.MkdirAll(filepath.Dir(SMLauncherDir), os.ModePerm); mkdirErr != nil {
+		return "", fmt.Errorf("the data of an older launcher version in %s could not be moved to %s: %w", legacyDir, SMLauncherDir, mkdirErr)
+	}
+	if renameErr := os.Rename(legacyDir, SMLauncherDir); renameErr != nil {
+		return "", fmt.Errorf("the data of an older launcher version in %s could not be moved to %s: %w", legacyDir, SMLauncherDir, renameErr)
+	}
+	return legacyDir, nil
+}
 
 // Exists returns true if the path exists
 func Exists(path string) bool {
@@ -45,6 +173,12 @@ func Init() {
 	if !Exists(ModsDir) {
 		os.MkdirAll(ModsDir, os.ModePerm)
 	}
+	if !Exists(CacheDir) {
+		os.MkdirAll(CacheDir, os.ModePerm)
+	}
+	if !Exists(ConfigDir) {
+		os.MkdirAll(ConfigDir, os.ModePerm)
+	}
 }
 
 // ModDir returns the path the mod should be downloaded to and creates it if it doesn't exist
@@ -0,0 +1,103 @@
+package paths
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// setupLegacy uses the OS directories in a new home directory, and works in another directory with the data of an older launcher.
+// Returns the directory of the older data. The returned function restores the environment and working directory
+func setupLegacy(t *testing.T) (string, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("older launchers kept their data in LOCALAPPDATA on Windows")
+	}
+	dir, tempErr := ioutil.TempDir("", "paths")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	workingDir, getwdErr := os.Getwd()
+	if getwdErr != nil {
+		t.Fatal(getwdErr)
+	}
+	environment := map[string]string{}
+	for _, name := range []string{"HOME", "XDG_DATA_HOME", "XDG_CACHE_HOME", "XDG_CONFIG_HOME"} {
+		environment[name] = os.Getenv(name)
+		os.Setenv(name, "")
+	}
+	cleanup := func() {
+		os.Chdir(workingDir)
+		for name, value := range environment {
+			os.Setenv(name, value)
+		}
+		SetDataDir("", false)
+		os.RemoveAll(dir)
+	}
+	home := filepath.Join(dir, "home")
+	os.Setenv("HOME", home)
+	legacyMod := filepath.Join(dir, "work", appDirName, "DownloadedMods", "A", "A_1.0.0.zip")
+	if mkdirErr := os.MkdirAll(filepath.Dir(legacyMod), 0755); mkdirErr != nil {
+		cleanup()
+		t.Fatal(mkdirErr)
+	}
+	if writeErr := ioutil.WriteFile(legacyMod, []byte("A"), 0644); writeErr != nil {
+		cleanup()
+		t.Fatal(writeErr)
+	}
+	if chdirErr := os.Chdir(filepath.Join(dir, "work")); chdirErr != nil {
+		cleanup()
+		t.Fatal(chdirErr)
+	}
+	legacyDir, absErr := filepath.Abs(appDirName)
+	if absErr != nil {
+		cleanup()
+		t.Fatal(absErr)
+	}
+	if setErr := SetDataDir("", false); setErr != nil {
+		cleanup()
+		t.Fatal(setErr)
+	}
+	if !strings.HasPrefix(SMLauncherDir, home) {
+		cleanup()
+		t.Skipf("the data directory %s is not in the home directory %s", SMLauncherDir, home)
+	}
+	return legacyDir, cleanup
+}
+
+func TestMigrateLegacyDir(t *testing.T) {
+	legacyDir, cleanup := setupLegacy(t)
+	defer cleanup()
+	migrated, migrateErr := MigrateLegacyDir()
+	if migrateErr != nil || migrated != legacyDir {
+		t.Fatalf("MigrateLegacyDir = %q, %v, want %q moved", migrated, migrateErr, legacyDir)
+	}
+	if !Exists(filepath.Join(SMLauncherDir, "DownloadedMods", "A", "A_1.0.0.zip")) || Exists(legacyDir) {
+		t.Errorf("the older data was not moved from %s to %s", legacyDir, SMLauncherDir)
+	}
+	if migrated, migrateErr := MigrateLegacyDir(); migrated != "" || migrateErr != nil {
+		t.Errorf("MigrateLegacyDir after moving = %q, %v, want nothing to move", migrated, migrateErr)
+	}
+}
+
+func TestMigrateLegacyDirKeepsData(t *testing.T) {
+	legacyDir, cleanup := setupLegacy(t)
+	defer cleanup()
+	Init()
+	migrated, migrateErr := MigrateLegacyDir()
+	if migrated != "" || migrateErr == nil || !strings.Contains(migrateErr.Error(), legacyDir) {
+		t.Errorf("MigrateLegacyDir with both data directories = %q, %v, want an error naming %s", migrated, migrateErr, legacyDir)
+	}
+	if !Exists(legacyDir) {
+		t.Errorf("the older data in %s was removed", legacyDir)
+	}
+
+	if setErr := SetDataDir(filepath.Join(filepath.Dir(legacyDir), "data"), false); setErr != nil {
+		t.Fatal(setErr)
+	}
+	if migrated, migrateErr := MigrateLegacyDir(); migrated != "" || migrateErr != nil {
+		t.Errorf("MigrateLegacyDir with a data directory = %q, %v, want it left alone", migrated, migrateErr)
+	}
+}
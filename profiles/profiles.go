@@ -0,0 +1,244 @@
+package profiles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/modhandler"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/transaction"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/version"
+)
+
+var (
+	// ErrProfileNotFound is returned when there is no profile with the requested name
+	ErrProfileNotFound = errors.New("profile not found")
+	// ErrProfileExists is returned when creating a profile with a name that is already used
+	ErrProfileExists = errors.New("profile already exists")
+	// ErrInvalidName is returned when the profile name cannot be used as a file name
+	ErrInvalidName = errors.New("invalid profile name")
+	// ErrModNotInProfile is returned when removing a mod that is not in the profile
+	ErrModNotInProfile = errors.New("mod not in profile")
+)
+
+var profileNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.\- ]+$`)
+
+// ProfileMod is a mod requirement of a profile
+type ProfileMod struct {
+	ModID             string `json:"mod_id"`
+	VersionConstraint string `json:"version"`
+}
+
+// Profile is a named list of mod requirements
+type Profile struct {
+	Name string       `json:"name"`
+	Mods []ProfileMod `json:"mods"`
+}
+
+func profilesDir() string {
+	return path.Join(paths.SMLauncherDir, "profiles")
+}
+
+func profilePath(name string) string {
+	return path.Join(profilesDir(), name+".json")
+}
+
+// checkName returns ErrInvalidName if the name cannot be used as a file name in the profiles dir
+func checkName(name string) error {
+	if !profileNameRegex.MatchString(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// Get reads the profile with the name
+func Get(name string) (Profile, error) {
+	if nameErr := checkName(name); nameErr != nil {
+		return Profile{}, nameErr
+	}
+	content, readErr := ioutil.ReadFile(profilePath(name))
+	if readErr != nil {
+		if os.IsNotExist(readErr) {
+			return Profile{}, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+		}
+		return Profile{}, readErr
+	}
+	var profile Profile
+	jsonErr := json.Unmarshal(content, &profile)
+	if jsonErr != nil {
+		return Profile{}, fmt.Errorf("invalid profile %s: %w", name, jsonErr)
+	}
+	return profile, nil
+}
+
+// Save writes the profile
+func Save(profile Profile) error {
+	if nameErr := checkName(profile.Name); nameErr != nil {
+		return nameErr
+	}
+	mkdirErr := os.MkdirAll(profilesDir(), os.ModePerm)
+	if mkdirErr != nil {
+		return mkdirErr
+	}
+	content, jsonErr := json.MarshalIndent(profile, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	return ioutil.WriteFile(profilePath(profile.Name), content, 0644)
+}
+
+// Create creates an empty profile
+func Create(name string) error {
+	if nameErr := checkName(name); nameErr != nil {
+		return nameErr
+	}
+	if paths.Exists(profilePath(name)) {
+		return fmt.Errorf("%w: %s", ErrProfileExists, name)
+	}
+	return Save(Profile{Name: name, Mods: []ProfileMod{}})
+}
+
+// List returns the names of all profiles
+func List() ([]string, error) {
+	files, listDirErr := ioutil.ReadDir(profilesDir())
+	if listDirErr != nil {
+		if os.IsNotExist(listDirErr) {
+			return []string{}, nil
+		}
+		return nil, listDirErr
+	}
+	names := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return names, nil
+}
+
+// AddMod adds the mod to the profile, replacing its version constraint if it is already in the profile
+func AddMod(name string, modID string, versionConstraint string) error {
+	if versionConstraint == "" {
+		versionConstraint = "*"
+	}
+	if _, constraintErr := semver.NewConstraint(versionConstraint); constraintErr != nil {
+		return constraintErr
+	}
+	profile, getErr := Get(name)
+	if getErr != nil {
+		return getErr
+	}
+	for i, mod := range profile.Mods {
+		if mod.ModID == modID {
+			profile.Mods[i].VersionConstraint = versionConstraint
+			return Save(profile)
+		}
+	}
+	profile.Mods = append(profile.Mods, ProfileMod{modID, versionConstraint})
+	return Save(profile)
+}
+
+// RemoveMod removes the mod from the profile
+func RemoveMod(name string, modID string) error {
+	profile, getErr := Get(name)
+	if getErr != nil {
+		return getErr
+	}
+	for i, mod := range profile.Mods {
+		if mod.ModID == modID {
+			profile.Mods = append(profile.Mods[:i], profile.Mods[i+1:]...)
+			return Save(profile)
+		}
+	}
+	return fmt.Errorf("%w: %s is not in profile %s", ErrModNotInProfile, modID, name)
+}
+
+func (profile Profile) getMod(modID string) (ProfileMod, bool) {
+	for _, mod := range profile.Mods {
+		if mod.ModID == modID {
+			return mod, true
+		}
+	}
+	return ProfileMod{}, false
+}
+
+// Apply uninstalls the mods that are not in the profile or required by its mods, and installs the profile mods that are not installed
+func Apply(reporter *util.Reporter, name string, smlPath string) error {
+	if nameErr := checkName(name); nameErr != nil {
+		return nameErr
+	}
+	profile, getErr := Get(name)
+	if getErr != nil {
+		return getErr
+	}
+	return transaction.Run(nil, smlPath, "apply profile "+name, func(tx *transaction.Transaction) error {
+		return apply(tx, reporter, profile, smlPath)
+	})
+}
+
+func apply(tx *transaction.Transaction, reporter *util.Reporter, profile Profile, smlPath string) error {
+	installedMods, getInstalledErr := modhandler.GetInstalledMods(smlPath)
+	if getInstalledErr != nil {
+		return getInstalledErr
+	}
+	installedByID := map[string]modhandler.DataJSON{}
+	for _, installedMod := range installedMods {
+		installedByID[installedMod.ModID] = installedMod
+	}
+	keep := map[string]bool{}
+	toVisit := []string{}
+	for _, installedMod := range installedMods {
+		if profileMod, ok := profile.getMod(installedMod.ModID); ok && version.Satisfies(installedMod.Version, profileMod.VersionConstraint) {
+			toVisit = append(toVisit, installedMod.ModID)
+		}
+	}
+	for len(toVisit) > 0 {
+		modID := toVisit[0]
+		toVisit = toVisit[1:]
+		if keep[modID] {
+			continue
+		}
+		keep[modID] = true
+		for dependencyID, dependencyConstraint := range installedByID[modID].Dependencies {
+			if dependency, ok := installedByID[dependencyID]; ok && version.Satisfies(dependency.Version, dependencyConstraint) {
+				toVisit = append(toVisit, dependencyID)
+			}
+		}
+	}
+	for _, installedMod := range installedMods {
+		if keep[installedMod.ModID] {
+			continue
+		}
+		uninstallErr := modhandler.Uninstall(tx, installedMod.ModID, installedMod.Version, smlPath)
+		if uninstallErr != nil {
+			return uninstallErr
+		}
+	}
+	for _, mod := range profile.Mods {
+		if keep[mod.ModID] {
+			continue
+		}
+		// might have been installed as a dependency of another profile mod
+		installed, installedErr := modhandler.IsModVersionWithConstraintInstalled(mod.ModID, mod.VersionConstraint, smlPath)
+		if installedErr != nil {
+			return installedErr
+		}
+		if installed {
+			continue
+		}
+		installErr := modhandler.InstallModWithDependencies(tx, reporter, mod.ModID, mod.VersionConstraint, smlPath, false)
+		if installErr != nil {
+			return installErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package profiles
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInvalidNamesAreRejected(t *testing.T) {
+	for _, name := range []string{"", "../../x", "a/b", `a\b`, "/abs"} {
+		if _, getErr := Get(name); !errors.Is(getErr, ErrInvalidName) {
+			t.Errorf("Get(%q) error = %v, want %v", name, getErr, ErrInvalidName)
+		}
+		if createErr := Create(name); !errors.Is(createErr, ErrInvalidName) {
+			t.Errorf("Create(%q) error = %v, want %v", name, createErr, ErrInvalidName)
+		}
+		if applyErr := Apply(nil, name, ""); !errors.Is(applyErr, ErrInvalidName) {
+			t.Errorf("Apply(reporter, %q) error = %v, want %v", name, applyErr, ErrInvalidName)
+		}
+	}
+}
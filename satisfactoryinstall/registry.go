@@ -0,0 +1,235 @@
+package satisfactoryinstall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+var (
+	// ErrInstallNotFound is returned when there is no registered install with the requested name
+	ErrInstallNotFound = errors.New("install not found")
+	// ErrInstallExists is returned when registering an install with a name that is already used
+	ErrInstallExists = errors.New("install already exists")
+	// ErrInvalidInstallName is returned when the install name has characters other than letters, digits, _, . and -
+	ErrInvalidInstallName = errors.New("invalid install name")
+	// ErrNotBinariesDir is returned when a path is not the binaries directory of a Satisfactory install
+	ErrNotBinariesDir = errors.New("not a Satisfactory Binaries directory")
+	// ErrNoDefaultInstall is returned when no install is given and there is no default one
+	ErrNoDefaultInstall = errors.New("no install given and no default install set")
+)
+
+var installNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// executables of the game and dedicated server found in the binaries directory
+var binariesExecutableRegex = regexp.MustCompile(`^Factory(Game|Server)(-[A-Za-z0-9]+)*-Shipping`)
+
+// NamedInstall is an install registered under a name
+type NamedInstall struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Registry is the list of named installs and the default one
+type Registry struct {
+	Default  string         `json:"default"`
+	Installs []NamedInstall `json:"installs"`
+}
+
+func registryPath() string {
+	return path.Join(paths.ConfigDir, "installs.json")
+}
+
+// ReadRegistry reads the named installs. There are none if the registry was never written
+func ReadRegistry() (Registry, error) {
+	registry := Registry{Installs: []NamedInstall{}}
+	content, readErr := ioutil.ReadFile(registryPath())
+	if readErr != nil {
+		if os.IsNotExist(readErr) {
+			return registry, nil
+		}
+		return registry, readErr
+	}
+	if jsonErr := json.Unmarshal(content, &registry); jsonErr != nil {
+		return registry, fmt.Errorf("invalid install registry: %w", jsonErr)
+	}
+	return registry, nil
+}
+
+// WriteRegistry writes the named installs, sorted by name
+func WriteRegistry(registry Registry) error {
+	sort.Slice(registry.Installs, func(i, j int) bool {
+		return registry.Installs[i].Name < registry.Installs[j].Name
+	})
+	content, jsonErr := json.MarshalIndent(registry, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	return ioutil.WriteFile(registryPath(), content, 0644)
+}
+
+// Get returns the registered install with the name
+func (registry Registry) Get(name string) (NamedInstall, error) {
+	for _, install := range registry.Installs {
+		if install.Name == name {
+			return install, nil
+		}
+	}
+	return NamedInstall{}, fmt.Errorf("%w: %s", ErrInstallNotFound, name)
+}
+
+// ValidateBinariesPath checks that the path is the directory of the game or dedicated server executable, like FactoryGame/Binaries/Win64
+func ValidateBinariesPath(binariesPath string) error {
+	files, listErr := ioutil.ReadDir(binariesPath)
+	if listErr != nil {
+		if os.IsNotExist(listErr) {
+			return fmt.Errorf("%w: %s does not exist", ErrNotBinariesDir, binariesPath)
+		}
+		return fmt.Errorf("%w: %s: %s", ErrNotBinariesDir, binariesPath, listErr.Error())
+	}
+	for _, file := range files {
+		if !file.IsDir() && binariesExecutableRegex.MatchString(file.Name()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no game or server executable in %s", ErrNotBinariesDir, binariesPath)
+}
+
+// AddInstall registers the binaries path under the name. The first install registered becomes the default one
+func AddInstall(name string, binariesPath string) error {
+	if !installNameRegex.MatchString(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidInstallName, name)
+	}
+	if validateErr := ValidateBinariesPath(binariesPath); validateErr != nil {
+		return validateErr
+	}
+	registry, readErr := ReadRegistry()
+	if readErr != nil {
+		return readErr
+	}
+	if _, getErr := registry.Get(name); getErr == nil {
+		return fmt.Errorf("%w: %s", ErrInstallExists, name)
+	}
+	absolutePath, absErr := filepath.Abs(binariesPath)
+	if absErr != nil {
+		return absErr
+	}
+	registry.Installs = append(registry.Installs, NamedInstall{name, absolutePath})
+	if registry.Default == "" {
+		registry.Default = name
+	}
+	return WriteRegistry(registry)
+}
+
+// RemoveInstall unregisters the install. The files of the install are not touched
+func RemoveInstall(name string) error {
+	registry, readErr := ReadRegistry()
+	if readErr != nil {
+		return readErr
+	}
+	if _, getErr := registry.Get(name); getErr != nil {
+		return getErr
+	}
+	installs := []NamedInstall{}
+	for _, install := range registry.Installs {
+		if install.Name != name {
+			installs = append(installs, install)
+		}
+	}
+	registry.Installs = installs
+	if registry.Default == name {
+		registry.Default = ""
+	}
+	return WriteRegistry(registry)
+}
+
+// SetDefaultInstall makes the install used when none is given
+func SetDefaultInstall(name string) error {
+	registry, readErr := ReadRegistry()
+	if readErr != nil {
+		return readErr
+	}
+	if _, getErr := registry.Get(name); getErr != nil {
+		return getErr
+	}
+	registry.Default = name
+	return WriteRegistry(registry)
+}
+
+// discoveredName names a discovered install by its branch, and adds a number if the name is taken
+func discoveredName(registry Registry, install SatisfactoryInstall) string {
+	baseName := strings.ToLower(install.Branch)
+	if install.DedicatedServer {
+		baseName = "server"
+	}
+	name := baseName
+	for i := 2; ; i++ {
+		if _, getErr := registry.Get(name); getErr != nil {
+			return name
+		}
+		name = baseName + strconv.Itoa(i)
+	}
+}
+
+// AddDiscoveredInstalls registers the discovered installs that are not registered yet, returning the new ones.
+// They are named after their branch (ea, experimental or server)
+func AddDiscoveredInstalls() ([]NamedInstall, error) {
+	registry, readErr := ReadRegistry()
+	if readErr != nil {
+		return nil, readErr
+	}
+	registered := map[string]bool{}
+	for _, install := range registry.Installs {
+		registered[filepath.Clean(install.Path)] = true
+	}
+	added := []NamedInstall{}
+	for _, install := range FindSatisfactoryInstalls() {
+		if registered[filepath.Clean(install.BinariesPath)] || ValidateBinariesPath(install.BinariesPath) != nil {
+			continue
+		}
+		namedInstall := NamedInstall{discoveredName(registry, install), install.BinariesPath}
+		registry.Installs = append(registry.Installs, namedInstall)
+		registered[filepath.Clean(install.BinariesPath)] = true
+		added = append(added, namedInstall)
+	}
+	if registry.Default == "" && len(registry.Installs) > 0 {
+		registry.Default = registry.Installs[0].Name
+	}
+	return added, WriteRegistry(registry)
+}
+
+// ResolveInstallPath returns the binaries path to use from a path or install name given by the user.
+// The path wins over the name, and the default install is used if neither is given. The path is validated
+func ResolveInstallPath(binariesPath string, name string) (string, error) {
+	if binariesPath == "" {
+		registry, readErr := ReadRegistry()
+		if readErr != nil {
+			return "", readErr
+		}
+		if name == "" {
+			name = registry.Default
+		}
+		if name == "" {
+			return "", ErrNoDefaultInstall
+		}
+		install, getErr := registry.Get(name)
+		if getErr != nil {
+			return "", getErr
+		}
+		binariesPath = install.Path
+	}
+	if validateErr := ValidateBinariesPath(binariesPath); validateErr != nil {
+		return "", validateErr
+	}
+	return binariesPath, nil
+}
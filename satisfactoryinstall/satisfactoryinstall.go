@@ -3,42 +3,390 @@ package satisfactoryinstall
 import (
 	"encoding/json"
 	"io/ioutil"
-	"path"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
 
-	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+// Branches of the game
+const (
+	BranchEarlyAccess  = "EA"
+	BranchExperimental = "Experimental"
 )
 
-// SatisfactoryInstall part of Epic Games' manifest
+// SatisfactoryInstall is a game or dedicated server install found on this machine
 type SatisfactoryInstall struct {
-	Name             string
-	Version          string
-	Path             string
-	LaunchExecutable string
+	Name    string `json:"name"`
+	Branch  string `json:"branch"`
+	Version string `json:"version"`
+	// Path is the install directory
+	Path string `json:"path"`
+	// BinariesPath is the directory of the game executable, where SML and the mods are installed
+	BinariesPath     string `json:"binaries_path"`
+	LaunchExecutable string `json:"launch_executable"`
+	// Source is the launcher or layout the install was found by
+	Source          string `json:"source"`
+	DedicatedServer bool   `json:"dedicated_server"`
+}
+
+const (
+	steamAppID                = "526870"
+	steamDedicatedServerAppID = "1690800"
+)
+
+// Epic Games and Legendary app names of the branches
+var epicBranches = map[string]string{
+	"CrabEA":   BranchEarlyAccess,
+	"CrabTest": BranchExperimental,
 }
 
-const epicGamesManifestsPath = `C:\ProgramData\Epic\EpicGamesLauncher\Data\Manifests`
+var buildVersionRegex = regexp.MustCompile(`CL-(\d+)`)
+
+func homeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
 
-// SatisfactoryVersions keep track of the SF install dirs
-var SatisfactoryVersions []SatisfactoryInstall = []SatisfactoryInstall{}
+// binariesPath returns the directory of the game executable in the install
+func binariesPath(installPath string, dedicatedServer bool) string {
+	win64Path := filepath.Join(installPath, "FactoryGame", "Binaries", "Win64")
+	if dedicatedServer {
+		linuxPath := filepath.Join(installPath, "FactoryGame", "Binaries", "Linux")
+		if _, statErr := os.Stat(linuxPath); statErr == nil {
+			return linuxPath
+		}
+	}
+	return win64Path
+}
 
-// TODO: support version names (EA/EXP) instead of full paths
-// TODO: find where the manifests are stored in other OSs
-// Actually, this ^ won't work for dedicated servers, so exact paths are still needed
+// epicVersion turns an Epic Games version string like ++FactoryGame+rel-main-0.6.1-CL-211839 into the build number
+func epicVersion(appVersion string) string {
+	match := buildVersionRegex.FindStringSubmatch(appVersion)
+	if match == nil {
+		return appVersion
+	}
+	return "build " + match[1]
+}
+
+// epicManifestDirs are the Epic Games Launcher manifest directories, including the ones in Wine and Proton prefixes
+func epicManifestDirs(steamLibraries []string) []string {
+	manifestsPath := filepath.Join("ProgramData", "Epic", "EpicGamesLauncher", "Data", "Manifests")
+	dirs := []string{}
+	if runtime.GOOS == "windows" {
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		dirs = append(dirs, filepath.Join(programData, "Epic", "EpicGamesLauncher", "Data", "Manifests"))
+	}
+	prefixes := []string{filepath.Join(homeDir(), ".wine")}
+	for _, library := range steamLibraries {
+		compatPrefixes, _ := filepath.Glob(filepath.Join(library, "steamapps", "compatdata", "*", "pfx"))
+		prefixes = append(prefixes, compatPrefixes...)
+	}
+	for _, prefix := range prefixes {
+		dirs = append(dirs, filepath.Join(prefix, "drive_c", manifestsPath))
+	}
+	return dirs
+}
+
+// prefixPath converts a Windows path from inside a Wine prefix to the path on this machine
+func prefixPath(manifestDir string, windowsPath string) string {
+	if runtime.GOOS == "windows" {
+		return windowsPath
+	}
+	windowsPath = strings.ReplaceAll(windowsPath, `\`, "/")
+	if len(windowsPath) < 2 || windowsPath[1] != ':' {
+		return windowsPath
+	}
+	drive := strings.ToLower(windowsPath[:1])
+	rest := windowsPath[2:]
+	if drive == "z" {
+		return rest
+	}
+	// the manifest dir is <prefix>/drive_c/ProgramData/Epic/EpicGamesLauncher/Data/Manifests
+	prefix := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(manifestDir))))))
+	return filepath.Join(prefix, "drive_"+drive, rest)
+}
 
-// FindSatisfactoryInstalls checks Epic Games' manifests for SF install dirs
-func FindSatisfactoryInstalls() {
-	files, err := ioutil.ReadDir(epicGamesManifestsPath)
-	util.Check(err)
+// findEpicInstalls reads the Epic Games Launcher manifests in the directory
+func findEpicInstalls(manifestDir string) []SatisfactoryInstall {
+	files, listErr := ioutil.ReadDir(manifestDir)
+	if listErr != nil {
+		return nil
+	}
+	installs := []SatisfactoryInstall{}
 	for _, manifestFile := range files {
-		if manifestFile.IsDir() {
+		if manifestFile.IsDir() || !strings.HasSuffix(manifestFile.Name(), ".item") {
+			continue
+		}
+		manifestContent, readErr := ioutil.ReadFile(filepath.Join(manifestDir, manifestFile.Name()))
+		if readErr != nil {
+			continue
+		}
+		var manifest struct {
+			AppName          string
+			CatalogNamespace string
+			DisplayName      string
+			AppVersionString string
+			InstallLocation  string
+			LaunchExecutable string
+		}
+		if json.Unmarshal(manifestContent, &manifest) != nil || manifest.CatalogNamespace != "crab" {
 			continue
 		}
-		manifestContent, err2 := ioutil.ReadFile(path.Join(epicGamesManifestsPath, manifestFile.Name()))
-		util.Check(err2)
-		var manifest map[string]interface{}
-		json.Unmarshal([]byte(manifestContent), &manifest)
-		if manifest["CatalogNamespace"] == "crab" {
-			SatisfactoryVersions = append(SatisfactoryVersions, SatisfactoryInstall{manifest["DisplayName"].(string), manifest["AppVersionString"].(string), manifest["InstallLocation"].(string), manifest["LaunchExecutable"].(string)})
+		branch, ok := epicBranches[manifest.AppName]
+		if !ok {
+			branch = BranchEarlyAccess
+		}
+		installPath := prefixPath(manifestDir, manifest.InstallLocation)
+		installs = append(installs, SatisfactoryInstall{
+			Name:             manifest.DisplayName,
+			Branch:           branch,
+			Version:          epicVersion(manifest.AppVersionString),
+			Path:             installPath,
+			BinariesPath:     binariesPath(installPath, false),
+			LaunchExecutable: manifest.LaunchExecutable,
+			Source:           "epic",
+		})
+	}
+	return installs
+}
+
+// legendaryInstalledFiles are the installed.json files of Legendary and of the copies of Legendary bundled with Heroic, relative to the home directory
+var legendaryInstalledFiles = []struct {
+	source string
+	path   string
+}{
+	{"legendary", filepath.Join(".config", "legendary", "installed.json")},
+	{"heroic", filepath.Join(".config", "heroic", "legendaryConfig", "legendary", "installed.json")},
+	{"heroic", filepath.Join(".var", "app", "com.heroicgameslauncher.hgl", "config", "heroic", "legendaryConfig", "legendary", "installed.json")},
+	{"heroic", filepath.Join("AppData", "Roaming", "heroic", "legendaryConfig", "legendary", "installed.json")},
+	{"heroic", filepath.Join("Library", "Application Support", "heroic", "legendaryConfig", "legendary", "installed.json")},
+}
+
+// findLegendaryInstalls reads a Legendary installed.json
+func findLegendaryInstalls(installedPath string, source string) []SatisfactoryInstall {
+	content, readErr := ioutil.ReadFile(installedPath)
+	if readErr != nil {
+		return nil
+	}
+	var installed map[string]struct {
+		AppName     string `json:"app_name"`
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		InstallPath string `json:"install_path"`
+		Executable  string `json:"executable"`
+	}
+	if json.Unmarshal(content, &installed) != nil {
+		return nil
+	}
+	installs := []SatisfactoryInstall{}
+	for _, game := range installed {
+		branch, ok := epicBranches[game.AppName]
+		if !ok {
+			continue
+		}
+		installs = append(installs, SatisfactoryInstall{
+			Name:             game.Title,
+			Branch:           branch,
+			Version:          epicVersion(game.Version),
+			Path:             game.InstallPath,
+			BinariesPath:     binariesPath(game.InstallPath, false),
+			LaunchExecutable: game.Executable,
+			Source:           source,
+		})
+	}
+	return installs
+}
+
+// steamRoots are the directories Steam and SteamCMD are installed to by default
+func steamRoots() []string {
+	home := homeDir()
+	switch runtime.GOOS {
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("ProgramFiles(x86)"), "Steam"),
+			filepath.Join(os.Getenv("ProgramFiles"), "Steam"),
+		}
+	case "darwin":
+		return []string{filepath.Join(home, "Library", "Application Support", "Steam")}
+	}
+	return []string{
+		filepath.Join(home, ".steam", "steam"),
+		filepath.Join(home, ".local", "share", "Steam"),
+		filepath.Join(home, ".var", "app", "com.valvesoftware.Steam", ".local", "share", "Steam"),
+		filepath.Join(home, ".steam", "steamcmd"),
+		filepath.Join(home, "Steam"),
+	}
+}
+
+// steamLibraries returns the Steam library folders listed in libraryfolders.vdf of each Steam install
+func steamLibraries() []string {
+	libraries := []string{}
+	for _, root := range steamRoots() {
+		if _, statErr := os.Stat(filepath.Join(root, "steamapps")); statErr != nil {
+			continue
+		}
+		libraries = append(libraries, root)
+		libraryFolders, readErr := readVDF(filepath.Join(root, "steamapps", "libraryfolders.vdf"))
+		if readErr != nil {
+			continue
+		}
+		folders := libraryFolders.object("libraryfolders")
+		for key := range folders {
+			// old files have "1" "D:\\SteamLibrary", new ones have "1" { "path" "D:\\SteamLibrary" ... }
+			if folder := folders.object(key); folder != nil {
+				libraries = append(libraries, folder.string("path"))
+			} else if isLibraryIndex(key) {
+				libraries = append(libraries, folders.string(key))
+			}
+		}
+	}
+	return uniquePaths(libraries)
+}
+
+func isLibraryIndex(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// findSteamInstall reads the app manifest of the game or dedicated server in the Steam library
+func findSteamInstall(library string, appID string) []SatisfactoryInstall {
+	appManifest, readErr := readVDF(filepath.Join(library, "steamapps", "appmanifest_"+appID+".acf"))
+	if readErr != nil {
+		return nil
+	}
+	appState := appManifest.object("AppState")
+	if appState == nil || appState.string("installdir") == "" {
+		return nil
+	}
+	branch := BranchEarlyAccess
+	for _, config := range []vdfObject{appState.object("UserConfig"), appState.object("MountedConfig")} {
+		if config != nil && strings.EqualFold(config.string("BetaKey"), "experimental") {
+			branch = BranchExperimental
+		}
+	}
+	dedicatedServer := appID == steamDedicatedServerAppID
+	installPath := filepath.Join(library, "steamapps", "common", appState.string("installdir"))
+	launchExecutable := "FactoryGame.exe"
+	if dedicatedServer {
+		launchExecutable = serverExecutable(installPath)
+	}
+	return []SatisfactoryInstall{{
+		Name:             appState.string("name"),
+		Branch:           branch,
+		Version:          "build " + appState.string("buildid"),
+		Path:             installPath,
+		BinariesPath:     binariesPath(installPath, dedicatedServer),
+		LaunchExecutable: launchExecutable,
+		Source:           "steam",
+		DedicatedServer:  dedicatedServer,
+	}}
+}
+
+// serverExecutable returns the dedicated server start script of the install, empty if it is not a dedicated server
+func serverExecutable(installPath string) string {
+	for _, executable := range []string{"FactoryServer.sh", "FactoryServer.exe"} {
+		if _, statErr := os.Stat(filepath.Join(installPath, executable)); statErr == nil {
+			return executable
+		}
+	}
+	return ""
+}
+
+// dedicatedServerDirs are the directories the dedicated server guides install to with SteamCMD
+func dedicatedServerDirs() []string {
+	if runtime.GOOS == "windows" {
+		return []string{
+			filepath.Join(os.Getenv("SystemDrive")+`\`, "SatisfactoryDedicatedServer"),
+			filepath.Join(homeDir(), "SatisfactoryDedicatedServer"),
+		}
+	}
+	return []string{
+		filepath.Join(homeDir(), "SatisfactoryDedicatedServer"),
+		"/home/steam/SatisfactoryDedicatedServer",
+		"/opt/SatisfactoryDedicatedServer",
+		"/opt/satisfactory",
+	}
+}
+
+// findDedicatedServer checks for a dedicated server installed outside of a Steam library
+func findDedicatedServer(installPath string) []SatisfactoryInstall {
+	executable := serverExecutable(installPath)
+	if executable == "" {
+		return nil
+	}
+	return []SatisfactoryInstall{{
+		Name:             "Satisfactory Dedicated Server",
+		Branch:           BranchEarlyAccess,
+		Path:             installPath,
+		BinariesPath:     binariesPath(installPath, true),
+		LaunchExecutable: executable,
+		Source:           "dedicated-server",
+		DedicatedServer:  true,
+	}}
+}
+
+func uniquePaths(paths []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		cleanPath := filepath.Clean(path)
+		if !seen[cleanPath] {
+			seen[cleanPath] = true
+			unique = append(unique, cleanPath)
+		}
+	}
+	return unique
+}
+
+// FindSatisfactoryInstalls looks for the game in Epic Games (also inside Wine and Proton prefixes), Legendary, Heroic and Steam,
+// and for dedicated servers in Steam libraries and the usual SteamCMD directories. Launchers that are not installed or can not be read are skipped
+func FindSatisfactoryInstalls() []SatisfactoryInstall {
+	libraries := steamLibraries()
+	installs := []SatisfactoryInstall{}
+	for _, manifestDir := range uniquePaths(epicManifestDirs(libraries)) {
+		installs = append(installs, findEpicInstalls(manifestDir)...)
+	}
+	for _, installedFile := range legendaryInstalledFiles {
+		installs = append(installs, findLegendaryInstalls(filepath.Join(homeDir(), installedFile.path), installedFile.source)...)
+	}
+	for _, library := range libraries {
+		installs = append(installs, findSteamInstall(library, steamAppID)...)
+		installs = append(installs, findSteamInstall(library, steamDedicatedServerAppID)...)
+	}
+	for _, serverDir := range dedicatedServerDirs() {
+		installs = append(installs, findDedicatedServer(serverDir)...)
+	}
+	// the same install can be found by several launchers, or through symlinked Steam roots
+	unique := []SatisfactoryInstall{}
+	seen := map[string]bool{}
+	for _, install := range installs {
+		resolvedPath, resolveErr := filepath.EvalSymlinks(install.BinariesPath)
+		if resolveErr != nil {
+			resolvedPath = filepath.Clean(install.BinariesPath)
+		}
+		if !seen[resolvedPath] {
+			seen[resolvedPath] = true
+			unique = append(unique, install)
 		}
 	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return unique[i].BinariesPath < unique[j].BinariesPath
+	})
+	return unique
 }
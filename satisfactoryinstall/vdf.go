@@ -0,0 +1,124 @@
+package satisfactoryinstall
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// vdfObject is a section of a Valve KeyValues (vdf/acf) file. The keys are lowercase, as Steam does not care about their case.
+// The values are strings or nested sections
+type vdfObject map[string]interface{}
+
+// object returns the nested section with the key, nil if there is none
+func (object vdfObject) object(key string) vdfObject {
+	nested, _ := object[strings.ToLower(key)].(vdfObject)
+	return nested
+}
+
+// string returns the string value with the key, empty if there is none
+func (object vdfObject) string(key string) string {
+	value, _ := object[strings.ToLower(key)].(string)
+	return value
+}
+
+var errInvalidVDF = errors.New("invalid vdf file")
+
+// readVDF parses a Valve KeyValues text file
+func readVDF(filePath string) (vdfObject, error) {
+	content, readErr := ioutil.ReadFile(filePath)
+	if readErr != nil {
+		return nil, readErr
+	}
+	tokens, tokenizeErr := tokenizeVDF(string(content))
+	if tokenizeErr != nil {
+		return nil, tokenizeErr
+	}
+	object, rest, parseErr := parseVDFObject(tokens)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if len(rest) != 0 {
+		return nil, errInvalidVDF
+	}
+	return object, nil
+}
+
+type vdfToken struct {
+	value    string
+	isString bool
+}
+
+func tokenizeVDF(content string) ([]vdfToken, error) {
+	tokens := []vdfToken{}
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}':
+			tokens = append(tokens, vdfToken{value: string(c)})
+		case c == '"':
+			var value strings.Builder
+			i++
+			for ; i < len(content) && content[i] != '"'; i++ {
+				if content[i] == '\\' && i+1 < len(content) {
+					i++
+					switch content[i] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(content[i])
+					}
+					continue
+				}
+				value.WriteByte(content[i])
+			}
+			if i >= len(content) {
+				return nil, errInvalidVDF
+			}
+			tokens = append(tokens, vdfToken{value: value.String(), isString: true})
+		default:
+			start := i
+			for i < len(content) && !strings.ContainsRune(" \t\r\n{}\"", rune(content[i])) {
+				i++
+			}
+			tokens = append(tokens, vdfToken{value: content[start:i], isString: true})
+			i--
+		}
+	}
+	return tokens, nil
+}
+
+// parseVDFObject parses key value pairs until the closing brace or the end of the tokens, returning the tokens after it
+func parseVDFObject(tokens []vdfToken) (vdfObject, []vdfToken, error) {
+	object := vdfObject{}
+	for len(tokens) > 0 {
+		if !tokens[0].isString && tokens[0].value == "}" {
+			return object, tokens[1:], nil
+		}
+		if !tokens[0].isString || len(tokens) < 2 {
+			return nil, nil, errInvalidVDF
+		}
+		key := strings.ToLower(tokens[0].value)
+		if tokens[1].isString {
+			object[key] = tokens[1].value
+			tokens = tokens[2:]
+			continue
+		}
+		if tokens[1].value != "{" {
+			return nil, nil, errInvalidVDF
+		}
+		nested, rest, parseErr := parseVDFObject(tokens[2:])
+		if parseErr != nil {
+			return nil, nil, parseErr
+		}
+		object[key] = nested
+		tokens = rest
+	}
+	return object, tokens, nil
+}
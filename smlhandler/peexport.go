@@ -0,0 +1,175 @@
+package smlhandler
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"regexp"
+)
+
+// index of the export table in the optional header data directories
+const exportDirectoryIndex = 0
+
+// exportDirectory is the IMAGE_EXPORT_DIRECTORY structure
+type exportDirectory struct {
+	Characteristics       uint32
+	TimeDateStamp         uint32
+	MajorVersion          uint16
+	MinorVersion          uint16
+	Name                  uint32
+	Base                  uint32
+	NumberOfFunctions     uint32
+	NumberOfNames         uint32
+	AddressOfFunctions    uint32
+	AddressOfNames        uint32
+	AddressOfNameOrdinals uint32
+}
+
+var errExportNotFound = errors.New("export not found")
+
+var exportedVersionRegex = regexp.MustCompile(`^v?\d+\.\d+\.\d+[0-9A-Za-z.+\-]*$`)
+
+// readRVA reads size bytes at the relative virtual address, or up to the end of the data stored for the section if size is 0
+func readRVA(file *pe.File, rva uint32, size uint32) ([]byte, error) {
+	for _, section := range file.Sections {
+		sectionSize := section.VirtualSize
+		if section.Size > sectionSize {
+			sectionSize = section.Size
+		}
+		if rva < section.VirtualAddress || uint64(rva) >= uint64(section.VirtualAddress)+uint64(sectionSize) {
+			continue
+		}
+		offset := rva - section.VirtualAddress
+		if size == 0 && offset < section.Size {
+			size = section.Size - offset
+		}
+		if uint64(offset)+uint64(size) > uint64(sectionSize) {
+			return nil, errors.New("address range past the end of the section")
+		}
+		data := make([]byte, size)
+		if offset >= section.Size {
+			// uninitialized data, not stored in the file
+			return data, nil
+		}
+		stored := size
+		if offset+size > section.Size {
+			stored = section.Size - offset
+		}
+		_, readErr := section.ReadAt(data[:stored], int64(offset))
+		return data, readErr
+	}
+	return nil, errors.New("address not in any section")
+}
+
+func readCString(file *pe.File, rva uint32) (string, error) {
+	data, readErr := readRVA(file, rva, 0)
+	if readErr != nil {
+		return "", readErr
+	}
+	end := bytes.IndexByte(data, 0)
+	if end == -1 {
+		return "", errors.New("unterminated string")
+	}
+	return string(data[:end]), nil
+}
+
+func exportDataDirectory(file *pe.File) (pe.DataDirectory, uint64, bool) {
+	switch header := file.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if header.NumberOfRvaAndSizes > exportDirectoryIndex {
+			return header.DataDirectory[exportDirectoryIndex], uint64(header.ImageBase), true
+		}
+	case *pe.OptionalHeader64:
+		if header.NumberOfRvaAndSizes > exportDirectoryIndex {
+			return header.DataDirectory[exportDirectoryIndex], header.ImageBase, true
+		}
+	}
+	return pe.DataDirectory{}, 0, false
+}
+
+// findExport returns the relative virtual address of the exported symbol
+func findExport(file *pe.File, name string) (uint32, error) {
+	directory, _, ok := exportDataDirectory(file)
+	if !ok || directory.VirtualAddress == 0 {
+		return 0, errExportNotFound
+	}
+	directoryData, readErr := readRVA(file, directory.VirtualAddress, uint32(binary.Size(exportDirectory{})))
+	if readErr != nil {
+		return 0, readErr
+	}
+	var exports exportDirectory
+	decodeErr := binary.Read(bytes.NewReader(directoryData), binary.LittleEndian, &exports)
+	if decodeErr != nil {
+		return 0, decodeErr
+	}
+	for i := uint32(0); i < exports.NumberOfNames; i++ {
+		nameRVAData, nameRVAErr := readRVA(file, exports.AddressOfNames+4*i, 4)
+		if nameRVAErr != nil {
+			return 0, nameRVAErr
+		}
+		exportName, nameErr := readCString(file, binary.LittleEndian.Uint32(nameRVAData))
+		if nameErr != nil {
+			return 0, nameErr
+		}
+		if exportName != name {
+			continue
+		}
+		ordinalData, ordinalErr := readRVA(file, exports.AddressOfNameOrdinals+2*i, 2)
+		if ordinalErr != nil {
+			return 0, ordinalErr
+		}
+		ordinal := uint32(binary.LittleEndian.Uint16(ordinalData))
+		if ordinal >= exports.NumberOfFunctions {
+			return 0, errors.New("invalid export ordinal")
+		}
+		functionData, functionErr := readRVA(file, exports.AddressOfFunctions+4*ordinal, 4)
+		if functionErr != nil {
+			return 0, functionErr
+		}
+		symbolRVA := binary.LittleEndian.Uint32(functionData)
+		if symbolRVA >= directory.VirtualAddress && symbolRVA < directory.VirtualAddress+directory.Size {
+			// forwarded to another dll
+			return 0, errExportNotFound
+		}
+		return symbolRVA, nil
+	}
+	return 0, errExportNotFound
+}
+
+// readExportedString reads the string exported by the dll with the name.
+// The export is either the string itself or a pointer to it
+func readExportedString(dllPath string, name string) (string, error) {
+	file, openErr := pe.Open(dllPath)
+	if openErr != nil {
+		return "", openErr
+	}
+	defer file.Close()
+	symbolRVA, findErr := findExport(file, name)
+	if findErr != nil {
+		return "", findErr
+	}
+	value, readErr := readCString(file, symbolRVA)
+	if readErr == nil && exportedVersionRegex.MatchString(value) {
+		return value, nil
+	}
+	_, imageBase, _ := exportDataDirectory(file)
+	pointerSize := uint32(4)
+	if _, is64 := file.OptionalHeader.(*pe.OptionalHeader64); is64 {
+		pointerSize = 8
+	}
+	pointerData, pointerErr := readRVA(file, symbolRVA, pointerSize)
+	if pointerErr != nil {
+		return "", pointerErr
+	}
+	var address uint64
+	if pointerSize == 8 {
+		address = binary.LittleEndian.Uint64(pointerData)
+	} else {
+		address = uint64(binary.LittleEndian.Uint32(pointerData))
+	}
+	if address < imageBase {
+		return "", errors.New("exported pointer outside of the image")
+	}
+	return readCString(file, uint32(address-imageBase))
+}
@@ -0,0 +1,191 @@
+package smlhandler
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const (
+	testImageBase      = 0x180000000
+	testSectionRVA     = 0x1000
+	testSectionOffset  = 0x200
+	testSectionSize    = 0x200
+	testExportsSize    = 0x40
+	testVersionRVA     = testSectionRVA + 0x80
+	testPointerRVA     = testSectionRVA + 0x90
+	testPointedToRVA   = testSectionRVA + 0xc0
+	testNamesFieldAt   = 32
+	testExportedString = "2.1.0"
+	testPointedString  = "v3.0.0"
+)
+
+// buildTestDLL builds a PE32+ dll with a single section. With exports, the section starts with an export directory
+// exporting smlVersion as a string and smlVersionPtr as a pointer to one. edit can change the section data before it is written
+func buildTestDLL(t *testing.T, withExports bool, edit func(section []byte)) []byte {
+	section := make([]byte, testSectionSize)
+	if withExports {
+		putUint32 := func(offset int, value uint32) {
+			binary.LittleEndian.PutUint32(section[offset:], value)
+		}
+		directory := exportDirectory{
+			Name:                  testSectionRVA + 0x70,
+			Base:                  1,
+			NumberOfFunctions:     2,
+			NumberOfNames:         2,
+			AddressOfFunctions:    testSectionRVA + 0x40,
+			AddressOfNames:        testSectionRVA + 0x50,
+			AddressOfNameOrdinals: testSectionRVA + 0x60,
+		}
+		var directoryData bytes.Buffer
+		binary.Write(&directoryData, binary.LittleEndian, directory)
+		copy(section, directoryData.Bytes())
+		putUint32(0x40, testVersionRVA)
+		putUint32(0x44, testPointerRVA)
+		putUint32(0x50, testSectionRVA+0xa0)
+		putUint32(0x54, testSectionRVA+0xb0)
+		binary.LittleEndian.PutUint16(section[0x60:], 0)
+		binary.LittleEndian.PutUint16(section[0x62:], 1)
+		copy(section[0x70:], "test.dll\x00")
+		copy(section[0x80:], testExportedString+"\x00")
+		binary.LittleEndian.PutUint64(section[0x90:], testImageBase+testPointedToRVA)
+		copy(section[0xa0:], "smlVersion\x00")
+		copy(section[0xb0:], "smlVersionPtr\x00")
+		copy(section[0xc0:], testPointedString+"\x00")
+	}
+	if edit != nil {
+		edit(section)
+	}
+
+	optionalHeader := pe.OptionalHeader64{
+		Magic:               0x20b,
+		ImageBase:           testImageBase,
+		SectionAlignment:    0x1000,
+		FileAlignment:       0x200,
+		SizeOfImage:         0x2000,
+		SizeOfHeaders:       testSectionOffset,
+		NumberOfRvaAndSizes: 16,
+	}
+	if withExports {
+		optionalHeader.DataDirectory[exportDirectoryIndex] = pe.DataDirectory{VirtualAddress: testSectionRVA, Size: testExportsSize}
+	}
+	fileHeader := pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: uint16(binary.Size(optionalHeader)),
+		Characteristics:      pe.IMAGE_FILE_DLL | pe.IMAGE_FILE_EXECUTABLE_IMAGE,
+	}
+	sectionHeader := pe.SectionHeader32{
+		VirtualSize:      testSectionSize,
+		VirtualAddress:   testSectionRVA,
+		SizeOfRawData:    testSectionSize,
+		PointerToRawData: testSectionOffset,
+		Characteristics:  pe.IMAGE_SCN_CNT_INITIALIZED_DATA | pe.IMAGE_SCN_MEM_READ,
+	}
+	copy(sectionHeader.Name[:], ".rdata")
+
+	var dll bytes.Buffer
+	dosHeader := make([]byte, 0x40)
+	copy(dosHeader, "MZ")
+	binary.LittleEndian.PutUint32(dosHeader[0x3c:], 0x40)
+	dll.Write(dosHeader)
+	dll.WriteString("PE\x00\x00")
+	for _, header := range []interface{}{fileHeader, optionalHeader, sectionHeader} {
+		if writeErr := binary.Write(&dll, binary.LittleEndian, header); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	dll.Write(make([]byte, testSectionOffset-dll.Len()))
+	dll.Write(section)
+	return dll.Bytes()
+}
+
+// writeTestDLL writes the dll to a temporary file, returning its path
+func writeTestDLL(t *testing.T, dir string, name string, dll []byte) string {
+	dllPath := path.Join(dir, name)
+	if writeErr := ioutil.WriteFile(dllPath, dll, 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	return dllPath
+}
+
+func testDir(t *testing.T) string {
+	dir, tempErr := ioutil.TempDir("", "peexport")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	return dir
+}
+
+func TestReadExportedString(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	dllPath := writeTestDLL(t, dir, "exports.dll", buildTestDLL(t, true, nil))
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"smlVersion", testExportedString},
+		{"smlVersionPtr", testPointedString},
+	}
+	for _, test := range tests {
+		got, readErr := readExportedString(dllPath, test.name)
+		if readErr != nil {
+			t.Errorf("readExportedString(%q) error = %v", test.name, readErr)
+		} else if got != test.want {
+			t.Errorf("readExportedString(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+	if _, readErr := readExportedString(dllPath, "missing"); !errors.Is(readErr, errExportNotFound) {
+		t.Errorf("readExportedString(%q) error = %v, want %v", "missing", readErr, errExportNotFound)
+	}
+}
+
+func TestReadExportedStringErrors(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	setUint32 := func(offset int, value uint32) func(section []byte) {
+		return func(section []byte) {
+			binary.LittleEndian.PutUint32(section[offset:], value)
+		}
+	}
+	tests := []struct {
+		name string
+		dll  []byte
+	}{
+		{"no export directory", buildTestDLL(t, false, nil)},
+		{"names outside every section", buildTestDLL(t, true, setUint32(testNamesFieldAt, 0x9000))},
+		{"names past the end of the section", buildTestDLL(t, true, setUint32(testNamesFieldAt, testSectionRVA+testSectionSize-2))},
+		{"name outside every section", buildTestDLL(t, true, setUint32(0x50, 0x9000))},
+		{"symbol outside every section", buildTestDLL(t, true, setUint32(0x40, 0xffffff00))},
+		{"pointer outside the image", buildTestDLL(t, true, func(section []byte) {
+			binary.LittleEndian.PutUint32(section[0x40:], testPointerRVA)
+			binary.LittleEndian.PutUint64(section[0x90:], 0x10)
+		})},
+		{"empty file", []byte{}},
+		{"not a PE file", []byte("not a dll at all")},
+	}
+	for i, test := range tests {
+		dllPath := writeTestDLL(t, dir, "test.dll", test.dll)
+		if _, readErr := readExportedString(dllPath, "smlVersion"); readErr == nil {
+			t.Errorf("case %d, %s: readExportedString succeeded, want an error", i, test.name)
+		}
+	}
+}
+
+func TestReadExportedStringTruncated(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+	dll := buildTestDLL(t, true, nil)
+	for size := 0; size < len(dll); size += 8 {
+		dllPath := writeTestDLL(t, dir, "truncated.dll", dll[:size])
+		if _, readErr := readExportedString(dllPath, "smlVersion"); readErr == nil {
+			t.Errorf("readExportedString of the dll truncated to %d bytes succeeded, want an error", size)
+		}
+	}
+}
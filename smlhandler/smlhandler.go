@@ -1,7 +1,6 @@
 package smlhandler
 
 import (
-	"C"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,25 +10,33 @@ import (
 	"path"
 	"regexp"
 	"sort"
-	"strings"
-	"syscall"
 	"time"
-	"unsafe"
-
-	"github.com/Masterminds/semver"
 
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/config"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/transaction"
 	"github.com/mircearoata/SatisfactoryModLauncherCLI/util"
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/version"
 )
 
-var (
-	modkernel32        = syscall.NewLazyDLL("kernel32.dll")
-	procLoadLibraryExA = modkernel32.NewProc("LoadLibraryExA")
-	procFreeLibrary    = modkernel32.NewProc("FreeLibrary")
-	procGetProcAddress = modkernel32.NewProc("GetProcAddress")
-)
+// DefaultReleasesURL is the GitHub releases API of SML used when no other one is configured
+const DefaultReleasesURL = "https://api.github.com/repos/satisfactorymodding/SatisfactoryModLoader/releases"
+
+var smlGitHubReleasesAPIurl = DefaultReleasesURL
 
-const smlGitHubReleasesAPIurl = "https://api.github.com/repos/satisfactorymodding/SatisfactoryModLoader/releases"
+func init() {
+	config.Register(config.Setting{Name: "sml-releases-url", Env: "SMLAUNCHER_SML_RELEASES_URL", Default: DefaultReleasesURL, Description: "GitHub releases API to download SML from"})
+}
+
+// SetReleasesURL changes the GitHub releases API (or a mirror of it) SML is downloaded from
+func SetReleasesURL(releasesURL string) {
+	smlGitHubReleasesAPIurl = releasesURL
+}
+
+// ReleasesURL returns the GitHub releases API SML is downloaded from
+func ReleasesURL() string {
+	return smlGitHubReleasesAPIurl
+}
 
 var oldVersionsChecksum map[string]string = map[string]string{
 	"v1.0.0-pr1": "af8f291c9f9534fb0972e976d9e87807126ec7976fd1eb32af9438e34cb0316d",
@@ -44,32 +51,43 @@ var oldVersionsChecksum map[string]string = map[string]string{
 	// SML version is exported since SML 1.0.2
 }
 
+var (
+	// ErrSMLNotInstalled is returned when there is no SML dll at the path
+	ErrSMLNotInstalled = errors.New("SML is not installed at this path")
+	// ErrVersionNotFound is returned when there is no SML release with the requested version
+	ErrVersionNotFound = errors.New("SML version does not exist")
+	// ErrNewerInstalled is returned when the installed SML is newer than the target version
+	ErrNewerInstalled = errors.New("SML installed version newer than target")
+	// ErrUpToDate is returned when the installed SML is already the latest version
+	ErrUpToDate = errors.New("SML already up to date")
+	// ErrNoReleases is returned when no SML release could be found on GitHub
+	ErrNoReleases = errors.New("no SML release found")
+)
+
 // GetInstalledVersion gets the version of the SML dll (0.0.0 = unknown)
-func GetInstalledVersion(satisfactoryPath string) string {
+func GetInstalledVersion(satisfactoryPath string) (string, error) {
 	dllPath := path.Join(satisfactoryPath, "xinput1_3.dll")
 	if !paths.Exists(dllPath) {
-		return "Not Installed"
-	}
-	dllPathNullTerminated := append([]byte(dllPath), 0)
-	dll, _, loadErr := procLoadLibraryExA.Call(uintptr(unsafe.Pointer(&dllPathNullTerminated[0])), uintptr(unsafe.Pointer(nil)), 1)
-	if loadErr != syscall.Errno(0x0) {
-		util.Check(loadErr)
-	}
-	defer procFreeLibrary.Call(dll)
-	smlVersionString := "smlVersion"
-	smlVersionStringNullTerminated := append([]byte(smlVersionString), 0)
-	smlVersion, _, getProcErr := procGetProcAddress.Call(dll, uintptr(unsafe.Pointer(&smlVersionStringNullTerminated[0])))
-	if getProcErr != syscall.Errno(0x0) { // happens when using an old version of SML which doesn't export the version, fallback to hashes
-		fileHash := util.Sha256File(dllPath)
-		for k, v := range oldVersionsChecksum {
-			if v == fileHash {
-				return k
-			}
+		return "Not Installed", nil
+	}
+	smlVersion, exportErr := readExportedString(dllPath, "smlVersion")
+	if exportErr == nil {
+		return smlVersion, nil
+	}
+	if exportErr != errExportNotFound {
+		return "", fmt.Errorf("reading SML version from %s: %w", dllPath, exportErr)
+	}
+	// old versions of SML don't export the version, fallback to hashes
+	fileHash, hashErr := util.Sha256File(dllPath)
+	if hashErr != nil {
+		return "", hashErr
+	}
+	for k, v := range oldVersionsChecksum {
+		if v == fileHash {
+			return k, nil
 		}
-		return "UNKNOWN"
 	}
-	smlVersionFinal := C.GoString((*C.char)(unsafe.Pointer(smlVersion)))
-	return smlVersionFinal
+	return "UNKNOWN", nil
 }
 
 // SMLAsset part of GitHub asset structure
@@ -87,18 +105,28 @@ type SMLRelease struct {
 	DownloadURL     string
 }
 
-// GetSMLReleases finds the versions of SML available to download from GitHub
-func GetSMLReleases() []SMLRelease {
+// GetSMLReleases finds the versions of SML available to download from GitHub, oldest first
+func GetSMLReleases() ([]SMLRelease, error) {
 	response, httpErr := http.Get(smlGitHubReleasesAPIurl)
-	util.Check(httpErr)
-	body, _ := ioutil.ReadAll(response.Body)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("GitHub releases request failed: " + response.Status)
+	}
+	body, readErr := ioutil.ReadAll(response.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
 	var releases []SMLRelease
-	json.Unmarshal([]byte(string(body)), &releases)
+	jsonErr := json.Unmarshal(body, &releases)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
 	installInstructionsRegex, _ := regexp.Compile(`#\s*Installation(.+\s)*\n`)
 	for i := 0; i < len(releases); i++ {
-		if strings.HasPrefix(releases[i].Version, "v") {
-			releases[i].Version = releases[i].Version[1:]
-		}
+		releases[i].Version = version.Normalize(releases[i].Version)
 		releases[i].Description = installInstructionsRegex.ReplaceAllString(releases[i].Description, "")
 		for _, asset := range releases[i].Assets {
 			if asset.Name == "xinput1_3.dll" {
@@ -107,82 +135,146 @@ func GetSMLReleases() []SMLRelease {
 			}
 		}
 	}
-	sort.Slice(releases[:], func(i, j int) bool {
+	// oldest version first, a hotfix of an older version published later does not become the latest
+	sort.SliceStable(releases, func(i, j int) bool {
+		if cmp := version.Compare(releases[i].Version, releases[j].Version); cmp != 0 {
+			return cmp < 0
+		}
 		return releases[i].ReleaseDateTime.Before(releases[j].ReleaseDateTime)
 	})
-	return releases
+	return releases, nil
 }
 
 // GetLatestSML finds the latest version of SML available to download from GitHub
-func GetLatestSML() SMLRelease {
-	releases := GetSMLReleases()
-	return releases[len(releases)-1]
+func GetLatestSML() (SMLRelease, error) {
+	releases, getReleasesErr := GetSMLReleases()
+	if getReleasesErr != nil {
+		return SMLRelease{}, getReleasesErr
+	}
+	if len(releases) == 0 {
+		return SMLRelease{}, ErrNoReleases
+	}
+	return releases[len(releases)-1], nil
 }
 
-func shouldInstall(satisfactoryPath string, version string) bool {
-	installed, semverErr1 := semver.NewVersion(GetInstalledVersion(satisfactoryPath))
+func shouldInstall(satisfactoryPath string, smlVersion string) (bool, error) {
+	installedVersion, getInstalledErr := GetInstalledVersion(satisfactoryPath)
+	if getInstalledErr != nil {
+		return false, getInstalledErr
+	}
+	installed, semverErr1 := version.Parse(installedVersion)
 	if semverErr1 != nil {
-		return true // invalid semver => not installed
+		return true, nil // invalid semver => not installed
 	}
-	new, semverErr2 := semver.NewVersion(version)
+	new, semverErr2 := version.Parse(smlVersion)
 	if semverErr2 != nil {
-		return false // invalid semver
+		return false, nil // invalid semver
 	}
-	return installed.Compare(new) == -1
+	return installed.Compare(new) == -1, nil
 }
 
 // InstallSML checks the versions of SML and installs if the specified version is newer than the installed version
-func InstallSML(satisfactoryPath string, version string) error {
-	if shouldInstall(satisfactoryPath, version) {
-		releases := GetSMLReleases()
-		for _, release := range releases {
-			if release.Version == version {
-				return util.DownloadFile(path.Join(satisfactoryPath, "xinput1_3.dll"), release.DownloadURL)
-			}
+func InstallSML(reporter *util.Reporter, satisfactoryPath string, version string) error {
+	install, shouldInstallErr := shouldInstall(satisfactoryPath, version)
+	if shouldInstallErr != nil {
+		return shouldInstallErr
+	}
+	if !install {
+		return ErrNewerInstalled
+	}
+	releases, getReleasesErr := GetSMLReleases()
+	if getReleasesErr != nil {
+		return getReleasesErr
+	}
+	for _, release := range releases {
+		if release.Version == version {
+			return installDLL(reporter, satisfactoryPath, release)
 		}
-		return errors.New("SML version " + version + " does not exist")
 	}
-	return errors.New("SML installed version newer than target")
+	return fmt.Errorf("%w: %s", ErrVersionNotFound, version)
 }
 
-// UpdateSML finds the latest version of SML available to download from GitHub and updates to it if newer
-func UpdateSML(satisfactoryPath string) error {
-	version := GetLatestSML().Version
-	if shouldInstall(satisfactoryPath, version) {
-		releases := GetSMLReleases()
-		for _, release := range releases {
-			if release.Version == version {
-				UninstallSML(satisfactoryPath)
-				return util.DownloadFile(path.Join(satisfactoryPath, "xinput1_3.dll"), release.DownloadURL)
+// installDLL downloads the release next to the SML dll and only then replaces it, so a failed download leaves the installed SML unchanged
+func installDLL(reporter *util.Reporter, satisfactoryPath string, release SMLRelease) error {
+	dllPath := path.Join(satisfactoryPath, "xinput1_3.dll")
+	reporter.Progress("Downloading SML@" + release.Version)
+	downloadErr := util.DownloadFile(reporter, dllPath+".tmp", release.DownloadURL)
+	if downloadErr != nil {
+		os.Remove(dllPath + ".tmp")
+		return downloadErr
+	}
+	return transaction.Run(nil, satisfactoryPath, "install SML@"+release.Version, func(tx *transaction.Transaction) error {
+		if trackErr := tx.Track(dllPath); trackErr != nil {
+			return trackErr
+		}
+		if paths.Exists(dllPath) {
+			removeErr := os.Remove(dllPath)
+			if removeErr != nil {
+				return removeErr
 			}
 		}
-		return errors.New("SML version " + version + " does not exist")
+		return os.Rename(dllPath+".tmp", dllPath)
+	})
+}
+
+// UpdateSML finds the latest version of SML available to download from GitHub and updates to it if newer
+func UpdateSML(reporter *util.Reporter, satisfactoryPath string) error {
+	latest, getLatestErr := GetLatestSML()
+	if getLatestErr != nil {
+		return getLatestErr
 	}
-	return errors.New("SML already up to date")
+	install, shouldInstallErr := shouldInstall(satisfactoryPath, latest.Version)
+	if shouldInstallErr != nil {
+		return shouldInstallErr
+	}
+	if !install {
+		return ErrUpToDate
+	}
+	return installDLL(reporter, satisfactoryPath, latest)
 }
 
 // UninstallSML removes the SML dll from the path
 func UninstallSML(satisfactoryPath string) error {
 	dllPath := path.Join(satisfactoryPath, "xinput1_3.dll")
 	if !paths.Exists(dllPath) {
-		return errors.New("SML is not installed at this path")
-	}
-	err := os.Remove(dllPath)
-	return err
-}
-
-// CheckForUpdates compares the installed version with the newest available and optionally downloads it
-func CheckForUpdates(satisfactoryPath string, install bool) bool {
-	latestVersion := GetLatestSML().Version
-	hasUpdate := shouldInstall(satisfactoryPath, latestVersion)
-	if hasUpdate {
-		if install {
-			UpdateSML(satisfactoryPath)
-			fmt.Println("Updated SML to " + latestVersion)
-		} else {
-			fmt.Println("SML@" + latestVersion + " available")
+		return ErrSMLNotInstalled
+	}
+	return transaction.Run(nil, satisfactoryPath, "uninstall SML", func(tx *transaction.Transaction) error {
+		if trackErr := tx.Track(dllPath); trackErr != nil {
+			return trackErr
+		}
+		return os.Remove(dllPath)
+	})
+}
+
+// Update is a newer SML release than the installed one
+type Update struct {
+	CurrentVersion string `json:"current_version"`
+	LatestVersion  string `json:"latest_version"`
+	Updated        bool   `json:"updated"`
+}
+
+// CheckForUpdates returns the SML update for the Satisfactory install, nil if it is up to date. The update is installed if install is set
+func CheckForUpdates(reporter *util.Reporter, satisfactoryPath string, install bool) (*Update, error) {
+	latest, getLatestErr := GetLatestSML()
+	if getLatestErr != nil {
+		return nil, getLatestErr
+	}
+	hasUpdate, shouldInstallErr := shouldInstall(satisfactoryPath, latest.Version)
+	if shouldInstallErr != nil {
+		return nil, shouldInstallErr
+	}
+	if !hasUpdate {
+		return nil, nil
+	}
+	currentVersion, _ := GetInstalledVersion(satisfactoryPath)
+	update := &Update{CurrentVersion: currentVersion, LatestVersion: latest.Version}
+	if install {
+		updateErr := UpdateSML(reporter, satisfactoryPath)
+		if updateErr != nil {
+			return update, updateErr
 		}
-		return true
+		update.Updated = true
 	}
-	return false
+	return update, nil
 }
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package transaction
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile opens the file and takes an exclusive lock on it, which the OS releases when the process exits.
+// Returns errLocked if another process, or another open of the file, holds the lock
+func lockFile(filePath string) (*os.File, error) {
+	file, openErr := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
+	if openErr != nil {
+		return nil, openErr
+	}
+	if lockErr := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); lockErr != nil {
+		file.Close()
+		if lockErr == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, lockErr
+	}
+	return file, nil
+}
@@ -0,0 +1,26 @@
+package transaction
+
+import (
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is returned when opening a file another handle opened without sharing it
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile opens the file without sharing it, so no other handle can open it until the process closes it or exits.
+// Returns errLocked if another process, or another open of the file, holds it
+func lockFile(filePath string) (*os.File, error) {
+	name, nameErr := syscall.UTF16PtrFromString(filePath)
+	if nameErr != nil {
+		return nil, nameErr
+	}
+	handle, openErr := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if openErr != nil {
+		if openErr == errorSharingViolation {
+			return nil, errLocked
+		}
+		return nil, openErr
+	}
+	return os.NewFile(uintptr(handle), filePath), nil
+}
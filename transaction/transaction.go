@@ -0,0 +1,271 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+const (
+	stateApplying  = "applying"
+	stateCommitted = "committed"
+)
+
+const (
+	journalFileName = "journal.json"
+	lockFileSuffix  = ".lock"
+)
+
+// errLocked is returned when the lock file is held by another transaction, so its process is still running
+var errLocked = errors.New("locked")
+
+// trackedFile is a file changed by a transaction
+type trackedFile struct {
+	Path string `json:"path"`
+	// Backup is the copy of the file from before the change, empty if the file did not exist
+	Backup string `json:"backup,omitempty"`
+	// CreatedDir is the directory of the file if it did not exist, so it is removed again if it is left empty
+	CreatedDir string `json:"created_dir,omitempty"`
+}
+
+// journal is stored next to the backups of a transaction so an interrupted run can be recovered
+type journal struct {
+	Path      string        `json:"path"`
+	Operation string        `json:"operation"`
+	State     string        `json:"state"`
+	StartedAt time.Time     `json:"started_at"`
+	Files     []trackedFile `json:"files"`
+}
+
+// Transaction is a set of changes to the files of a directory, like a Satisfactory install, that can be rolled back.
+// Only the files tracked by the transaction are rolled back, other changes to the directory are kept
+type Transaction struct {
+	dir     string
+	lock    *os.File
+	journal journal
+}
+
+// Recovered is a transaction that was interrupted, and whether it was rolled back or finished
+type Recovered struct {
+	Operation  string
+	Path       string
+	RolledBack bool
+}
+
+func transactionsDir() string {
+	return path.Join(paths.SMLauncherDir, "transactions")
+}
+
+func (t *Transaction) writeJournal() error {
+	content, jsonErr := json.MarshalIndent(t.journal, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	// the journal is replaced rather than written in place, so an interrupted write leaves the previous one
+	journalPath := path.Join(t.dir, journalFileName)
+	if writeErr := ioutil.WriteFile(journalPath+".tmp", content, 0644); writeErr != nil {
+		os.Remove(journalPath + ".tmp")
+		return writeErr
+	}
+	return os.Rename(journalPath+".tmp", journalPath)
+}
+
+// linkOrCopy hard links the file, falling back to copying it.
+// The launcher only ever replaces the files it changes, never writes them in place, so a hard link is a safe backup
+func linkOrCopy(src string, dst string) error {
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	return paths.CopyFile(src, dst)
+}
+
+// unlock releases the lock of the transaction and removes its lock file
+func (t *Transaction) unlock() {
+	if t.lock == nil {
+		return
+	}
+	t.lock.Close()
+	t.lock = nil
+	os.Remove(t.dir + lockFileSuffix)
+}
+
+// Begin starts a transaction of the changes to the files in the path. The lock file of the transaction is held
+// until it is committed or rolled back, so it is only recovered once the process running it is gone
+func Begin(changedPath string, operation string) (*Transaction, error) {
+	if mkdirErr := os.MkdirAll(transactionsDir(), os.ModePerm); mkdirErr != nil {
+		return nil, mkdirErr
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.Itoa(os.Getpid())
+	t := &Transaction{
+		dir: path.Join(transactionsDir(), id),
+		journal: journal{
+			Path:      changedPath,
+			Operation: operation,
+			State:     stateApplying,
+			StartedAt: time.Now(),
+			Files:     []trackedFile{},
+		},
+	}
+	// the lock is taken before the transaction dir exists, so recovery never finds the dir unlocked while it is running
+	lock, lockErr := lockFile(t.dir + lockFileSuffix)
+	if lockErr != nil {
+		return nil, lockErr
+	}
+	t.lock = lock
+	if mkdirErr := os.MkdirAll(t.dir, os.ModePerm); mkdirErr != nil {
+		t.unlock()
+		return nil, mkdirErr
+	}
+	if journalErr := t.writeJournal(); journalErr != nil {
+		os.RemoveAll(t.dir)
+		t.unlock()
+		return nil, journalErr
+	}
+	return t, nil
+}
+
+// Track backs up the file before it is created, replaced or removed, so rolling back restores it.
+// Tracking a file more than once keeps the first backup
+func (t *Transaction) Track(filePath string) error {
+	for _, tracked := range t.journal.Files {
+		if tracked.Path == filePath {
+			return nil
+		}
+	}
+	tracked := trackedFile{Path: filePath}
+	if !paths.Exists(path.Dir(filePath)) {
+		tracked.CreatedDir = path.Dir(filePath)
+	}
+	if paths.Exists(filePath) {
+		tracked.Backup = path.Join(t.dir, strconv.Itoa(len(t.journal.Files)))
+		if backupErr := linkOrCopy(filePath, tracked.Backup); backupErr != nil {
+			os.Remove(tracked.Backup)
+			return backupErr
+		}
+	}
+	t.journal.Files = append(t.journal.Files, tracked)
+	// the journal is written before the change, so an interrupted change is always rolled back
+	return t.writeJournal()
+}
+
+// Commit marks the transaction as finished and removes the backups
+func (t *Transaction) Commit() error {
+	t.journal.State = stateCommitted
+	journalErr := t.writeJournal()
+	if journalErr != nil {
+		return journalErr
+	}
+	removeErr := os.RemoveAll(t.dir)
+	t.unlock()
+	return removeErr
+}
+
+// Rollback restores the tracked files to the state they were in before the transaction changed them
+func (t *Transaction) Rollback() error {
+	for i := len(t.journal.Files) - 1; i >= 0; i-- {
+		tracked := t.journal.Files[i]
+		if removeErr := os.Remove(tracked.Path); removeErr != nil && !os.IsNotExist(removeErr) {
+			return removeErr
+		}
+		if tracked.Backup != "" {
+			if mkdirErr := os.MkdirAll(path.Dir(tracked.Path), os.ModePerm); mkdirErr != nil {
+				return mkdirErr
+			}
+			if restoreErr := linkOrCopy(tracked.Backup, tracked.Path); restoreErr != nil {
+				return restoreErr
+			}
+		}
+	}
+	for _, tracked := range t.journal.Files {
+		if tracked.CreatedDir != "" {
+			if empty, _ := paths.IsEmpty(tracked.CreatedDir); empty {
+				os.Remove(tracked.CreatedDir)
+			}
+		}
+	}
+	removeErr := os.RemoveAll(t.dir)
+	t.unlock()
+	return removeErr
+}
+
+// Run runs the changes to the files in the path in a transaction, rolling them back if they fail.
+// If tx is a transaction of the same path, the changes join it and are rolled back with it; otherwise a new transaction is begun.
+// The changes get the transaction they run in, to track the files they change and to pass to the runs nested in them
+func Run(tx *Transaction, changedPath string, operation string, changes func(tx *Transaction) error) error {
+	if tx != nil && filepath.Clean(tx.journal.Path) == filepath.Clean(changedPath) {
+		return changes(tx)
+	}
+	t, beginErr := Begin(changedPath, operation)
+	if beginErr != nil {
+		return beginErr
+	}
+	changesErr := changes(t)
+	if changesErr != nil {
+		rollbackErr := t.Rollback()
+		if rollbackErr != nil {
+			t.unlock()
+			return fmt.Errorf("%v (rollback failed, backup kept in %s: %v)", changesErr, t.dir, rollbackErr)
+		}
+		return changesErr
+	}
+	return t.Commit()
+}
+
+// Recover rolls back the transactions that were interrupted while applying their changes,
+// and finishes cleaning up the ones that were interrupted after they were committed.
+// Transactions whose lock is still held by a running process are left alone
+func Recover() ([]Recovered, error) {
+	dirs, listDirErr := ioutil.ReadDir(transactionsDir())
+	if listDirErr != nil {
+		if os.IsNotExist(listDirErr) {
+			return []Recovered{}, nil
+		}
+		return nil, listDirErr
+	}
+	recovered := []Recovered{}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		t := &Transaction{dir: path.Join(transactionsDir(), dir.Name())}
+		lock, lockErr := lockFile(t.dir + lockFileSuffix)
+		if errors.Is(lockErr, errLocked) {
+			continue
+		}
+		if lockErr != nil {
+			return recovered, lockErr
+		}
+		t.lock = lock
+		content, readErr := ioutil.ReadFile(path.Join(t.dir, journalFileName))
+		if readErr != nil {
+			// the journal is written before anything is changed, so nothing was changed yet
+			os.RemoveAll(t.dir)
+			t.unlock()
+			continue
+		}
+		if jsonErr := json.Unmarshal(content, &t.journal); jsonErr != nil {
+			t.unlock()
+			return recovered, fmt.Errorf("invalid transaction journal in %s: %w", t.dir, jsonErr)
+		}
+		if t.journal.State == stateCommitted {
+			os.RemoveAll(t.dir)
+			t.unlock()
+			recovered = append(recovered, Recovered{t.journal.Operation, t.journal.Path, false})
+			continue
+		}
+		if rollbackErr := t.Rollback(); rollbackErr != nil {
+			t.unlock()
+			return recovered, rollbackErr
+		}
+		recovered = append(recovered, Recovered{t.journal.Operation, t.journal.Path, true})
+	}
+	return recovered, nil
+}
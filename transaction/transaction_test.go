@@ -0,0 +1,233 @@
+package transaction
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mircearoata/SatisfactoryModLauncherCLI/paths"
+)
+
+var errTestChanges = errors.New("changes failed")
+
+// setup uses a new data dir and returns a directory with mods/a.zip in it. The returned function removes them
+func setup(t *testing.T) (string, func()) {
+	dir, tempErr := ioutil.TempDir("", "transaction")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	if setErr := paths.SetDataDir(path.Join(dir, "data"), false); setErr != nil {
+		os.RemoveAll(dir)
+		t.Fatal(setErr)
+	}
+	changedPath := path.Join(dir, "install")
+	if mkdirErr := os.MkdirAll(path.Join(changedPath, "mods"), 0755); mkdirErr != nil {
+		os.RemoveAll(dir)
+		t.Fatal(mkdirErr)
+	}
+	writeFile(t, path.Join(changedPath, "mods", "a.zip"), "a")
+	return changedPath, func() { os.RemoveAll(dir) }
+}
+
+func writeFile(t *testing.T, filePath string, content string) {
+	if writeErr := ioutil.WriteFile(filePath, []byte(content), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+}
+
+// fileContent returns the content of the file, or "missing" if it does not exist
+func fileContent(t *testing.T, filePath string) string {
+	content, readErr := ioutil.ReadFile(filePath)
+	if os.IsNotExist(readErr) {
+		return "missing"
+	}
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return string(content)
+}
+
+// replaceFile tracks the file and replaces it with the content, or removes it if the content is empty
+func replaceFile(t *testing.T, tx *Transaction, filePath string, content string) {
+	if trackErr := tx.Track(filePath); trackErr != nil {
+		t.Fatal(trackErr)
+	}
+	if content == "" {
+		if removeErr := os.Remove(filePath); removeErr != nil {
+			t.Fatal(removeErr)
+		}
+		return
+	}
+	if mkdirErr := os.MkdirAll(path.Dir(filePath), 0755); mkdirErr != nil {
+		t.Fatal(mkdirErr)
+	}
+	writeFile(t, filePath+".tmp", content)
+	if renameErr := os.Rename(filePath+".tmp", filePath); renameErr != nil {
+		t.Fatal(renameErr)
+	}
+}
+
+func checkFinished(t *testing.T) {
+	entries, listErr := ioutil.ReadDir(transactionsDir())
+	if listErr != nil {
+		t.Fatal(listErr)
+	}
+	for _, entry := range entries {
+		t.Errorf("%s left in the transactions dir", entry.Name())
+	}
+}
+
+func TestRunRollsBackFailingChanges(t *testing.T) {
+	changedPath, cleanup := setup(t)
+	defer cleanup()
+	modsDir := path.Join(changedPath, "mods")
+	runErr := Run(nil, changedPath, "install", func(tx *Transaction) error {
+		replaceFile(t, tx, path.Join(modsDir, "a.zip"), "")
+		replaceFile(t, tx, path.Join(modsDir, "b.zip"), "b")
+		replaceFile(t, tx, path.Join(changedPath, "new", "c.zip"), "c")
+		// written by another tool, so it is not tracked
+		writeFile(t, path.Join(modsDir, "other.zip"), "other")
+		return errTestChanges
+	})
+	if !errors.Is(runErr, errTestChanges) {
+		t.Fatalf("Run error = %v, want %v", runErr, errTestChanges)
+	}
+	files := map[string]string{
+		path.Join(modsDir, "a.zip"):     "a",
+		path.Join(modsDir, "b.zip"):     "missing",
+		path.Join(changedPath, "new"):   "missing",
+		path.Join(modsDir, "other.zip"): "other",
+	}
+	for filePath, want := range files {
+		if want == "missing" {
+			if paths.Exists(filePath) {
+				t.Errorf("%s exists after the rollback", filePath)
+			}
+		} else if got := fileContent(t, filePath); got != want {
+			t.Errorf("%s = %q after the rollback, want %q", filePath, got, want)
+		}
+	}
+	checkFinished(t)
+}
+
+func TestRunCommits(t *testing.T) {
+	changedPath, cleanup := setup(t)
+	defer cleanup()
+	aZip := path.Join(changedPath, "mods", "a.zip")
+	runErr := Run(nil, changedPath, "install", func(tx *Transaction) error {
+		replaceFile(t, tx, aZip, "a2")
+		return nil
+	})
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if got := fileContent(t, aZip); got != "a2" {
+		t.Errorf("a.zip = %q after the commit, want %q", got, "a2")
+	}
+	checkFinished(t)
+}
+
+func TestNestedRunJoinsParent(t *testing.T) {
+	changedPath, cleanup := setup(t)
+	defer cleanup()
+	otherPath := path.Join(path.Dir(changedPath), "other")
+	aZip := path.Join(changedPath, "mods", "a.zip")
+	otherZip := path.Join(otherPath, "other.zip")
+	runErr := Run(nil, changedPath, "install", func(tx *Transaction) error {
+		nestedErr := Run(tx, changedPath+"/", "nested install", func(nested *Transaction) error {
+			if nested != tx {
+				t.Errorf("nested Run of the same path began a new transaction")
+			}
+			replaceFile(t, nested, aZip, "a2")
+			return nil
+		})
+		if nestedErr != nil {
+			t.Fatal(nestedErr)
+		}
+		otherErr := Run(tx, otherPath, "install elsewhere", func(other *Transaction) error {
+			if other == tx {
+				t.Errorf("nested Run of another path joined the transaction")
+			}
+			replaceFile(t, other, otherZip, "other")
+			return nil
+		})
+		if otherErr != nil {
+			t.Fatal(otherErr)
+		}
+		return errTestChanges
+	})
+	if !errors.Is(runErr, errTestChanges) {
+		t.Fatalf("Run error = %v, want %v", runErr, errTestChanges)
+	}
+	if got := fileContent(t, aZip); got != "a" {
+		t.Errorf("a.zip changed by the nested run = %q, want it rolled back with its parent to %q", got, "a")
+	}
+	if got := fileContent(t, otherZip); got != "other" {
+		t.Errorf("other.zip = %q, want the separate transaction to be committed", got)
+	}
+	checkFinished(t)
+}
+
+func TestRecover(t *testing.T) {
+	changedPath, cleanup := setup(t)
+	defer cleanup()
+	aZip := path.Join(changedPath, "mods", "a.zip")
+	interrupted, beginErr := Begin(changedPath, "install")
+	if beginErr != nil {
+		t.Fatal(beginErr)
+	}
+	replaceFile(t, interrupted, aZip, "a2")
+
+	recovered, recoverErr := Recover()
+	if recoverErr != nil || len(recovered) != 0 {
+		t.Fatalf("Recover of a running transaction = %v, %v, want it left alone", recovered, recoverErr)
+	}
+	if got := fileContent(t, aZip); got != "a2" {
+		t.Fatalf("a.zip = %q, want the running transaction to keep its change", got)
+	}
+
+	// the process running the transaction exits without committing or rolling back
+	interrupted.lock.Close()
+	recovered, recoverErr = Recover()
+	if recoverErr != nil {
+		t.Fatal(recoverErr)
+	}
+	if len(recovered) != 1 || !recovered[0].RolledBack || recovered[0].Operation != "install" || recovered[0].Path != changedPath {
+		t.Errorf("Recover = %+v, want the install rolled back", recovered)
+	}
+	if got := fileContent(t, aZip); got != "a" {
+		t.Errorf("a.zip = %q after recovering, want %q", got, "a")
+	}
+	checkFinished(t)
+}
+
+func TestRecoverFinishesCommitted(t *testing.T) {
+	changedPath, cleanup := setup(t)
+	defer cleanup()
+	aZip := path.Join(changedPath, "mods", "a.zip")
+	interrupted, beginErr := Begin(changedPath, "install")
+	if beginErr != nil {
+		t.Fatal(beginErr)
+	}
+	replaceFile(t, interrupted, aZip, "a2")
+	// the process exits after marking the transaction as committed, before removing the backups
+	interrupted.journal.State = stateCommitted
+	if journalErr := interrupted.writeJournal(); journalErr != nil {
+		t.Fatal(journalErr)
+	}
+	interrupted.lock.Close()
+
+	recovered, recoverErr := Recover()
+	if recoverErr != nil {
+		t.Fatal(recoverErr)
+	}
+	if len(recovered) != 1 || recovered[0].RolledBack {
+		t.Errorf("Recover = %+v, want the install finished", recovered)
+	}
+	if got := fileContent(t, aZip); got != "a2" {
+		t.Errorf("a.zip = %q after recovering, want the committed %q", got, "a2")
+	}
+	checkFinished(t)
+}
@@ -0,0 +1,279 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownloadProgress is the state of a running download
+type DownloadProgress struct {
+	// File is the name of the file being downloaded
+	File       string `json:"file"`
+	Downloaded int64  `json:"downloaded"`
+	// Total is 0 if the server did not send the size
+	Total int64 `json:"total"`
+	// Rate is in bytes per second
+	Rate float64 `json:"rate"`
+	// ETA is 0 if the total or the rate is not known
+	ETA  time.Duration `json:"eta"`
+	Done bool          `json:"done"`
+}
+
+// DownloadProgressHandler receives the progress of the downloads
+type DownloadProgressHandler func(progress DownloadProgress)
+
+// progressInterval is how often a running download reports its progress
+const progressInterval = 100 * time.Millisecond
+
+// DefaultDownloadTimeout is how long a download waits for the server to connect or send data
+const DefaultDownloadTimeout = 30 * time.Second
+
+// downloadRetries is how many times DownloadFile tries a download
+const downloadRetries = 5
+
+// retryDelay is the wait before the first retry of a download, doubled for each following one
+const retryDelay = time.Second
+
+var downloadTimeout = DefaultDownloadTimeout
+var downloadClient = newDownloadClient(DefaultDownloadTimeout)
+
+// SetDownloadTimeout changes how long downloads wait for the server to connect or send data before they fail
+func SetDownloadTimeout(timeout time.Duration) {
+	downloadTimeout = timeout
+	downloadClient = newDownloadClient(timeout)
+}
+
+func newDownloadClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}}
+}
+
+// httpStatusError is a download the server answered with an error status
+type httpStatusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (statusErr *httpStatusError) Error() string {
+	return fmt.Sprintf("download of %s failed: %s", statusErr.url, statusErr.status)
+}
+
+// isRetryable returns false for the failures that trying again will not fix, like a missing file on the server or a local file error
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 || statusErr.code == http.StatusRequestTimeout || statusErr.code == http.StatusTooManyRequests
+	}
+	var pathErr *os.PathError
+	return !errors.As(err, &pathErr)
+}
+
+// retryWithBackoff runs the task until it succeeds or fails with an error that is not retryable, up to attempts times,
+// waiting twice as long before each retry
+func retryWithBackoff(attempts int, task func() error) error {
+	delay := retryDelay
+	var taskErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		taskErr = task()
+		if taskErr == nil || !isRetryable(taskErr) {
+			return taskErr
+		}
+	}
+	return taskErr
+}
+
+// DownloadFile will download a url to a local file. It's efficient because it will
+// write as it downloads and not load the whole file into memory.
+// The data goes to filepath.part first, which a later download resumes with a Range request, and is renamed to filepath when complete.
+// Failed downloads are tried again with exponential backoff. The progress is sent to the reporter
+func DownloadFile(reporter *Reporter, filepath string, url string) error {
+	return retryWithBackoff(downloadRetries, func() error {
+		return downloadFileOnce(reporter, filepath, url)
+	})
+}
+
+// contentRangeStart returns the first byte and the total size of a Content-Range header, -1 for a total that is not known
+func contentRangeStart(contentRange string) (int64, int64, error) {
+	var start, end int64
+	var total string
+	if _, scanErr := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); scanErr != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %s", contentRange)
+	}
+	if total == "*" {
+		return start, -1, nil
+	}
+	totalSize, parseErr := strconv.ParseInt(total, 10, 64)
+	if parseErr != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %s", contentRange)
+	}
+	return start, totalSize, nil
+}
+
+func downloadFileOnce(reporter *Reporter, filepath string, url string) error {
+	partPath := filepath + ".part"
+	var offset int64
+	if info, statErr := os.Stat(partPath); statErr == nil {
+		offset = info.Size()
+	}
+
+	// cancelled when the server stops sending data for longer than the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, requestErr := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if requestErr != nil {
+		return requestErr
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, getErr := downloadClient.Do(req)
+	if getErr != nil {
+		return getErr
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, rangeTotal, rangeErr := contentRangeStart(resp.Header.Get("Content-Range"))
+		if rangeErr != nil {
+			return rangeErr
+		}
+		if start != offset {
+			// the server sent another part, start over
+			os.Remove(partPath)
+			return fmt.Errorf("download of %s resumed at byte %d instead of %d", url, start, offset)
+		}
+		total = rangeTotal
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the part does not fit the file on the server any more, start over
+		os.Remove(partPath)
+		return fmt.Errorf("download of %s could not be resumed: %s", url, resp.Status)
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		// the server sends the whole file
+		offset = 0
+		if resp.ContentLength >= 0 {
+			total = resp.ContentLength
+		}
+	default:
+		return &httpStatusError{url, resp.Status, resp.StatusCode}
+	}
+
+	// Create the file
+	out, createErr := os.OpenFile(partPath, flags, 0644)
+	if createErr != nil {
+		return createErr
+	}
+	defer out.Close()
+
+	// Write the body to file
+	progress := DownloadProgress{File: strings.TrimSuffix(path.Base(filepath), ".tmp"), Downloaded: offset}
+	if total > 0 {
+		progress.Total = total
+	}
+	startTime := time.Now()
+	lastReport := time.Time{}
+	stallTimer := time.AfterFunc(downloadTimeout, cancel)
+	defer stallTimer.Stop()
+	buffer := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buffer)
+		stallTimer.Reset(downloadTimeout)
+		if n > 0 {
+			if _, writeErr := out.Write(buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+			progress.Downloaded += int64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("download of %s stalled for %s", url, downloadTimeout)
+			}
+			return readErr
+		}
+		if now := time.Now(); now.Sub(lastReport) >= progressInterval {
+			lastReport = now
+			if elapsed := now.Sub(startTime).Seconds(); elapsed > 0 {
+				progress.Rate = float64(progress.Downloaded-offset) / elapsed
+			}
+			if progress.Total > 0 && progress.Rate > 0 {
+				progress.ETA = time.Duration(float64(progress.Total-progress.Downloaded) / progress.Rate * float64(time.Second))
+			}
+			reporter.DownloadProgress(progress)
+		}
+	}
+	if total >= 0 && progress.Downloaded != total {
+		return fmt.Errorf("download of %s ended after %d of %d bytes", url, progress.Downloaded, total)
+	}
+	if closeErr := out.Close(); closeErr != nil {
+		return closeErr
+	}
+	progress.ETA = 0
+	progress.Done = true
+	progress.Total = progress.Downloaded
+	reporter.DownloadProgress(progress)
+	return os.Rename(partPath, filepath)
+}
+
+// ErrDownloadMismatch is returned when a downloaded file does not have the expected size or checksum
+var ErrDownloadMismatch = errors.New("downloaded file does not match the expected size or checksum")
+
+// DownloadVerifiedFile downloads a url to a temporary file next to filepath and checks its size and SHA-256 checksum.
+// Only a file that matches is renamed to filepath. An empty checksum or a size of 0 skips that check.
+// Failed downloads are tried again with exponential backoff, up to attempts times in total. The progress is sent to the reporter
+func DownloadVerifiedFile(reporter *Reporter, filepath string, url string, size int64, sha256Hash string, attempts int) error {
+	return retryWithBackoff(attempts, func() error {
+		return downloadVerifiedFileOnce(reporter, filepath, url, size, sha256Hash)
+	})
+}
+
+func downloadVerifiedFileOnce(reporter *Reporter, filepath string, url string, size int64, sha256Hash string) error {
+	tmpPath := filepath + ".tmp"
+	defer os.Remove(tmpPath)
+	downloadErr := downloadFileOnce(reporter, tmpPath, url)
+	if downloadErr != nil {
+		return downloadErr
+	}
+	if size > 0 {
+		info, statErr := os.Stat(tmpPath)
+		if statErr != nil {
+			return statErr
+		}
+		if info.Size() != size {
+			return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrDownloadMismatch, url, info.Size(), size)
+		}
+	}
+	if sha256Hash != "" {
+		hash, hashErr := Sha256File(tmpPath)
+		if hashErr != nil {
+			return hashErr
+		}
+		if !strings.EqualFold(hash, sha256Hash) {
+			return fmt.Errorf("%w: %s has SHA-256 %s, expected %s", ErrDownloadMismatch, url, hash, sha256Hash)
+		}
+	}
+	return os.Rename(tmpPath, filepath)
+}
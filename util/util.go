@@ -7,8 +7,8 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"sync"
 )
 
 // Check will print the error and exit
@@ -19,54 +19,93 @@ func Check(err error) {
 	}
 }
 
-// ReadAllFromZip reads a zip file as bytes
-func ReadAllFromZip(file *zip.File) []byte {
-	fc, openErr := file.Open()
-	Check(openErr)
-	defer fc.Close()
+// ProgressHandler receives the progress messages of long operations
+type ProgressHandler func(message string)
 
-	content, readErr := ioutil.ReadAll(fc)
-	Check(readErr)
+// Reporter sends the progress of an operation to whoever started it, like the CLI output or the stream of an API request.
+// It is passed to the operations explicitly so concurrent operations report to their own handlers. A nil reporter drops everything
+type Reporter struct {
+	// lock makes the handlers receive one report at a time, as parallel downloads report from several goroutines
+	lock     sync.Mutex
+	progress ProgressHandler
+	download DownloadProgressHandler
+}
 
-	return content
+// NewReporter creates a reporter sending to the handlers. A nil handler drops those reports
+func NewReporter(progress ProgressHandler, download DownloadProgressHandler) *Reporter {
+	return &Reporter{progress: progress, download: download}
 }
 
-// DownloadFile will download a url to a local file. It's efficient because it will
-// write as it downloads and not load the whole file into memory.
-func DownloadFile(filepath string, url string) error {
+// Progress reports a progress message of a long operation
+func (reporter *Reporter) Progress(message string) {
+	if reporter == nil || reporter.progress == nil {
+		return
+	}
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+	reporter.progress(message)
+}
 
-	// Get the data
-	resp, getErr := http.Get(url)
-	if getErr != nil {
-		return getErr
+// DownloadProgress reports the progress of a download
+func (reporter *Reporter) DownloadProgress(progress DownloadProgress) {
+	if reporter == nil || reporter.download == nil {
+		return
 	}
-	defer resp.Body.Close()
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+	reporter.download(progress)
+}
 
-	// Create the file
-	out, createErr := os.Create(filepath)
-	if createErr != nil {
-		return createErr
+// ParallelForEach runs the task for each index from 0 to count-1, at most workers at a time.
+// Returns the error of each task at its index, so the caller can handle failures in a fixed order
+func ParallelForEach(count int, workers int, task func(i int) error) []error {
+	errs := make([]error, count)
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers && worker < count; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = task(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
 	}
-	defer out.Close()
+	close(indexes)
+	wg.Wait()
+	return errs
+}
+
+// ReadAllFromZip reads a zip file as bytes
+func ReadAllFromZip(file *zip.File) ([]byte, error) {
+	fc, openErr := file.Open()
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer fc.Close()
 
-	// Write the body to file
-	_, copyErr := io.Copy(out, resp.Body)
-	return copyErr
+	return ioutil.ReadAll(fc)
 }
 
 // Sha256File calculates the checksum of the file
-func Sha256File(path string) string {
+func Sha256File(path string) (string, error) {
 	f, err := os.Open(path)
-	hasher := sha256.New()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	defer f.Close()
+	hasher := sha256.New()
 	if _, err := io.Copy(hasher, f); err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	hash := hasher.Sum(nil)
-	return hex.EncodeToString(hash)
+	return hex.EncodeToString(hash), nil
 }
 
 // Contains come on go, it is obvious what this function does
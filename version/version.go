@@ -0,0 +1,83 @@
+// Package version orders mod and SML versions, which are semver with an optional v prefix
+package version
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// Normalize removes the optional v prefix of the version
+func Normalize(ver string) string {
+	return strings.TrimPrefix(ver, "v")
+}
+
+// Parse parses the version as semver, ignoring the optional v prefix
+func Parse(ver string) (*semver.Version, error) {
+	return semver.NewVersion(Normalize(ver))
+}
+
+// Compare returns -1 if a is older than b, 0 if they are the same version, and 1 if a is newer.
+// Versions that are not valid semver are older than all valid ones, and ordered by their text among themselves
+func Compare(a string, b string) int {
+	verA, errA := Parse(a)
+	verB, errB := Parse(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(Normalize(a), Normalize(b))
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	return verA.Compare(verB)
+}
+
+// Less returns true if a is older than b
+func Less(a string, b string) bool {
+	return Compare(a, b) < 0
+}
+
+// Sort sorts the versions oldest first
+func Sort(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return Less(versions[i], versions[j])
+	})
+}
+
+// Latest returns the newest of the versions, empty if there are none
+func Latest(versions []string) string {
+	latest := ""
+	for i, ver := range versions {
+		if i == 0 || Compare(ver, latest) > 0 {
+			latest = ver
+		}
+	}
+	return latest
+}
+
+// Satisfies returns true if the version meets the constraint. Versions that are not valid semver never do, nor does an invalid constraint
+func Satisfies(ver string, versionConstraint string) bool {
+	constraint, constraintErr := semver.NewConstraint(versionConstraint)
+	if constraintErr != nil {
+		return false
+	}
+	parsed, parseErr := Parse(ver)
+	return parseErr == nil && constraint.Check(parsed)
+}
+
+// LatestSatisfying returns the newest of the versions that meets the constraint, empty if none does
+func LatestSatisfying(versions []string, versionConstraint string) (string, error) {
+	constraint, constraintErr := semver.NewConstraint(versionConstraint)
+	if constraintErr != nil {
+		return "", constraintErr
+	}
+	matching := []string{}
+	for _, ver := range versions {
+		if parsed, parseErr := Parse(ver); parseErr == nil && constraint.Check(parsed) {
+			matching = append(matching, ver)
+		}
+	}
+	return Latest(matching), nil
+}
@@ -0,0 +1,151 @@
+package version
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		ver  string
+		want string
+	}{
+		{"1.2.3", "1.2.3"},
+		{"v1.2.3", "1.2.3"},
+		{"", ""},
+		{"latest", "latest"},
+	}
+	for _, test := range tests {
+		if got := Normalize(test.ver); got != test.want {
+			t.Errorf("Normalize(%q) = %q, want %q", test.ver, got, test.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want int
+	}{
+		{"equal", "1.2.3", "1.2.3", 0},
+		{"leading v", "v1.2.3", "1.2.3", 0},
+		{"leading v on both", "v1.0.0", "v2.0.0", -1},
+		{"numeric minor", "1.10.0", "1.9.0", 1},
+		{"numeric patch", "1.0.9", "1.0.10", -1},
+		{"major", "2.0.0", "1.99.99", 1},
+		{"prerelease is older than release", "1.0.0-beta", "1.0.0", -1},
+		{"prereleases in order", "1.0.0-alpha", "1.0.0-beta", -1},
+		{"numeric prerelease", "1.0.0-rc.10", "1.0.0-rc.9", 1},
+		{"non-semver is older", "latest", "0.0.1", -1},
+		{"semver is newer", "0.0.1", "latest", 1},
+		{"non-semver by text", "abc", "abd", -1},
+		{"same non-semver", "abc", "abc", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Compare(test.a, test.b); got != test.want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+			}
+			if got := Compare(test.b, test.a); got != -test.want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", test.b, test.a, got, -test.want)
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		want     []string
+	}{
+		{"empty", []string{}, []string{}},
+		{"numeric", []string{"1.10.0", "1.9.0", "1.1.0"}, []string{"1.1.0", "1.9.0", "1.10.0"}},
+		{"leading v", []string{"v2.0.0", "1.0.0", "v1.5.0"}, []string{"1.0.0", "v1.5.0", "v2.0.0"}},
+		{"prerelease", []string{"1.0.0", "1.0.0-beta", "1.0.0-alpha"}, []string{"1.0.0-alpha", "1.0.0-beta", "1.0.0"}},
+		{"non-semver first", []string{"1.0.0", "nightly", "dev"}, []string{"dev", "nightly", "1.0.0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			versions := append([]string{}, test.versions...)
+			Sort(versions)
+			if !reflect.DeepEqual(versions, test.want) {
+				t.Errorf("Sort(%q) = %q, want %q", test.versions, versions, test.want)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		want     string
+	}{
+		{"nil", nil, ""},
+		{"empty", []string{}, ""},
+		{"single", []string{"1.0.0"}, "1.0.0"},
+		{"numeric", []string{"1.9.0", "1.10.0", "1.2.0"}, "1.10.0"},
+		{"leading v", []string{"1.0.0", "v1.1.0"}, "v1.1.0"},
+		{"release over prerelease", []string{"2.0.0-rc.1", "2.0.0", "1.0.0"}, "2.0.0"},
+		{"semver over non-semver", []string{"zzz", "0.1.0"}, "0.1.0"},
+		{"only non-semver", []string{"abc", "abd"}, "abd"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Latest(test.versions); got != test.want {
+				t.Errorf("Latest(%q) = %q, want %q", test.versions, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		ver        string
+		constraint string
+		want       bool
+	}{
+		{"1.2.3", "^1.0.0", true},
+		{"v1.2.3", "^1.0.0", true},
+		{"2.0.0", "^1.0.0", false},
+		{"1.10.0", ">=1.9.0", true},
+		{"latest", "*", false},
+		{"1.0.0", "not a constraint", false},
+	}
+	for _, test := range tests {
+		if got := Satisfies(test.ver, test.constraint); got != test.want {
+			t.Errorf("Satisfies(%q, %q) = %t, want %t", test.ver, test.constraint, got, test.want)
+		}
+	}
+}
+
+func TestLatestSatisfying(t *testing.T) {
+	tests := []struct {
+		name       string
+		versions   []string
+		constraint string
+		want       string
+		wantErr    bool
+	}{
+		{"newest match", []string{"1.0.0", "1.10.0", "1.9.0", "2.0.0"}, "^1.0.0", "1.10.0", false},
+		{"leading v", []string{"v1.0.0", "v1.1.0"}, "^1.0.0", "v1.1.0", false},
+		{"skips non-semver", []string{"latest", "1.0.0"}, "*", "1.0.0", false},
+		{"no match", []string{"1.0.0", "1.1.0"}, "^2.0.0", "", false},
+		{"empty", []string{}, "*", "", false},
+		{"invalid constraint", []string{"1.0.0"}, "not a constraint", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := LatestSatisfying(test.versions, test.constraint)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("LatestSatisfying(%q, %q) error = %v, want error %t", test.versions, test.constraint, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("LatestSatisfying(%q, %q) = %q, want %q", test.versions, test.constraint, got, test.want)
+			}
+		})
+	}
+}